@@ -4,8 +4,6 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -26,6 +24,7 @@ import (
 	"github.com/rebus2015/praktikum-devops/internal/agent"
 	"github.com/rebus2015/praktikum-devops/internal/model"
 	"github.com/rebus2015/praktikum-devops/internal/signer"
+	"github.com/rebus2015/praktikum-devops/internal/subnet"
 )
 
 type gauge float64
@@ -163,11 +162,12 @@ func (m *metricset) flushCounter(c string) {
 	m.counters[c] = 0
 }
 
-func (m *metricset) gatherJSONMetrics(key string) ([]*model.Metrics, error) {
+func (m *metricset) gatherJSONMetrics(key string, hashVersion int) ([]*model.Metrics, error) {
 	metricList := []*model.Metrics{}
 	for g := range m.gauges {
 		gmetric := m.get(Gauge, g)
 		if key != "" {
+			gmetric.HashVersion = hashVersion
 			hashObject := signer.NewHashObject(key)
 			err := hashObject.Sign(gmetric)
 			if err != nil {
@@ -182,6 +182,7 @@ func (m *metricset) gatherJSONMetrics(key string) ([]*model.Metrics, error) {
 	for c := range m.counters {
 		cmetric := m.get(Count, c)
 		if key != "" {
+			cmetric.HashVersion = hashVersion
 			hashObject := signer.NewHashObject(key)
 			err := hashObject.Sign(cmetric)
 			if err != nil {
@@ -223,33 +224,15 @@ func (m *metricset) get(mtype string, name string) *model.Metrics {
 	return &metric
 }
 
-func encrypt(d []byte, pubKey *rsa.PublicKey) ([]byte, error) {
-	rnd := rand.Reader
-	hash := sha256.New()
-	size := pubKey.Size() - 2*hash.Size() - 2
-	encripted := make([]byte, 0)
-	for i := 0; i < len(d); i += size {
-		end := i + size
-		if end > len(d) {
-			end = len(d)
-		}
-		slice := d[i:end]
-		data, err := rsa.EncryptOAEP(hash, rnd, pubKey, slice, []byte(""))
-		if err != nil {
-			return nil, fmt.Errorf("message encript error: %w", err)
-		}
-		encripted = append(encripted, data...)
-	}
-	fmt.Fprintln(os.Stdout, len(d), len(encripted))
-	return encripted, nil
-}
-
 func request(ctx context.Context, metrics []model.Metrics, cfg *agent.Config) *retryablehttp.Request {
 	queryurl := url.URL{
 		Scheme: "http",
 		Host:   cfg.ServerAddress,
 		Path:   "updates",
 	}
+	if cfg.TLS != nil {
+		queryurl.Scheme = "https"
+	}
 
 	data, err := json.Marshal(metrics)
 	if err != nil {
@@ -259,10 +242,10 @@ func request(ctx context.Context, metrics []model.Metrics, cfg *agent.Config) *r
 
 	buf := bytes.NewBuffer(data)
 	if cfg.CryptoKey != nil {
-		d, err1 := encrypt(data, cfg.CryptoKey)
+		d, err1 := signer.EncryptEnvelope(cfg.CryptoKey, data)
 		if err1 != nil {
-			log.Printf("Create Request failed! with error: %s\n", err)
-			log.Panicf("Create Request failed! with error: %s\n", err)
+			log.Printf("Create Request failed! with error: %s\n", err1)
+			log.Panicf("Create Request failed! with error: %s\n", err1)
 		}
 		buf = bytes.NewBuffer(d)
 	}
@@ -273,6 +256,18 @@ func request(ctx context.Context, metrics []model.Metrics, cfg *agent.Config) *r
 		log.Panicf("Create Request failed! with error: %v\n", err)
 	}
 	req.Header.Add("Content-type", "application/json")
+	if cfg.CryptoKey != nil {
+		req.Header.Set(signer.EncryptionHeader, signer.EncryptionHybrid)
+		req.Header.Set(signer.KeyIDHeader, signer.KeyID(cfg.CryptoKey))
+	}
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+	if ip, err := subnet.OutboundIP(cfg.ServerAddress); err == nil {
+		req.Header.Set(subnet.RealIPHeader, ip.String())
+	} else {
+		log.Printf("Error: [request] %v", err)
+	}
 
 	return req
 }
@@ -337,7 +332,20 @@ func (m *metricset) updateSendMultiple(ctx context.Context, cfg *agent.Config) e
 	client := retryablehttp.NewClient()
 	client.RetryMax = 3
 	client.RetryWaitMax = 5 * time.Second
-	metricList, err := m.gatherJSONMetrics(cfg.Key)
+	if cfg.TLS != nil {
+		if transport, ok := client.HTTPClient.Transport.(*http.Transport); ok {
+			transport.TLSClientConfig = cfg.TLS
+		}
+	}
+	switch {
+	case cfg.AgentKey != nil:
+		// собственный ключ агента: сервер проверяет подпись открытым ключом из реестра и знает отправителя
+		client.HTTPClient.Transport = signer.NewAgentSigner(cfg.AgentID, cfg.AgentKey).Transport(client.HTTPClient.Transport)
+	case cfg.Key != "":
+		// подпись запроса целиком защищает пакет метрик от повторной отправки перехваченного запроса
+		client.HTTPClient.Transport = signer.NewRequestSigner(cfg.Key).Transport(client.HTTPClient.Transport)
+	}
+	metricList, err := m.gatherJSONMetrics(cfg.Key, cfg.HashVersion)
 	if err != nil {
 		log.Printf("Error send metricList Statistic: %v,\n Values: %v", err, metricList)
 		return err
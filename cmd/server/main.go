@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	_ "net/http/pprof" // #nosec
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,8 +13,14 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/rebus2015/praktikum-devops/internal/config"
+	"github.com/rebus2015/praktikum-devops/internal/graphite"
 	"github.com/rebus2015/praktikum-devops/internal/handlers"
+	"github.com/rebus2015/praktikum-devops/internal/profiler"
+	"github.com/rebus2015/praktikum-devops/internal/rpc"
+	"github.com/rebus2015/praktikum-devops/internal/signer"
+	"github.com/rebus2015/praktikum-devops/internal/statsd"
 	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/boltstorage"
 	"github.com/rebus2015/praktikum-devops/internal/storage/dbstorage"
 	"github.com/rebus2015/praktikum-devops/internal/storage/filestorage"
 	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
@@ -53,6 +59,22 @@ func main() {
 		fs = storage.SecondaryStorage(db)
 		sqlDBStorage = dbstorage.SQLStorage(db)
 		defer sqlDBStorage.Close()
+	case cfg.SQLiteFile != "":
+		db, dberr := dbstorage.NewSQLiteStorage(ctx, cfg.SQLiteFile, cfg.StoreInterval == 0)
+		if dberr != nil {
+			log.Panicf("Error creating SQLite storage %v", dberr)
+		}
+		fs = storage.SecondaryStorage(db)
+		sqlDBStorage = dbstorage.SQLStorage(db)
+		defer sqlDBStorage.Close()
+	case cfg.BoltFile != "":
+		db, dberr := boltstorage.NewStorage(cfg.BoltFile, cfg.StoreInterval == 0)
+		if dberr != nil {
+			log.Panicf("Error creating boltstorage %v", dberr)
+		}
+		fs = storage.SecondaryStorage(db)
+		sqlDBStorage = dbstorage.SQLStorage(db)
+		defer sqlDBStorage.Close()
 	default:
 		if cfg.StoreFile != "" {
 			fs = filestorage.NewStorage(ctx, cfg)
@@ -79,6 +101,127 @@ func main() {
 	}
 	log.Printf("Created NewRepositoryWrapper: %v", cfg.ConnectionString)
 
+	statsdDone := make(chan struct{})
+	if cfg.StatsdAddress != "" {
+		listener, lerr := statsd.NewListener(cfg.StatsdAddress, cfg.StatsdFlushInterval, storage)
+		if lerr != nil {
+			log.Panicf("Error creating StatsD listener: %v", lerr)
+		}
+		log.Printf("StatsD listener started on %v, flush interval: %v", listener.Addr(), cfg.StatsdFlushInterval)
+		go func() {
+			defer close(statsdDone)
+			listener.Run(ctx)
+		}()
+	} else {
+		close(statsdDone)
+	}
+
+	graphiteDone := make(chan struct{})
+	if cfg.GraphiteAddress != "" {
+		rules, rerr := graphite.ParseRules(cfg.GraphiteRules)
+		if rerr != nil {
+			log.Panicf("Error reading Graphite rules: %v", rerr)
+		}
+		gs, gerr := graphite.NewServer(cfg.GraphiteAddress, storage, graphite.Options{
+			Rules:         rules,
+			MaxConns:      cfg.GraphiteMaxConns,
+			MaxLineLength: cfg.GraphiteMaxLineLength,
+			IdleTimeout:   cfg.GraphiteIdleTimeout,
+		})
+		if gerr != nil {
+			log.Panicf("Error creating Graphite listener: %v", gerr)
+		}
+		log.Printf("Graphite listener started on %v, rules: %d", gs.Addr(), len(rules))
+		go func() {
+			defer close(graphiteDone)
+			gs.Run(ctx)
+		}()
+	} else {
+		close(graphiteDone)
+	}
+
+	grpcDone := make(chan struct{})
+	if cfg.GRPCAddress != "" {
+		opts := rpc.Options{Key: cfg.Key, SignMode: signer.Mode(cfg.SignMode)}
+		if cfg.TLS != nil {
+			opts.TLS = cfg.TLS.Config()
+		}
+		opts.TrustedSubnet = cfg.TrustedNets
+		opts.Tokens = cfg.Tokens
+		opts.Audit = cfg.Audit
+		opts.Agents = cfg.AgentKeys
+		gs, gerr := rpc.NewServer(cfg.GRPCAddress, storage, opts)
+		if gerr != nil {
+			log.Panicf("Error creating gRPC server: %v", gerr)
+		}
+		log.Printf("gRPC server started on %v", gs.Addr())
+		go func() {
+			defer close(grpcDone)
+			gs.Run(ctx)
+		}()
+	} else {
+		close(grpcDone)
+	}
+
+	if cfg.CryptoKeys != nil {
+		log.Printf("Loaded private keys: %v", cfg.CryptoKeys.IDs())
+	}
+	if cfg.AgentKeys != nil {
+		log.Printf("Loaded agent keys: %v", cfg.AgentKeys.IDs())
+	}
+	if cfg.Tokens != nil {
+		log.Printf("Loaded access tokens: %v", cfg.Tokens.Names())
+	}
+	if cfg.CryptoKeys != nil || cfg.AgentKeys != nil || cfg.Tokens != nil || cfg.TLS != nil {
+		// SIGHUP перечитывает набор закрытых ключей, чтобы добавить новый ключ до перехода агентов на него,
+		// реестр ключей агентов и токены доступа, чтобы подключать и отзывать клиентов без перезапуска,
+		// и сертификаты TLS, если их замену нужно применить до изменения времени файлов
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		go func() {
+			for {
+				select {
+				case <-hupChan:
+					reloadKeys(cfg)
+				case <-ctx.Done():
+					signal.Stop(hupChan)
+					return
+				}
+			}
+		}()
+	}
+
+	profileDone := make(chan struct{})
+	if cfg.ProfileInterval > 0 {
+		log.Printf("Scheduled profiling every %v into '%s'", cfg.ProfileInterval, cfg.ProfileDir)
+		go func() {
+			defer close(profileDone)
+			profiler.Run(ctx, cfg.ProfileDir, cfg.ProfileInterval, cfg.ProfileCPUDuration)
+		}()
+	} else {
+		close(profileDone)
+	}
+
+	var adminSrv *http.Server
+	switch {
+	case cfg.AdminAddress != "":
+		adminSrv = &http.Server{
+			Addr:        cfg.AdminAddress,
+			ReadTimeout: fileReadTimeout,
+			Handler:     handlers.NewAdminRouter(),
+		}
+		log.Printf("Profiler listener started on %v", cfg.AdminAddress)
+		go func() {
+			if aerr := adminSrv.ListenAndServe(); aerr != nil && !errors.Is(aerr, http.ErrServerClosed) {
+				log.Printf("Error: [main] profiler listener: %v", aerr)
+			}
+		}()
+	case cfg.Profiler && cfg.Tokens == nil:
+		log.Printf("Profiler is served on the main port without authentication, configure tokens or -admin")
+	case cfg.Profiler:
+		log.Printf("Profiler is served on the main port, admin scope required")
+	}
+
 	r := handlers.NewRouter(storage, sqlDBStorage, *cfg)
 	srv := &http.Server{
 		Addr:         cfg.ServerAddress,
@@ -86,6 +229,8 @@ func main() {
 		WriteTimeout: fileWriteTimeout,
 		Handler:      r,
 	}
+	// потоки SSE и WebSocket завершаются при закрытии брокера, иначе Shutdown ждал бы их бесконечно
+	srv.RegisterOnShutdown(storage.Stream().Close)
 	idleConnsClosed := make(chan struct{})
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan,
@@ -98,9 +243,28 @@ func main() {
 			// ошибки закрытия Listener
 			log.Printf("HTTP server Shutdown: %v", err)
 		}
+		if adminSrv != nil {
+			if err := adminSrv.Shutdown(context.Background()); err != nil {
+				log.Printf("Profiler listener Shutdown: %v", err)
+			}
+		}
+		// останавливаем gRPC-сервер, приемники StatsD и Graphite, профилирование по расписанию
+		// и дожидаемся сохранения накопленных значений
+		cancel()
+		<-grpcDone
+		<-statsdDone
+		<-graphiteDone
+		<-profileDone
 		close(idleConnsClosed)
 	}()
-	err = srv.ListenAndServe()
+	if cfg.TLS != nil {
+		// сертификат выбирается из cfg.TLS при каждом рукопожатии, файлы в ListenAndServeTLS не нужны
+		srv.TLSConfig = cfg.TLS.Config()
+		log.Printf("HTTPS enabled, mutual TLS: %v", cfg.TLS.MutualTLS())
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	log.Printf("server started \n address:%v \n database:%v,\n restore interval: %v ",
 		cfg.ServerAddress, cfg.ConnectionString, cfg.StoreInterval)
 
@@ -110,3 +274,36 @@ func main() {
 	<-idleConnsClosed
 	fmt.Println("Server Shutdown gracefully")
 }
+
+// reloadKeys перечитывает закрытые ключи сервера, реестр ключей агентов, токены доступа и сертификаты TLS.
+// При ошибке продолжает использоваться прежний набор ключей.
+func reloadKeys(cfg *config.Config) {
+	if cfg.CryptoKeys != nil {
+		if err := cfg.CryptoKeys.Reload(); err != nil {
+			log.Printf("Error: [main] reload private keys: %v", err)
+		} else {
+			log.Printf("Reloaded private keys: %v", cfg.CryptoKeys.IDs())
+		}
+	}
+	if cfg.AgentKeys != nil {
+		if err := cfg.AgentKeys.Reload(); err != nil {
+			log.Printf("Error: [main] reload agent keys: %v", err)
+		} else {
+			log.Printf("Reloaded agent keys: %v", cfg.AgentKeys.IDs())
+		}
+	}
+	if cfg.Tokens != nil {
+		if err := cfg.Tokens.Reload(); err != nil {
+			log.Printf("Error: [main] reload access tokens: %v", err)
+		} else {
+			log.Printf("Reloaded access tokens: %v", cfg.Tokens.Names())
+		}
+	}
+	if cfg.TLS != nil {
+		if err := cfg.TLS.Reload(); err != nil {
+			log.Printf("Error: [main] reload TLS certificates: %v", err)
+		} else {
+			log.Printf("Reloaded TLS certificates")
+		}
+	}
+}
@@ -2,7 +2,9 @@
 package agent
 
 import (
+	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -15,6 +17,9 @@ import (
 
 	"github.com/caarlos0/env"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/signer"
+	"github.com/rebus2015/praktikum-devops/internal/tlsconfig"
 )
 
 var (
@@ -28,10 +33,26 @@ type Config struct {
 	CryptoKeyFile  string        `env:"CRYPTO_KEY" json:"crypto_key,omitempty"` // Путь к файлу с открытым ключом
 	confFile       string        `env:"CONFIG" json:"-"`
 	Key            string        `env:"KEY" json:"-"`                        // Ключ для подписи данных
+	HashVersion    int           `env:"HASH_VERSION" json:"-"`               // Формат подписи метрик (signer.HashV1, signer.HashV2)
 	ReportInterval time.Duration `env:"PUSH_TIMEOUT" json:"report_interval"` // Интервал отправки метрик на сервер
 	PollInterval   time.Duration `env:"POLL_INTERVAL" json:"poll_interval"`  // Интервал сбора метрик
 	RateLimit      int           `env:"RATE_LIMIT" json:"-"`                 // Количество одновременных запросов
 
+	// AgentID идентификатор агента в реестре ключей сервера, по умолчанию имя хоста
+	AgentID string `env:"AGENT_ID" json:"agent_id"`
+	// AgentKeyFile путь к закрытому ключу Ed25519 агента, заменяет подпись запросов общим ключом
+	AgentKeyFile string             `env:"AGENT_KEY" json:"agent_key"`
+	AgentKey     ed25519.PrivateKey // ключ из AgentKeyFile
+	// Token токен доступа к серверу со scope write
+	Token string `env:"TOKEN" json:"-"`
+	// UseTLS включает HTTPS с системными удостоверяющими центрами, TLSCAFile и TLSCertFile включают его неявно
+	UseTLS bool `env:"TLS" json:"tls"`
+	// TLSCAFile единственный доверенный удостоверяющий центр сервера (закрепление CA)
+	TLSCAFile string `env:"TLS_CA" json:"tls_ca"`
+	// TLSCertFile и TLSKeyFile клиентский сертификат агента для mTLS
+	TLSCertFile string      `env:"TLS_CERT" json:"tls_cert"`
+	TLSKeyFile  string      `env:"TLS_KEY" json:"tls_key"`
+	TLS         *tls.Config // настройки TLS, nil — обычный HTTP
 }
 
 func GetConfig() (*Config, error) {
@@ -43,7 +64,16 @@ func GetConfig() (*Config, error) {
 	flag.DurationVar(&conf.PollInterval, "p", defPollInterval, "Interval between metrics reads from runtime")
 	flag.StringVar(&conf.Key, "k", "", "Key to sign up data with SHA256 algorythm")
 	flag.IntVar(&conf.RateLimit, "l", 5, "Workers count")
+	flag.IntVar(&conf.HashVersion, "hash-version", signer.HashV2,
+		"Metric signature format: 2 - canonical, 1 - legacy for servers without v2 support")
 	flag.StringVar(&conf.CryptoKeyFile, "crypto-key", "", "Public Key file address")
+	flag.StringVar(&conf.AgentID, "agent-id", "", "Agent ID registered on the server, hostname by default")
+	flag.StringVar(&conf.AgentKeyFile, "agent-key", "", "Agent Ed25519 private key PEM file, replaces -k request signature")
+	flag.StringVar(&conf.Token, "token", "", "Server access token with write scope")
+	flag.BoolVar(&conf.UseTLS, "tls", false, "Connect to the server over HTTPS")
+	flag.StringVar(&conf.TLSCAFile, "tls-ca", "", "CA certificate PEM file to trust instead of system roots, enables HTTPS")
+	flag.StringVar(&conf.TLSCertFile, "tls-cert", "", "Client TLS certificate PEM file for mutual TLS, enables HTTPS")
+	flag.StringVar(&conf.TLSKeyFile, "tls-key", "", "Client TLS private key PEM file")
 	flag.Parse()
 
 	err := env.Parse(&conf)
@@ -55,9 +85,19 @@ func GetConfig() (*Config, error) {
 		return nil, fmt.Errorf("error reading agent config(Json): %w", err)
 	}
 
+	if !signer.SupportedHashVersion(conf.HashVersion) {
+		return nil, fmt.Errorf("error reading agent config: %w: %d", signer.ErrHashVersion, conf.HashVersion)
+	}
+
 	if err = conf.getCryptoKey(); err != nil {
 		return nil, fmt.Errorf("error reading agent config, failed to get CryptoKey: %w", err)
 	}
+	if err = conf.getAgentKey(); err != nil {
+		return nil, fmt.Errorf("error reading agent config, failed to get agent key: %w", err)
+	}
+	if err = conf.getTLS(); err != nil {
+		return nil, fmt.Errorf("error reading agent config, failed to get TLS settings: %w", err)
+	}
 
 	return &conf, err
 }
@@ -68,6 +108,12 @@ func (c *Config) UnmarshalJSON(data []byte) (err error) {
 		ReportInterval string `json:"report_interval"`
 		PollInterval   string `json:"poll_interval"`
 		CryptoKeyFile  string `json:"crypto_key"`
+		AgentID        string `json:"agent_id"`
+		AgentKeyFile   string `json:"agent_key"`
+		UseTLS         bool   `json:"tls"`
+		TLSCAFile      string `json:"tls_ca"`
+		TLSCertFile    string `json:"tls_cert"`
+		TLSKeyFile     string `json:"tls_key"`
 	}
 
 	if err = json.Unmarshal(data, &conf); err != nil {
@@ -83,6 +129,12 @@ func (c *Config) UnmarshalJSON(data []byte) (err error) {
 		return fmt.Errorf("time.ParceDuration PollInterval failed with error: ,%w", err)
 	}
 	c.CryptoKeyFile = conf.CryptoKeyFile
+	c.AgentID = conf.AgentID
+	c.AgentKeyFile = conf.AgentKeyFile
+	c.UseTLS = conf.UseTLS
+	c.TLSCAFile = conf.TLSCAFile
+	c.TLSCertFile = conf.TLSCertFile
+	c.TLSKeyFile = conf.TLSKeyFile
 	return nil
 }
 
@@ -123,6 +175,24 @@ func (c *Config) parseConfigFile() error {
 	if c.CryptoKeyFile == "" {
 		c.CryptoKeyFile = cfg.CryptoKeyFile
 	}
+	if c.AgentID == "" {
+		c.AgentID = cfg.AgentID
+	}
+	if c.AgentKeyFile == "" {
+		c.AgentKeyFile = cfg.AgentKeyFile
+	}
+	if !c.UseTLS {
+		c.UseTLS = cfg.UseTLS
+	}
+	if c.TLSCAFile == "" {
+		c.TLSCAFile = cfg.TLSCAFile
+	}
+	if c.TLSCertFile == "" {
+		c.TLSCertFile = cfg.TLSCertFile
+	}
+	if c.TLSKeyFile == "" {
+		c.TLSKeyFile = cfg.TLSKeyFile
+	}
 	return nil
 }
 
@@ -168,3 +238,32 @@ func (c *Config) getCryptoKey() error {
 	c.CryptoKey = cKey
 	return nil
 }
+
+func (c *Config) getAgentKey() error {
+	if c.AgentKeyFile == "" {
+		return nil
+	}
+	key, err := signer.LoadAgentKey(c.AgentKeyFile)
+	if err != nil {
+		return err
+	}
+	if c.AgentID == "" {
+		if c.AgentID, err = os.Hostname(); err != nil {
+			return fmt.Errorf("agent id is not set and hostname is unavailable: %w", err)
+		}
+	}
+	c.AgentKey = key
+	return nil
+}
+
+func (c *Config) getTLS() error {
+	if !c.UseTLS && c.TLSCAFile == "" && c.TLSCertFile == "" && c.TLSKeyFile == "" {
+		return nil
+	}
+	tlsConfig, err := tlsconfig.NewClient(c.TLSCAFile, c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return err
+	}
+	c.TLS = tlsConfig
+	return nil
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Причины отклонения запросов, значения Event.Reason. Общие для HTTP и gRPC.
+const (
+	ReasonNoToken          string = "missing_token"
+	ReasonInvalidToken     string = "invalid_token"
+	ReasonScope            string = "insufficient_scope"
+	ReasonMetricForbidden  string = "metric_forbidden"
+	ReasonPrefixRestricted string = "prefix_restricted"
+)
+
+// Event запись журнала аудита об отклоненном запросе.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Reason    string    `json:"reason"`
+	Token     string    `json:"token,omitempty"` // имя токена, если он распознан
+	Scope     Scope     `json:"scope,omitempty"`
+	Metric    string    `json:"metric,omitempty"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Remote    string    `json:"remote"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// AuditLog журнал отклоненных запросов в формате JSON Lines.
+//
+// Файл открывается на время каждой записи, поэтому его можно ротировать без перезапуска сервера.
+// Без файла записи выводятся в основной лог.
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewAuditLog создает журнал аудита в файле path. Пустой path направляет записи в основной лог.
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Record добавляет событие в журнал. Ошибки записи выводятся в основной лог.
+func (a *AuditLog) Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error: [AuditLog] marshal event error: %v", err)
+		return
+	}
+	if a.path == "" {
+		log.Printf("Audit: %s", line)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err = appendLine(a.path, line); err != nil {
+		log.Printf("Error: [AuditLog] %v, event: %s", err, line)
+	}
+}
+
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	return nil
+}
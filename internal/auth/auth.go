@@ -0,0 +1,196 @@
+// Package auth выполняет аутентификацию клиентов сервиса метрик по токенам доступа.
+//
+// Токены хранятся в JSON-файле только в виде SHA-256, каждому токену назначаются области
+// доступа (write, read, admin) и, при необходимости, префиксы имен доступных метрик.
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Scope область доступа токена.
+type Scope string
+
+const (
+	// ScopeWrite запись метрик.
+	ScopeWrite Scope = "write"
+	// ScopeRead чтение метрик.
+	ScopeRead Scope = "read"
+	// ScopeAdmin служебные эндпоинты (/debug), включает read и write.
+	ScopeAdmin Scope = "admin"
+)
+
+// APIKeyHeader заголовок с токеном для клиентов, не поддерживающих схему Bearer.
+const APIKeyHeader string = "X-API-Key"
+
+var (
+	ErrNoToken      = errors.New("access token is required")
+	ErrInvalidToken = errors.New("invalid access token")
+	ErrForbidden    = errors.New("access denied")
+)
+
+// Token описание токена в файле токенов.
+//
+//	[{"name": "agent-1", "sha256": "<hex>", "scopes": ["write"], "prefixes": ["web_"]}]
+//
+// Хэш токена можно получить командой printf '%s' "$TOKEN" | sha256sum.
+type Token struct {
+	Name     string   `json:"name"`
+	SHA256   string   `json:"sha256"`
+	Scopes   []Scope  `json:"scopes"`
+	Prefixes []string `json:"prefixes,omitempty"` // пустой список разрешает все метрики
+}
+
+// Allows сообщает, разрешена ли токену область scope.
+func (t *Token) Allows(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsMetric сообщает, разрешен ли токену доступ к метрике name.
+func (t *Token) AllowsMetric(name string) bool {
+	if len(t.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range t.Prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Restricted сообщает, ограничен ли токен префиксами имен метрик.
+func (t *Token) Restricted() bool {
+	return len(t.Prefixes) > 0
+}
+
+// HashToken возвращает SHA-256 токена в hex, в том виде, в каком он хранится в файле токенов.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// FromRequest извлекает токен из заголовка Authorization (схема Bearer) или APIKeyHeader.
+func FromRequest(r *http.Request) string {
+	return FromValues(r.Header.Get("Authorization"), r.Header.Get(APIKeyHeader))
+}
+
+// FromValues извлекает токен из значений Authorization и APIKeyHeader: они же передаются
+// в метаданных gRPC. Authorization с другой схемой, чем Bearer, дает пустой токен.
+func FromValues(authorization, apiKey string) string {
+	if authorization != "" {
+		if scheme, token, ok := strings.Cut(authorization, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return apiKey
+}
+
+// Store набор токенов из файла. Reload перечитывает файл без перезапуска сервера.
+type Store struct {
+	path   string
+	mu     sync.RWMutex
+	tokens []Token
+}
+
+// NewStore загружает токены из JSON-файла path.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload перечитывает файл токенов. При ошибке набор токенов не меняется.
+func (s *Store) Reload() error {
+	tokens, err := loadTokens(s.path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.tokens = tokens
+	s.mu.Unlock()
+	return nil
+}
+
+// Names возвращает имена токенов в порядке файла.
+func (s *Store) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, len(s.tokens))
+	for i := range s.tokens {
+		names[i] = s.tokens[i].Name
+	}
+	return names
+}
+
+// Authenticate возвращает описание токена token. Хэши сравниваются за постоянное время.
+func (s *Store) Authenticate(token string) (*Token, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+	sum := sha256.Sum256([]byte(token))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := range s.tokens {
+		want, _ := hex.DecodeString(s.tokens[i].SHA256)
+		if subtle.ConstantTimeCompare(sum[:], want) == 1 {
+			t := s.tokens[i]
+			return &t, nil
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+func loadTokens(path string) ([]Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("token file: %w", err)
+	}
+	var tokens []Token
+	if err = json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("token file '%s': %w", path, err)
+	}
+	names := make(map[string]bool, len(tokens))
+	for i := range tokens {
+		t := &tokens[i]
+		if t.Name == "" {
+			return nil, fmt.Errorf("token file '%s': token %d has no name", path, i)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("token file '%s': duplicate token name '%s'", path, t.Name)
+		}
+		names[t.Name] = true
+		t.SHA256 = strings.ToLower(t.SHA256)
+		if raw, herr := hex.DecodeString(t.SHA256); herr != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("token file '%s': token '%s' has malformed sha256", path, t.Name)
+		}
+		if len(t.Scopes) == 0 {
+			return nil, fmt.Errorf("token file '%s': token '%s' has no scopes", path, t.Name)
+		}
+		for _, scope := range t.Scopes {
+			switch scope {
+			case ScopeWrite, ScopeRead, ScopeAdmin:
+			default:
+				return nil, fmt.Errorf("token file '%s': token '%s' has unknown scope '%s'", path, t.Name, scope)
+			}
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTokens(t *testing.T, tokens string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(name, []byte(tokens), 0o600))
+	return name
+}
+
+func TestStore(t *testing.T) {
+	name := writeTokens(t, `[
+		{"name": "agent", "sha256": "`+HashToken("agent-secret")+`", "scopes": ["write"], "prefixes": ["web_"]},
+		{"name": "ops", "sha256": "`+HashToken("ops-secret")+`", "scopes": ["admin"]}
+	]`)
+	store, err := NewStore(name)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"agent", "ops"}, store.Names())
+
+	agent, err := store.Authenticate("agent-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "agent", agent.Name)
+	assert.True(t, agent.Allows(ScopeWrite))
+	assert.False(t, agent.Allows(ScopeRead))
+	assert.True(t, agent.Restricted())
+	assert.True(t, agent.AllowsMetric("web_requests"))
+	assert.False(t, agent.AllowsMetric("db_requests"))
+
+	ops, err := store.Authenticate("ops-secret")
+	require.NoError(t, err)
+	assert.True(t, ops.Allows(ScopeRead))
+	assert.True(t, ops.Allows(ScopeWrite))
+	assert.True(t, ops.AllowsMetric("db_requests"))
+
+	_, err = store.Authenticate("")
+	assert.ErrorIs(t, err, ErrNoToken)
+	_, err = store.Authenticate("guess")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// некорректный файл не заменяет загруженные токены
+	require.NoError(t, os.WriteFile(name, []byte(`[{"name": "x"}]`), 0o600))
+	assert.Error(t, store.Reload())
+	_, err = store.Authenticate("agent-secret")
+	assert.NoError(t, err)
+}
+
+func TestNewStore_invalid(t *testing.T) {
+	hash := HashToken("secret")
+	tests := []struct {
+		name   string
+		tokens string
+	}{
+		{"not json", `{`},
+		{"no name", `[{"sha256": "` + hash + `", "scopes": ["read"]}]`},
+		{"duplicate name", `[{"name": "a", "sha256": "` + hash + `", "scopes": ["read"]},
+			{"name": "a", "sha256": "` + hash + `", "scopes": ["write"]}]`},
+		{"short hash", `[{"name": "a", "sha256": "abcd", "scopes": ["read"]}]`},
+		{"no scopes", `[{"name": "a", "sha256": "` + hash + `"}]`},
+		{"unknown scope", `[{"name": "a", "sha256": "` + hash + `", "scopes": ["root"]}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStore(writeTokens(t, tt.tokens))
+			assert.Error(t, err)
+		})
+	}
+	_, err := NewStore(filepath.Join(t.TempDir(), "none.json"))
+	assert.Error(t, err)
+}
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{"bearer", "Authorization", "Bearer abc", "abc"},
+		{"bearer lower case", "Authorization", "bearer abc", "abc"},
+		{"other scheme", "Authorization", "Basic abc", ""},
+		{"api key", APIKeyHeader, "abc", "abc"},
+		{"none", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			assert.Equal(t, tt.want, FromRequest(r))
+		})
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.log")
+	audit := NewAuditLog(name)
+	audit.Record(Event{Reason: "invalid_token", Method: "POST", Path: "/update/", Remote: "10.0.0.1"})
+	audit.Record(Event{Reason: "forbidden", Token: "agent", Scope: ScopeRead, Method: "GET", Path: "/"})
+
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		events = append(events, e)
+	}
+	require.Len(t, events, 2)
+	assert.Equal(t, "invalid_token", events[0].Reason)
+	assert.False(t, events[0].Time.IsZero())
+	assert.Equal(t, "agent", events[1].Token)
+	assert.Equal(t, ScopeRead, events[1].Scope)
+}
@@ -0,0 +1,114 @@
+// Package codec кодирует метрики в форматы, доступные в /api/v2: JSON, MessagePack и Protobuf.
+//
+// Формат запроса выбирается по заголовку Content-Type, формат ответа — по Accept.
+// Protobuf-схема описана в metrics.proto.
+package codec
+
+import (
+	"errors"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+)
+
+const (
+	JSONContentType     string = "application/json"
+	MsgpackContentType  string = "application/msgpack"
+	ProtobufContentType string = "application/x-protobuf"
+)
+
+// ErrDecode тело не соответствует формату.
+var ErrDecode = errors.New("malformed message")
+
+// Metric метрика в ответах и запросах /api/v2. NotFound заполняется в ответе на запрос значений.
+type Metric struct {
+	model.Metrics
+	NotFound bool `json:"not_found,omitempty"`
+}
+
+// Codec кодирует одиночные метрики и списки метрик.
+type Codec interface {
+	// ContentType основной тип содержимого формата.
+	ContentType() string
+	Marshal(m *Metric) ([]byte, error)
+	Unmarshal(data []byte, m *Metric) error
+	MarshalList(metrics []Metric) ([]byte, error)
+	UnmarshalList(data []byte) ([]Metric, error)
+}
+
+var (
+	jsonCodec     Codec = JSON{}
+	msgpackCodec  Codec = Msgpack{}
+	protobufCodec Codec = Protobuf{}
+
+	// byMediaType соответствие типов содержимого форматам, включая распространенные синонимы.
+	byMediaType = map[string]Codec{
+		JSONContentType:                   jsonCodec,
+		MsgpackContentType:                msgpackCodec,
+		"application/x-msgpack":           msgpackCodec,
+		"application/vnd.msgpack":         msgpackCodec,
+		ProtobufContentType:               protobufCodec,
+		"application/protobuf":            protobufCodec,
+		"application/vnd.google.protobuf": protobufCodec,
+	}
+)
+
+// ForContentType возвращает формат по значению заголовка Content-Type.
+// Пустой заголовок означает JSON.
+func ForContentType(contentType string) (Codec, bool) {
+	if strings.TrimSpace(contentType) == "" {
+		return jsonCodec, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	c, ok := byMediaType[mediaType]
+	return c, ok
+}
+
+// Negotiate выбирает формат ответа по заголовку Accept с учетом весов q.
+// Если заголовок пуст или допускает любой тип, возвращается fallback — обычно формат запроса.
+func Negotiate(accept string, fallback Codec) (Codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return fallback, true
+	}
+	type candidate struct {
+		codec Codec
+		q     float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		if q == 0 {
+			continue
+		}
+		var c Codec
+		switch mediaType {
+		case "*/*", "application/*":
+			c = fallback
+		default:
+			c = byMediaType[mediaType]
+		}
+		if c != nil {
+			candidates = append(candidates, candidate{codec: c, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].codec, true
+}
@@ -0,0 +1,119 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestCodecs_roundTrip(t *testing.T) {
+	metrics := []Metric{
+		{Metrics: model.Metrics{ID: "Alloc", MType: "gauge", Value: ptr(-1.5), Hash: "abc"}},
+		{Metrics: model.Metrics{ID: "PollCount", MType: "counter", Delta: ptr(int64(-42)), Hash: "def", HashVersion: 2}},
+		{Metrics: model.Metrics{ID: "zero", MType: "counter", Delta: ptr(int64(0))}},
+		{Metrics: model.Metrics{ID: "missing", MType: "gauge"}, NotFound: true},
+	}
+	for _, c := range []Codec{JSON{}, Msgpack{}, Protobuf{}} {
+		t.Run(c.ContentType(), func(t *testing.T) {
+			data, err := c.MarshalList(metrics)
+			require.NoError(t, err)
+			got, err := c.UnmarshalList(data)
+			require.NoError(t, err)
+			assert.Equal(t, metrics, got)
+
+			for i := range metrics {
+				data, err = c.Marshal(&metrics[i])
+				require.NoError(t, err)
+				var m Metric
+				require.NoError(t, c.Unmarshal(data, &m))
+				assert.Equal(t, metrics[i], m)
+			}
+
+			empty, err := c.MarshalList(nil)
+			require.NoError(t, err)
+			got, err = c.UnmarshalList(empty)
+			require.NoError(t, err)
+			assert.Empty(t, got)
+
+			_, err = c.UnmarshalList([]byte{0xff, 0xff, 0xff})
+			assert.ErrorIs(t, err, ErrDecode)
+		})
+	}
+}
+
+func TestMsgpack_fieldNames(t *testing.T) {
+	data, err := Msgpack{}.Marshal(&Metric{Metrics: model.Metrics{ID: "a", MType: "counter", Delta: ptr(int64(1))}})
+	require.NoError(t, err)
+	// map из трех элементов с ключами как в JSON, пустые поля не передаются
+	assert.Equal(t, []byte("\x83\xa2id\xa1a\xa4type\xa7counter\xa5delta\x01"), data)
+}
+
+func TestProtobuf_wireFormat(t *testing.T) {
+	m := Metric{Metrics: model.Metrics{ID: "a", MType: "c", Delta: ptr(int64(-1)), Value: ptr(1.0)}}
+	data, err := Protobuf{}.Marshal(&m)
+	require.NoError(t, err)
+	want := []byte{
+		0x0a, 0x01, 'a', // 1: id
+		0x12, 0x01, 'c', // 2: type
+		0x18, 0x01, // 3: delta, zigzag(-1)
+		0x21, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, // 4: value, double 1.0
+	}
+	assert.Equal(t, want, data)
+
+	// неизвестные поля пропускаются
+	var got Metric
+	require.NoError(t, Protobuf{}.Unmarshal(append([]byte{0x78, 0x05}, data...), &got))
+	assert.Equal(t, m, got)
+}
+
+func TestForContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        Codec
+		ok          bool
+	}{
+		{"", JSON{}, true},
+		{"application/json; charset=utf-8", JSON{}, true},
+		{"application/x-msgpack", Msgpack{}, true},
+		{"application/x-protobuf", Protobuf{}, true},
+		{"text/plain", nil, false},
+		{";;", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			got, ok := ForContentType(tt.contentType)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   Codec
+		ok     bool
+	}{
+		{"", Msgpack{}, true},
+		{"*/*", Msgpack{}, true},
+		{"application/json", JSON{}, true},
+		{"application/json;q=0.5, application/x-protobuf", Protobuf{}, true},
+		{"application/x-protobuf;q=0, application/json;q=0.1", JSON{}, true},
+		{"text/html, application/*;q=0.2", Msgpack{}, true},
+		{"text/html", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			got, ok := Negotiate(tt.accept, Msgpack{})
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JSON формат application/json, совпадающий с форматом /api/v1.
+type JSON struct{}
+
+func (JSON) ContentType() string {
+	return JSONContentType
+}
+
+func (JSON) Marshal(m *Metric) ([]byte, error) {
+	return json.Marshal(m)
+}
+
+func (JSON) Unmarshal(data []byte, m *Metric) error {
+	if err := json.Unmarshal(data, m); err != nil {
+		return fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	return nil
+}
+
+func (JSON) MarshalList(metrics []Metric) ([]byte, error) {
+	if metrics == nil {
+		metrics = []Metric{}
+	}
+	return json.Marshal(metrics)
+}
+
+func (JSON) UnmarshalList(data []byte) ([]Metric, error) {
+	var metrics []Metric
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	return metrics, nil
+}
@@ -0,0 +1,59 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Msgpack формат application/msgpack. Имена полей совпадают с JSON.
+type Msgpack struct{}
+
+func (Msgpack) ContentType() string {
+	return MsgpackContentType
+}
+
+func (Msgpack) Marshal(m *Metric) ([]byte, error) {
+	return msgpackEncode(m)
+}
+
+func (Msgpack) Unmarshal(data []byte, m *Metric) error {
+	return msgpackDecode(data, m)
+}
+
+func (Msgpack) MarshalList(metrics []Metric) ([]byte, error) {
+	if metrics == nil {
+		metrics = []Metric{}
+	}
+	return msgpackEncode(metrics)
+}
+
+func (Msgpack) UnmarshalList(data []byte) ([]Metric, error) {
+	var metrics []Metric
+	if err := msgpackDecode(data, &metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+func msgpackEncode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("msgpack encode: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func msgpackDecode(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	return nil
+}
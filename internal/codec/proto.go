@@ -0,0 +1,156 @@
+package codec
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Номера полей сообщений из metrics.proto.
+const (
+	fieldID       protowire.Number = 1
+	fieldType     protowire.Number = 2
+	fieldDelta    protowire.Number = 3
+	fieldValue    protowire.Number = 4
+	fieldHash     protowire.Number = 5
+	fieldNotFound protowire.Number = 6
+	fieldHashVer  protowire.Number = 7
+
+	fieldMetrics protowire.Number = 1
+)
+
+// Protobuf формат application/x-protobuf: одиночная метрика — сообщение Metric,
+// список — сообщение MetricList.
+type Protobuf struct{}
+
+func (Protobuf) ContentType() string {
+	return ProtobufContentType
+}
+
+func (Protobuf) Marshal(m *Metric) ([]byte, error) {
+	return appendMetric(nil, m), nil
+}
+
+func (Protobuf) Unmarshal(data []byte, m *Metric) error {
+	*m = Metric{}
+	return consumeMetric(data, m)
+}
+
+func (Protobuf) MarshalList(metrics []Metric) ([]byte, error) {
+	var b []byte
+	for i := range metrics {
+		b = protowire.AppendTag(b, fieldMetrics, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendMetric(nil, &metrics[i]))
+	}
+	return b, nil
+}
+
+func (Protobuf) UnmarshalList(data []byte) ([]Metric, error) {
+	metrics := []Metric{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protoError(n)
+		}
+		data = data[n:]
+		if num != fieldMetrics || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, data); n < 0 {
+				return nil, protoError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, protoError(n)
+		}
+		data = data[n:]
+		var m Metric
+		if err := consumeMetric(v, &m); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+func appendMetric(b []byte, m *Metric) []byte {
+	if m.ID != "" {
+		b = protowire.AppendTag(b, fieldID, protowire.BytesType)
+		b = protowire.AppendString(b, m.ID)
+	}
+	if m.MType != "" {
+		b = protowire.AppendTag(b, fieldType, protowire.BytesType)
+		b = protowire.AppendString(b, m.MType)
+	}
+	if m.Delta != nil {
+		b = protowire.AppendTag(b, fieldDelta, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeZigZag(*m.Delta))
+	}
+	if m.Value != nil {
+		b = protowire.AppendTag(b, fieldValue, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(*m.Value))
+	}
+	if m.Hash != "" {
+		b = protowire.AppendTag(b, fieldHash, protowire.BytesType)
+		b = protowire.AppendString(b, m.Hash)
+	}
+	if m.NotFound {
+		b = protowire.AppendTag(b, fieldNotFound, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if m.HashVersion != 0 {
+		b = protowire.AppendTag(b, fieldHashVer, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(uint32(m.HashVersion)))
+	}
+	return b
+}
+
+// consumeMetric разбирает сообщение Metric. Неизвестные поля пропускаются.
+func consumeMetric(b []byte, m *Metric) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protoError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == fieldID && typ == protowire.BytesType:
+			m.ID, n = protowire.ConsumeString(b)
+		case num == fieldType && typ == protowire.BytesType:
+			m.MType, n = protowire.ConsumeString(b)
+		case num == fieldHash && typ == protowire.BytesType:
+			m.Hash, n = protowire.ConsumeString(b)
+		case num == fieldDelta && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			delta := protowire.DecodeZigZag(v)
+			m.Delta = &delta
+		case num == fieldValue && typ == protowire.Fixed64Type:
+			var v uint64
+			v, n = protowire.ConsumeFixed64(b)
+			value := math.Float64frombits(v)
+			m.Value = &value
+		case num == fieldNotFound && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			m.NotFound = v != 0
+		case num == fieldHashVer && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			m.HashVersion = int(uint32(v))
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protoError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func protoError(n int) error {
+	return fmt.Errorf("%w: %v", ErrDecode, protowire.ParseError(n))
+}
@@ -3,15 +3,18 @@
 package config
 
 import (
-	"crypto/rsa"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/signer"
 )
 
 func TestGetConfig(t *testing.T) {
@@ -23,12 +26,19 @@ func TestGetConfig(t *testing.T) {
 		{
 			name: "test 1",
 			want: &Config{
-				ServerAddress:    "127.0.0.1:8080",
-				StoreInterval:    time.Second * 30,
-				StoreFile:        "",
-				Restore:          false,
-				Key:              "",
-				ConnectionString: "",
+				ServerAddress:         "127.0.0.1:8080",
+				StoreInterval:         time.Second * 30,
+				StoreFile:             "",
+				Restore:               false,
+				Key:                   "",
+				ConnectionString:      "",
+				StatsdFlushInterval:   time.Second * 10,
+				GraphiteMaxConns:      100,
+				GraphiteMaxLineLength: 4096,
+				GraphiteIdleTimeout:   time.Minute,
+				SignatureMaxSkew:      signer.DefaultMaxSkew,
+				ProfileDir:            "profiles",
+				ProfileCPUDuration:    time.Second * 10,
 			},
 			wantErr: false,
 		},
@@ -47,7 +57,7 @@ func TestGetConfig(t *testing.T) {
 
 func TestConfig_UnmarshalJSON(t *testing.T) {
 	type fields struct {
-		CryptoKey        *rsa.PrivateKey
+		CryptoKeys       *signer.Keyring
 		ServerAddress    string
 		StoreFile        string
 		Key              string
@@ -122,7 +132,7 @@ func TestConfig_UnmarshalJSON(t *testing.T) {
 				ConnectionString: tt.fields.ConnectionString,
 				CryptoKeyFile:    tt.fields.CryptoKeyFile,
 				confFile:         tt.fields.confFile,
-				CryptoKey:        tt.fields.CryptoKey,
+				CryptoKeys:       tt.fields.CryptoKeys,
 			}
 			var err error
 			if err = c.UnmarshalJSON(tt.args.data); (err != nil) != tt.wantErr {
@@ -141,14 +151,14 @@ func TestConfig_UnmarshalJSON(t *testing.T) {
 			assert.Equal(t, tt.fields.ConnectionString, c.ConnectionString)
 			assert.Equal(t, tt.fields.CryptoKeyFile, c.CryptoKeyFile)
 			assert.Equal(t, tt.fields.confFile, c.confFile)
-			assert.Equal(t, tt.fields.CryptoKey, c.CryptoKey)
+			assert.Equal(t, tt.fields.CryptoKeys, c.CryptoKeys)
 		})
 	}
 }
 
 func TestConfig_parseConfigFile(t *testing.T) {
 	type fields struct {
-		CryptoKey        *rsa.PrivateKey
+		CryptoKeys       *signer.Keyring
 		ServerAddress    string
 		StoreFile        string
 		Key              string
@@ -234,7 +244,7 @@ func TestConfig_parseConfigFile(t *testing.T) {
 				ConnectionString: tt.f.ConnectionString,
 				CryptoKeyFile:    tt.f.CryptoKeyFile,
 				confFile:         tt.f.confFile,
-				CryptoKey:        tt.f.CryptoKey,
+				CryptoKeys:       tt.f.CryptoKeys,
 			}
 			if strings.Contains(tt.name, "no file") {
 				c.confFile = ""
@@ -265,7 +275,7 @@ func TestConfig_parseConfigFile(t *testing.T) {
 				assert.Equal(t, tt.wantf.Key, c.Key)
 				assert.Equal(t, tt.wantf.ConnectionString, c.ConnectionString)
 				assert.Equal(t, tt.wantf.CryptoKeyFile, c.CryptoKeyFile)
-				assert.Equal(t, tt.wantf.CryptoKey, c.CryptoKey)
+				assert.Equal(t, tt.wantf.CryptoKeys, c.CryptoKeys)
 			}
 		})
 	}
@@ -289,7 +299,7 @@ kVpKJJ4IyDiPV8+6A3VAlxvSpjVusaR7Jq8VDiHBKEf5jQ==
 
 func TestConfig_getCryptoKey(t *testing.T) {
 	type fields struct {
-		CryptoKey        *rsa.PrivateKey
+		CryptoKeys       *signer.Keyring
 		ServerAddress    string
 		StoreFile        string
 		Key              string
@@ -367,7 +377,7 @@ func TestConfig_getCryptoKey(t *testing.T) {
 
 			c := &Config{
 				CryptoKeyFile: tt.fields.CryptoKeyFile,
-				CryptoKey:     tt.fields.CryptoKey,
+				CryptoKeys:    tt.fields.CryptoKeys,
 			}
 			if err = c.getCryptoKey(); (err != nil) != tt.wantErr {
 				t.Errorf("Config.getCryptoKey() error = %v, wantErr %v", err, tt.wantErr)
@@ -377,10 +387,50 @@ func TestConfig_getCryptoKey(t *testing.T) {
 				return
 			}
 			if tt.fields.CryptoKeyFile == "" {
-				assert.True(t, c.CryptoKey == nil)
+				assert.True(t, c.CryptoKeys == nil)
 				return
 			}
-			assert.True(t, c.CryptoKey != nil)
+			assert.True(t, c.CryptoKeys != nil)
 		})
 	}
 }
+
+func TestConfig_parseConfigFile_flagDefaults(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "conf.json")
+	require.NoError(t, os.WriteFile(name, []byte(`{
+		"store_interval": "1s",
+		"statsd_flush_interval": "3s",
+		"graphite_max_conns": 10,
+		"graphite_max_line_length": 512,
+		"graphite_idle_timeout": "30s",
+		"profile_dir": "/var/lib/devops/profiles",
+		"profile_cpu_duration": "5s",
+		"signature_max_skew": "2m"
+	}`), 0o600))
+
+	// значения по умолчанию флагов не перекрывают файл конфигурации
+	c := &Config{
+		confFile:              name,
+		StatsdFlushInterval:   time.Second * 10,
+		GraphiteMaxConns:      100,
+		GraphiteMaxLineLength: 4096,
+		GraphiteIdleTimeout:   time.Minute,
+		ProfileDir:            "profiles",
+		ProfileCPUDuration:    time.Second * 10,
+		SignatureMaxSkew:      signer.DefaultMaxSkew,
+	}
+	require.NoError(t, c.parseConfigFile())
+	assert.Equal(t, time.Second*3, c.StatsdFlushInterval)
+	assert.Equal(t, 10, c.GraphiteMaxConns)
+	assert.Equal(t, 512, c.GraphiteMaxLineLength)
+	assert.Equal(t, time.Second*30, c.GraphiteIdleTimeout)
+	assert.Equal(t, "/var/lib/devops/profiles", c.ProfileDir)
+	assert.Equal(t, time.Second*5, c.ProfileCPUDuration)
+	assert.Equal(t, time.Minute*2, c.SignatureMaxSkew)
+
+	// явно заданная переменная окружения важнее файла
+	t.Setenv("STATSD_FLUSH_INTERVAL", "5s")
+	c = &Config{confFile: name, StatsdFlushInterval: time.Second * 5}
+	require.NoError(t, c.parseConfigFile())
+	assert.Equal(t, time.Second*5, c.StatsdFlushInterval)
+}
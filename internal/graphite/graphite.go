@@ -0,0 +1,202 @@
+// Package graphite реализует TCP-приемник метрик в текстовом протоколе Graphite.
+//
+// Каждая строка имеет вид
+//
+//	path value timestamp
+//
+// Значения сохраняются как метрики типа gauge, имя метрики формируется по правилам Rule.
+// Для каждого соединения действуют ограничения на длину строки, время простоя
+// и общее число одновременных соединений.
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+)
+
+const (
+	gauge string = "gauge"
+
+	defaultMaxConns      int           = 100
+	defaultMaxLineLength int           = 4096
+	defaultIdleTimeout   time.Duration = time.Minute
+	batchSize            int           = 500
+)
+
+var ErrMalformed = errors.New("malformed graphite line")
+
+// Options ограничения приемника. Нулевые значения заменяются значениями по умолчанию.
+type Options struct {
+	Rules         []Rule
+	MaxConns      int           // максимальное число одновременных соединений
+	MaxLineLength int           // максимальная длина строки, при превышении соединение закрывается
+	IdleTimeout   time.Duration // соединение закрывается, если за это время не пришло ни одной строки
+}
+
+// Server принимает TCP-соединения и сохраняет полученные метрики в хранилище.
+type Server struct {
+	repo     storage.Repository
+	listener net.Listener
+	sem      chan struct{}
+	conns    map[net.Conn]struct{}
+	mux      sync.Mutex
+	wg       sync.WaitGroup
+	opts     Options
+}
+
+// NewServer открывает TCP-сокет на адресе addr.
+func NewServer(addr string, repo storage.Repository, opts Options) (*Server, error) {
+	if opts.MaxConns <= 0 {
+		opts.MaxConns = defaultMaxConns
+	}
+	if opts.MaxLineLength <= 0 {
+		opts.MaxLineLength = defaultMaxLineLength
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = defaultIdleTimeout
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("graphite listen on '%s' error: %w", addr, err)
+	}
+	return &Server{
+		repo:     repo,
+		listener: ln,
+		sem:      make(chan struct{}, opts.MaxConns),
+		conns:    map[net.Conn]struct{}{},
+		opts:     opts,
+	}, nil
+}
+
+// Addr адрес, на котором приемник ожидает соединения.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Run принимает соединения до отмены ctx, затем закрывает открытые соединения и дожидается их обработки.
+func (s *Server) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		if err := s.listener.Close(); err != nil {
+			log.Printf("graphite listener close error: %v", err)
+		}
+		s.mux.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mux.Unlock()
+	}()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				break
+			}
+			log.Printf("graphite accept error: %v", err)
+			continue
+		}
+		select {
+		case s.sem <- struct{}{}:
+		default:
+			log.Printf("graphite: connection limit %d reached, rejecting %v", s.opts.MaxConns, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		s.mux.Lock()
+		s.conns[conn] = struct{}{}
+		if ctx.Err() != nil {
+			// остановка началась после Accept: соединение уже не будет закрыто обработчиком ctx
+			conn.Close()
+		}
+		s.mux.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+			s.mux.Lock()
+			delete(s.conns, conn)
+			s.mux.Unlock()
+			conn.Close()
+			<-s.sem
+		}()
+	}
+	s.wg.Wait()
+}
+
+func (s *Server) serve(conn net.Conn) {
+	reader := bufio.NewReaderSize(conn, s.opts.MaxLineLength+1)
+	batch := make([]*model.Metrics, 0, batchSize)
+	defer func() {
+		s.save(batch)
+	}()
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(s.opts.IdleTimeout)); err != nil {
+			log.Printf("graphite set deadline error: %v", err)
+			return
+		}
+		line, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			log.Printf("graphite: line longer than %d bytes from %v, closing connection",
+				s.opts.MaxLineLength, conn.RemoteAddr())
+			return
+		}
+		if len(line) > 0 {
+			m, perr := ParseLine(string(line), s.opts.Rules)
+			if perr != nil {
+				log.Printf("graphite: %v from %v", perr, conn.RemoteAddr())
+			} else {
+				batch = append(batch, m)
+			}
+		}
+		if err != nil {
+			// EOF, истечение времени простоя или закрытие соединения при остановке
+			return
+		}
+		// сохраняем пачку, когда входные данные временно закончились или пачка заполнена
+		if len(batch) == batchSize || reader.Buffered() == 0 {
+			s.save(batch)
+			batch = make([]*model.Metrics, 0, batchSize)
+		}
+	}
+}
+
+func (s *Server) save(batch []*model.Metrics) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := s.repo.AddMetrics(batch); err != nil {
+		log.Printf("graphite save error: %v", err)
+	}
+}
+
+// ParseLine разбирает строку вида "path value timestamp".
+func ParseLine(line string, rules []Rule) (*model.Metrics, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("%w: '%s'", ErrMalformed, strings.TrimSpace(line))
+	}
+	path, valStr, tsStr := fields[0], fields[1], fields[2]
+	value, err := strconv.ParseFloat(valStr, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("%w: bad value '%s'", ErrMalformed, valStr)
+	}
+	// -1 означает время получения, дробные отметки времени допускаются некоторыми клиентами
+	if _, err = strconv.ParseFloat(tsStr, 64); err != nil {
+		return nil, fmt.Errorf("%w: bad timestamp '%s'", ErrMalformed, tsStr)
+	}
+	return &model.Metrics{ID: MetricID(rules, path), MType: gauge, Value: &value}, nil
+}
@@ -0,0 +1,144 @@
+package graphite
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		wantID  string
+		wantVal float64
+		wantErr bool
+	}{
+		{line: "servers.a.load 1.5 1700000000\n", wantID: "servers.a.load", wantVal: 1.5},
+		{line: "x  -3   -1", wantID: "x", wantVal: -3},
+		{line: "x 1", wantErr: true},
+		{line: "x abc 1700000000", wantErr: true},
+		{line: "x NaN 1700000000", wantErr: true},
+		{line: "x 1 yesterday", wantErr: true},
+		{line: "x 1 2 3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			m, err := ParseLine(tt.line, nil)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrMalformed)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantID, m.ID)
+			assert.Equal(t, gauge, m.MType)
+			assert.Equal(t, tt.wantVal, *m.Value)
+		})
+	}
+}
+
+func startServer(t *testing.T, opts Options) (*Server, storage.Repository, func()) {
+	t.Helper()
+	repo := storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	s, err := NewServer("127.0.0.1:0", repo, opts)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+	return s, repo, func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("graphite server did not stop")
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	rules, err := ParseRules([]string{"servers.*.cpu=cpu;host=$1"})
+	require.NoError(t, err)
+	s, repo, stop := startServer(t, Options{Rules: rules})
+	defer stop()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = fmt.Fprint(conn, "servers.web01.cpu 42 1700000000\nbroken line\nload 0.5 -1\n")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	assert.Eventually(t, func() bool {
+		_, err := repo.GetGauge("load")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	cpu, err := repo.GetGauge(`cpu{host="web01"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 42.0, cpu)
+}
+
+func TestServer_limits(t *testing.T) {
+	s, repo, stop := startServer(t, Options{MaxConns: 1, MaxLineLength: 32, IdleTimeout: 200 * time.Millisecond})
+	defer stop()
+
+	first, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	_, err = fmt.Fprint(first, "ok 1 -1\n")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := repo.GetGauge("ok")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// второе соединение сверх лимита закрывается сервером
+	second, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	require.NoError(t, second.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = second.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// слишком длинная строка закрывает соединение, метрика не сохраняется
+	_, err = fmt.Fprintf(first, "%s 1 -1\n", strings.Repeat("x", 64))
+	require.NoError(t, err)
+	require.NoError(t, first.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = first.Read(make([]byte, 1))
+	assert.Error(t, err) // EOF или RST: в буфере сервера остались непрочитанные данные
+	_, err = repo.GetGauge(strings.Repeat("x", 64))
+	assert.Error(t, err)
+
+	// после закрытия соединения слот освобождается; простаивающее соединение закрывается по таймауту
+	var idle net.Conn
+	assert.Eventually(t, func() bool {
+		idle, err = net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			return false
+		}
+		_, err = fmt.Fprint(idle, "after 2 -1\n")
+		if err != nil {
+			idle.Close()
+			return false
+		}
+		if _, err = repo.GetGauge("after"); err != nil {
+			idle.Close()
+			return false
+		}
+		return true
+	}, time.Second, 20*time.Millisecond)
+	defer idle.Close()
+	require.NoError(t, idle.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = idle.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,156 @@
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+)
+
+var ErrBadRule = errors.New("bad graphite rule")
+
+// Rule сопоставляет путь Graphite с именем метрики.
+//
+// Правило задается строкой pattern=template, например
+//
+//	servers.*.cpu.*=cpu_$2;host=$1
+//
+// Шаблон пути делится на сегменты по точке, сегмент * совпадает с любым одним сегментом пути.
+// В template $N заменяется на сегмент, совпавший с N-й звездочкой (нумерация с 1), $0 — весь путь.
+// Части template после ';' в виде key=value становятся метками метрики.
+type Rule struct {
+	pattern []string
+	name    string
+	labels  []model.Label
+}
+
+// ParseRules разбирает набор правил. Правила применяются в порядке задания, срабатывает первое совпавшее.
+func ParseRules(specs []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		r, err := parseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func parseRule(spec string) (Rule, error) {
+	pattern, template, ok := strings.Cut(spec, "=")
+	if !ok || pattern == "" || template == "" {
+		return Rule{}, fmt.Errorf("%w '%s': expected pattern=template", ErrBadRule, spec)
+	}
+	r := Rule{pattern: strings.Split(pattern, ".")}
+	wildcards := 0
+	for _, seg := range r.pattern {
+		if seg == "" {
+			return Rule{}, fmt.Errorf("%w '%s': empty path segment", ErrBadRule, spec)
+		}
+		if seg == "*" {
+			wildcards++
+		}
+	}
+	parts := strings.Split(template, ";")
+	r.name = parts[0]
+	if r.name == "" {
+		return Rule{}, fmt.Errorf("%w '%s': empty metric name", ErrBadRule, spec)
+	}
+	for _, p := range parts[1:] {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok || k == "" || v == "" {
+			return Rule{}, fmt.Errorf("%w '%s': bad label '%s'", ErrBadRule, spec, p)
+		}
+		r.labels = append(r.labels, model.Label{Name: k, Value: v})
+	}
+	if n := maxReference(template); n > wildcards {
+		return Rule{}, fmt.Errorf("%w '%s': $%d refers to missing wildcard", ErrBadRule, spec, n)
+	}
+	return r, nil
+}
+
+// match возвращает сегменты, совпавшие со звездочками шаблона.
+func (r *Rule) match(segments []string) ([]string, bool) {
+	if len(segments) != len(r.pattern) {
+		return nil, false
+	}
+	captures := []string{}
+	for i, p := range r.pattern {
+		switch {
+		case p == "*":
+			captures = append(captures, segments[i])
+		case p != segments[i]:
+			return nil, false
+		}
+	}
+	return captures, true
+}
+
+// MetricID возвращает идентификатор метрики для пути. Если ни одно правило не совпало,
+// идентификатором служит сам путь.
+func MetricID(rules []Rule, path string) string {
+	segments := strings.Split(path, ".")
+	for i := range rules {
+		captures, ok := rules[i].match(segments)
+		if !ok {
+			continue
+		}
+		expand := func(s string) string { return expandTemplate(s, path, captures) }
+		labels := make([]model.Label, len(rules[i].labels))
+		for j, l := range rules[i].labels {
+			labels[j] = model.Label{Name: l.Name, Value: expand(l.Value)}
+		}
+		return model.FormatID(expand(rules[i].name), labels)
+	}
+	return path
+}
+
+func expandTemplate(s, path string, captures []string) string {
+	if !strings.Contains(s, "$") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		j := i + 1
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		if s[i] != '$' || j == i+1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		n, _ := strconv.Atoi(s[i+1 : j])
+		if n == 0 {
+			b.WriteString(path)
+		} else {
+			b.WriteString(captures[n-1])
+		}
+		i = j - 1
+	}
+	return b.String()
+}
+
+func maxReference(s string) int {
+	maxIndex := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			continue
+		}
+		j := i + 1
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		if n, err := strconv.Atoi(s[i+1 : j]); err == nil && n > maxIndex {
+			maxIndex = n
+		}
+		i = j - 1
+	}
+	return maxIndex
+}
@@ -0,0 +1,54 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricID(t *testing.T) {
+	rules, err := ParseRules([]string{
+		"servers.*.cpu.*=cpu_$2;host=$1",
+		"collectd.*.memory.*=mem.$2",
+		"collectd.*.*=collectd_$2;source=$0",
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"servers.web01.cpu.idle", `cpu_idle{host="web01"}`},
+		{"collectd.node1.memory.free", "mem.free"},
+		{"collectd.node1.load", `collectd_load{source="collectd.node1.load"}`},
+		{"servers.web01.cpu", "servers.web01.cpu"},
+		{"unmatched", "unmatched"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, MetricID(rules, tt.path))
+		})
+	}
+}
+
+func TestParseRules_errors(t *testing.T) {
+	tests := []string{
+		"servers.*",
+		"=name",
+		"servers..cpu=name",
+		"servers.*=;host=$1",
+		"servers.*=cpu;host",
+		"servers.*=cpu_$2",
+	}
+	for _, spec := range tests {
+		t.Run(spec, func(t *testing.T) {
+			_, err := ParseRules([]string{spec})
+			assert.ErrorIs(t, err, ErrBadRule)
+		})
+	}
+
+	rules, err := ParseRules([]string{"", " "})
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+}
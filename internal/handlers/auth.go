@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/auth"
+	"github.com/rebus2015/praktikum-devops/internal/config"
+)
+
+// tokenContextKey токен, которым аутентифицирован запрос (tokenAuth).
+type tokenContextKey struct{}
+
+// tokenAuth результат аутентификации запроса.
+type tokenAuth struct {
+	token *auth.Token
+	authn *authenticator
+}
+
+// authenticator проверяет токены доступа и записывает отклоненные запросы в журнал аудита.
+// Нулевой указатель означает, что аутентификация отключена.
+type authenticator struct {
+	tokens *auth.Store
+	audit  *auth.AuditLog
+}
+
+// newAuthenticator создает authenticator по конфигурации. Без файла токенов возвращает nil.
+func newAuthenticator(cfg config.Config) *authenticator {
+	if cfg.Tokens == nil {
+		return nil
+	}
+	audit := cfg.Audit
+	if audit == nil {
+		audit = auth.NewAuditLog(cfg.AuditLogFile)
+	}
+	return &authenticator{tokens: cfg.Tokens, audit: audit}
+}
+
+// require пропускает запросы с токеном, которому разрешена область scope.
+func (a *authenticator) require(scope auth.Scope) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if a == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := a.tokens.Authenticate(auth.FromRequest(r))
+			if err != nil {
+				reason := auth.ReasonInvalidToken
+				if errors.Is(err, auth.ErrNoToken) {
+					reason = auth.ReasonNoToken
+				}
+				a.record(r, reason, nil, scope, "")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, err.Error())
+				return
+			}
+			if !token.Allows(scope) {
+				a.record(r, auth.ReasonScope, token, scope, "")
+				writeProblem(w, r, http.StatusForbidden, codeForbidden,
+					fmt.Sprintf("Token '%s' has no '%s' scope", token.Name, scope))
+				return
+			}
+			ctx := context.WithValue(r.Context(), tokenContextKey{}, tokenAuth{token: token, authn: a})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requireAllMetrics отклоняет запросы с токеном, ограниченным префиксами имен метрик.
+// Применяется к эндпоинтам, возвращающим все метрики сразу.
+func (a *authenticator) requireAllMetrics(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := requestToken(r); token != nil && token.Restricted() {
+			a.record(r, auth.ReasonPrefixRestricted, token, "", "")
+			writeProblem(w, r, http.StatusForbidden, codeForbidden,
+				fmt.Sprintf("Token '%s' is restricted to metric prefixes %v", token.Name, token.Prefixes))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *authenticator) record(r *http.Request, reason string, token *auth.Token, scope auth.Scope, metric string) {
+	e := auth.Event{
+		Reason:    reason,
+		Scope:     scope,
+		Metric:    metric,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Remote:    r.RemoteAddr,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+	if token != nil {
+		e.Token = token.Name
+	}
+	a.audit.Record(e)
+}
+
+// requestToken возвращает токен, которым аутентифицирован запрос, или nil.
+func requestToken(r *http.Request) *auth.Token {
+	ta, _ := r.Context().Value(tokenContextKey{}).(tokenAuth)
+	return ta.token
+}
+
+// authorizeMetric проверяет, что токен запроса разрешает доступ к метрике name.
+// Запросы без аутентификации (она отключена) не ограничиваются.
+func authorizeMetric(r *http.Request, name string) error {
+	ta, ok := r.Context().Value(tokenContextKey{}).(tokenAuth)
+	if !ok || ta.token.AllowsMetric(name) {
+		return nil
+	}
+	ta.authn.record(r, auth.ReasonMetricForbidden, ta.token, "", name)
+	log.Printf("Error: [authorizeMetric] token '%s' has no access to metric '%s'", ta.token.Name, name)
+	return fmt.Errorf("%w: metric '%s'", auth.ErrForbidden, name)
+}
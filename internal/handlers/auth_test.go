@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/auth"
+	"github.com/rebus2015/praktikum-devops/internal/config"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+func TestTokenAuth(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "tokens.json")
+	require.NoError(t, os.WriteFile(tokenFile, []byte(`[
+		{"name": "agent", "sha256": "`+auth.HashToken("agent-secret")+`", "scopes": ["write"], "prefixes": ["web_"]},
+		{"name": "viewer", "sha256": "`+auth.HashToken("viewer-secret")+`", "scopes": ["read"]},
+		{"name": "ops", "sha256": "`+auth.HashToken("ops-secret")+`", "scopes": ["admin"]}
+	]`), 0o600))
+	tokens, err := auth.NewStore(tokenFile)
+	require.NoError(t, err)
+	auditFile := filepath.Join(dir, "audit.log")
+
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	cfg := config.Config{Tokens: tokens, AuditLogFile: auditFile, SignMode: "permissive", Profiler: true}
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{isOpened: true}, cfg))
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  string
+		status int
+		code   string
+	}{
+		{"no token", http.MethodPost, "/update/gauge/web_load/1", "", "", http.StatusUnauthorized, codeUnauthorized},
+		{"unknown token", http.MethodGet, "/", "", "guess", http.StatusUnauthorized, codeUnauthorized},
+		{"write allowed prefix", http.MethodPost, "/update/gauge/web_load/1", "", "agent-secret", http.StatusOK, ""},
+		{"write other prefix", http.MethodPost, "/update/gauge/db_load/1", "", "agent-secret", http.StatusForbidden, codeForbidden},
+		{"json write other prefix", http.MethodPost, "/update/", `{"id":"db_load","type":"gauge","value":1}`,
+			"agent-secret", http.StatusForbidden, codeForbidden},
+		{"batch other prefix", http.MethodPost, "/updates/", `[{"id":"db_load","type":"gauge","value":1}]`,
+			"agent-secret", http.StatusBadRequest, codeInvalidBatch},
+		{"write without scope", http.MethodPost, "/update/gauge/web_load/1", "", "viewer-secret", http.StatusForbidden, codeForbidden},
+		{"read without scope", http.MethodGet, "/value/gauge/web_load", "", "agent-secret", http.StatusForbidden, codeForbidden},
+		{"read", http.MethodGet, "/value/gauge/web_load", "", "viewer-secret", http.StatusOK, ""},
+		{"list", http.MethodGet, "/api/v1/metrics", "", "viewer-secret", http.StatusOK, ""},
+		{"debug without admin", http.MethodGet, "/debug/vars", "", "viewer-secret", http.StatusForbidden, codeForbidden},
+		{"debug", http.MethodGet, "/debug/vars", "", "ops-secret", http.StatusOK, ""},
+		{"admin writes", http.MethodPost, "/update/gauge/db_load/2", "", "ops-secret", http.StatusOK, ""},
+		{"ping is public", http.MethodGet, "/ping", "", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set(keyCT, keyValueJSON)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			data, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode, string(data))
+			if tt.code != "" {
+				assertProblem(t, data, tt.status, tt.code)
+			}
+			if tt.status == http.StatusUnauthorized {
+				assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	_, err = metricStorage.GetGauge("db_load")
+	assert.NoError(t, err, "admin token is not restricted by prefixes")
+
+	f, err := os.Open(auditFile)
+	require.NoError(t, err)
+	defer f.Close()
+	reasons := map[string]int{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e auth.Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		assert.NotEmpty(t, e.RequestID)
+		reasons[e.Reason]++
+	}
+	assert.Equal(t, map[string]int{
+		auth.ReasonNoToken:         1,
+		auth.ReasonInvalidToken:    1,
+		auth.ReasonMetricForbidden: 3,
+		auth.ReasonScope:           3,
+	}, reasons)
+}
+
+func TestTokenAuth_prefixRestricted(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(tokenFile, []byte(`[
+		{"name": "web", "sha256": "`+auth.HashToken("web-secret")+`", "scopes": ["read"], "prefixes": ["web_"]}
+	]`), 0o600))
+	tokens, err := auth.NewStore(tokenFile)
+	require.NoError(t, err)
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	r := NewRouter(metricStorage, &sqlStorageMock{}, config.Config{Tokens: tokens})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/metrics", nil)
+	req.Header.Set(auth.APIKeyHeader, "web-secret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assertProblem(t, w.Body.Bytes(), http.StatusForbidden, codeForbidden)
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+const (
+	dashboardTemplateFile string = "index.html"
+	dashboardRefresh      int    = 10 // период автообновления страницы, секунды
+	sparklinePoints       int    = 30
+	sparklineWidth        int    = 100
+	sparklineHeight       int    = 20
+)
+
+//go:embed dashboard/index.html
+var dashboardFS embed.FS
+
+// dashboardRow строка таблицы метрик.
+type dashboardRow struct {
+	Updated time.Time // нулевое значение, если время обновления неизвестно
+	ID      string
+	Value   string
+	History []float64 // значения из буфера потока изменений, от старых к новым
+}
+
+type dashboardTable struct {
+	Title string
+	Type  string
+	Rows  []dashboardRow
+}
+
+type dashboardPage struct {
+	Generated      time.Time
+	Title          string
+	Counters       []dashboardRow
+	Gauges         []dashboardRow
+	RefreshSeconds int
+}
+
+// loadDashboardTemplate разбирает шаблон страницы. Если задан каталог dir, шаблон index.html
+// берется из него, иначе используется встроенный.
+func loadDashboardTemplate(dir string) (*template.Template, error) {
+	var source fs.FS = dashboardFS
+	pattern := "dashboard/" + dashboardTemplateFile
+	if dir != "" {
+		source = os.DirFS(dir)
+		pattern = dashboardTemplateFile
+	}
+	tmpl, err := template.New("dashboard").Funcs(template.FuncMap{
+		"sparkline": sparkline,
+		"tableData": func(title, mtype string, rows []dashboardRow) dashboardTable {
+			return dashboardTable{Title: title, Type: mtype, Rows: rows}
+		},
+	}).ParseFS(source, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("parse dashboard template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// GetAllHandler возвращает html-страницу со всеми метриками.
+// templateDir задает каталог с шаблоном index.html вместо встроенного.
+func GetAllHandler(
+	metricStorage storage.Repository,
+	templateDir string,
+) func(w http.ResponseWriter, r *http.Request) {
+	tmpl, tmplErr := loadDashboardTemplate(templateDir)
+	if tmplErr != nil {
+		log.Printf("Error: [GetAllHandler] %v", tmplErr)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tmplErr != nil {
+			writeProblem(w, r, http.StatusInternalServerError, codeInternal, "Dashboard template is not available")
+			return
+		}
+		page, err := metricStorage.QueryMetrics(memstorage.Query{WithUpdated: true})
+		if err != nil {
+			log.Printf("Error: [GetAllHandler] query metrics error: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, codeStorage, "Failed to read metrics")
+			return
+		}
+
+		history := map[string][]float64{}
+		for _, e := range metricStorage.Stream().Backlog() {
+			key := e.Metric.MType + ":" + e.Metric.ID
+			switch {
+			case e.Metric.Delta != nil:
+				history[key] = append(history[key], float64(*e.Metric.Delta))
+			case e.Metric.Value != nil:
+				history[key] = append(history[key], *e.Metric.Value)
+			}
+		}
+
+		data := dashboardPage{
+			Title:          "Метрики",
+			Generated:      time.Now(),
+			RefreshSeconds: dashboardRefresh,
+		}
+		for i, m := range page.Metrics {
+			row := dashboardRow{ID: m.ID, Updated: page.Updated[i], History: history[m.MType+":"+m.ID]}
+			if len(row.History) > sparklinePoints {
+				row.History = row.History[len(row.History)-sparklinePoints:]
+			}
+			switch {
+			case m.Delta != nil:
+				row.Value = strconv.FormatInt(*m.Delta, 10)
+				data.Counters = append(data.Counters, row)
+			case m.Value != nil:
+				row.Value = strconv.FormatFloat(*m.Value, 'f', -1, 64)
+				data.Gauges = append(data.Gauges, row)
+			}
+		}
+
+		w.Header().Set(keyCT, "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if err = tmpl.ExecuteTemplate(w, "dashboard", data); err != nil {
+			log.Printf("Error: [GetAllHandler] execute template: %v", err)
+		}
+	}
+}
+
+// sparkline строит svg-график значений. Для менее чем двух точек график не выводится.
+func sparkline(values []float64) template.HTML {
+	if len(values) < 2 {
+		return ""
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	span := hi - lo
+	var points strings.Builder
+	for i, v := range values {
+		x := float64(i) * float64(sparklineWidth) / float64(len(values)-1)
+		y := float64(sparklineHeight) / 2
+		if span > 0 && !math.IsInf(span, 0) {
+			y = float64(sparklineHeight) - (v-lo)/span*float64(sparklineHeight)
+		}
+		if math.IsNaN(y) || math.IsInf(y, 0) {
+			y = float64(sparklineHeight) / 2
+		}
+		if i > 0 {
+			points.WriteByte(' ')
+		}
+		fmt.Fprintf(&points, "%.1f,%.1f", x, y)
+	}
+	// #nosec G203 -- разметка формируется из чисел, пользовательские данные не попадают в строку
+	return template.HTML(fmt.Sprintf(
+		`<svg class="spark" viewBox="0 0 %d %d" preserveAspectRatio="none"><polyline points="%s"/></svg>`,
+		sparklineWidth, sparklineHeight, points.String()))
+}
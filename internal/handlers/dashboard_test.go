@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/config"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+func getDashboard(t *testing.T, metricStorage storage.Repository, cfg config.Config) (int, string) {
+	t.Helper()
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, cfg))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestGetAllHandler_dashboard(t *testing.T) {
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	for _, v := range []string{"1", "2", "3"} {
+		_, err := metricStorage.AddCounter("PollCount", v)
+		require.NoError(t, err)
+	}
+	_, err := metricStorage.AddGauge(`<script>alert(1)</script>`, "1.25")
+	require.NoError(t, err)
+
+	status, body := getDashboard(t, metricStorage, config.Config{})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "<title>Метрики</title>")
+
+	counters := strings.Index(body, `data-type="counter"`)
+	gauges := strings.Index(body, `data-type="gauge"`)
+	require.True(t, counters > 0 && gauges > counters, "counters table must precede gauges table")
+	assert.Contains(t, body[counters:gauges], `<td class="value">6</td>`)
+	assert.Contains(t, body[counters:gauges], `<svg class="spark"`, "counter with history has a sparkline")
+	assert.Contains(t, body[gauges:], `<td class="value">1.25</td>`)
+	assert.NotContains(t, body[gauges:], `<svg class="spark"`, "single value has no sparkline")
+	assert.Contains(t, body, "<time datetime=")
+	assert.NotContains(t, body, "<script>alert(1)</script>")
+}
+
+func TestGetAllHandler_templateDir(t *testing.T) {
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	_, err := metricStorage.AddGauge("Alloc", "5")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	custom := `{{define "dashboard"}}custom:{{range .Gauges}}{{.ID}}={{.Value}};{{end}}{{end}}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte(custom), 0o600))
+	status, body := getDashboard(t, metricStorage, config.Config{TemplateDir: dir})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "custom:Alloc=5;", body)
+
+	status, _ = getDashboard(t, metricStorage, config.Config{TemplateDir: filepath.Join(dir, "missing")})
+	assert.Equal(t, http.StatusInternalServerError, status)
+}
+
+func Test_sparkline(t *testing.T) {
+	assert.Empty(t, sparkline(nil))
+	assert.Empty(t, sparkline([]float64{1}))
+	assert.Contains(t, string(sparkline([]float64{0, 10})), `points="0.0,20.0 100.0,0.0"`)
+	assert.Contains(t, string(sparkline([]float64{3, 3, 3})), `points="0.0,10.0 50.0,10.0 100.0,10.0"`)
+}
@@ -8,8 +8,8 @@ import (
 	"context"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"net/http"
 
@@ -17,41 +17,27 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/rebus2015/praktikum-devops/internal/auth"
 	"github.com/rebus2015/praktikum-devops/internal/config"
+	"github.com/rebus2015/praktikum-devops/internal/influx"
 	"github.com/rebus2015/praktikum-devops/internal/model"
 	"github.com/rebus2015/praktikum-devops/internal/signer"
 	"github.com/rebus2015/praktikum-devops/internal/storage"
 	"github.com/rebus2015/praktikum-devops/internal/storage/dbstorage"
 )
 
-// Шаблон html-страницы для вывода всех имеющихся метрик.
-const templ = `{{define "metrics"}}
-<!doctype html>
-<html lang="ru" class="h-100">
-<head>
-    <title>Title</title>
-</head>
-<body class="d-flex flex-column h-100">
-    <table class="table">
-        <th>name</th>
-        <th>value</th>
-        {{range .}}
-        <tr>
-            <td>{{.Name}}</td>
-            <td>{{.Val}}</td>
-        </tr>
-        {{end}}
-    </table>
-</body>
-</html>
-{{end}}`
-
 type multipleMetricsContextKey struct{}
 
 type singleMetricContextKey struct{}
 
 type bodyContextKey struct{}
 
+// signedRequestContextKey признак запроса, прошедшего проверку подписи целиком.
+type signedRequestContextKey struct{}
+
+// agentContextKey идентификатор агента, ключом которого подписан запрос.
+type agentContextKey struct{}
+
 var contentTypes = []string{
 	"application/javascript",
 	"application/json",
@@ -76,6 +62,17 @@ const (
 	retUpdateJSONResultMessage string = "Возвращаем UpdateJSON result :%v"
 )
 
+// NewAdminRouter роутер отдельного listener профилировщика: /debug/pprof и /debug/vars без аутентификации,
+// поэтому listener должен быть доступен только локально.
+func NewAdminRouter() chi.Router {
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
+	r.Mount("/debug", middleware.Profiler())
+	return r
+}
+
 // NewRouter инициализация роутера с помощью библиотеки chi и описание доступных эндпоинтов.
 func NewRouter(
 	metricStorage storage.Repository,
@@ -84,44 +81,103 @@ func NewRouter(
 ) chi.Router {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	// с доверенной сетью адресу из заголовков нельзя верить без доверенного прокси
+	if len(cfg.TrustedNets) > 0 {
+		r.Use(realIPMiddleware(cfg.TrustedProxyNets))
+	} else {
+		r.Use(middleware.RealIP)
+	}
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Compress(gzip.BestSpeed, contentTypes...))
-	r.Mount("/debug", middleware.Profiler())
-	r.Get("/", GetAllHandler(metricStorage))
+	if cfg.Key != "" {
+		r.Use(requestSignatureMiddleware(signer.NewRequestVerifier(cfg.Key, cfg.SignatureMaxSkew)))
+	}
+	if cfg.AgentKeys != nil {
+		r.Use(agentSignatureMiddleware(signer.NewAgentVerifier(cfg.AgentKeys, cfg.SignatureMaxSkew)))
+	}
+	policy := signPolicy(cfg)
+	authn := newAuthenticator(cfg)
+	if cfg.Profiler && cfg.AdminAddress == "" {
+		r.With(authn.require(auth.ScopeAdmin)).Mount("/debug", middleware.Profiler())
+	}
 
-	r.Get("/ping", GetDBConnState(postgreStorage))
+	r.Group(func(r chi.Router) {
+		r.Use(authn.require(auth.ScopeRead))
+		r.With(authn.requireAllMetrics).Get("/", GetAllHandler(metricStorage, cfg.TemplateDir))
+		r.With(authn.requireAllMetrics).Get("/metrics", GetPrometheusHandler(metricStorage))
+		r.With(authn.requireAllMetrics).Get("/api/v1/metrics", ListMetricsHandler(metricStorage))
+		r.With(authn.requireAllMetrics).Get("/api/v1/stream", StreamSSEHandler(metricStorage))
+		r.With(authn.requireAllMetrics).Get("/api/v1/stream/ws", StreamWSHandler(metricStorage))
+
+		r.Route("/values", func(r chi.Router) {
+			r.With(gzipMiddleware).
+				With(rsaMiddleware(cfg.CryptoKeys)).
+				With(MiddlewareGeneratorMultipleJSON(policy)).
+				Post("/", GetJSONMultipleMetricsHandlerFunc(metricStorage, cfg.Key))
+		})
 
-	r.Route("/update", func(r chi.Router) {
-		r.With(gzipMiddleware).
-			With(rsaMiddleware(cfg.CryptoKey)).
-			With(MiddlewareGeneratorSingleJSON(cfg.Key)).
-			Post("/", UpdateJSONMetricHandlerFunc(metricStorage, cfg.Key))
-		r.Route("/{mtype}/{name}/{val}", func(r chi.Router) {
-			r.Post("/", UpdateMetricHandlerFunc(metricStorage))
+		r.Route("/value", func(r chi.Router) {
+			r.With(gzipMiddleware).
+				With(rsaMiddleware(cfg.CryptoKeys)).
+				With(MiddlewareGeneratorSingleJSON(policy)).
+				Post("/", GetJSONMetricHandlerFunc(metricStorage, cfg.Key))
+			r.Route("/{mtype}/{name}", func(r chi.Router) {
+				r.Get("/", GetMetricHandlerFunc(metricStorage))
+			})
 		})
 	})
 
-	r.Route("/updates", func(r chi.Router) {
-		r.With(gzipMiddleware).
-			With(rsaMiddleware(cfg.CryptoKey)).
-			With(MiddlewareGeneratorMultipleJSON(cfg.Key)).
-			Post("/", UpdateJSONMultipleMetricHandlerFunc(metricStorage, cfg.Key))
-	})
+	r.Get("/ping", GetDBConnState(postgreStorage))
 
-	r.Route("/value", func(r chi.Router) {
-		r.With(gzipMiddleware).
-			With(rsaMiddleware(cfg.CryptoKey)).
-			With(MiddlewareGeneratorSingleJSON(cfg.Key)).
-			Post("/", GetJSONMetricHandlerFunc(metricStorage, cfg.Key))
-		r.Route("/{mtype}/{name}", func(r chi.Router) {
-			r.Get("/", GetMetricHandlerFunc(metricStorage))
+	r.Group(func(r chi.Router) {
+		r.Use(trustedSubnetMiddleware(cfg.TrustedNets))
+		r.Use(authn.require(auth.ScopeWrite))
+		r.Route("/update", func(r chi.Router) {
+			r.With(requireSignedRequest(policy, true)).
+				With(gzipMiddleware).
+				With(rsaMiddleware(cfg.CryptoKeys)).
+				With(MiddlewareGeneratorSingleJSON(policy)).
+				Post("/", UpdateJSONMetricHandlerFunc(metricStorage, cfg.Key))
+			r.Route("/{mtype}/{name}/{val}", func(r chi.Router) {
+				r.With(requireSignedRequest(policy, false)).
+					Post("/", UpdateMetricHandlerFunc(metricStorage))
+			})
 		})
+
+		r.Route("/updates", func(r chi.Router) {
+			r.With(requireSignedRequest(policy, true)).
+				With(gzipMiddleware).
+				With(rsaMiddleware(cfg.CryptoKeys)).
+				With(MiddlewareGeneratorMultipleJSON(policy)).
+				Post("/", UpdateJSONMultipleMetricHandlerFunc(metricStorage, cfg.Key))
+		})
+
+		r.With(requireSignedRequest(policy, false)).
+			With(gzipMiddleware).
+			With(rsaMiddleware(cfg.CryptoKeys)).
+			Post("/write", WriteInfluxHandlerFunc(metricStorage, influx.Options{
+				TagsAsPrefix:       cfg.InfluxTagsAsPrefix,
+				IntegersAsCounters: cfg.InfluxCounters,
+			}))
+	})
+
+	r.Route("/api/v2", func(r chi.Router) {
+		routeV2(r, metricStorage, cfg, authn)
 	})
 	return r
 }
 
+// signPolicy правила проверки подписей метрик по конфигурации сервера.
+func signPolicy(cfg config.Config) signer.Policy {
+	return signer.Policy{
+		Key:      cfg.Key,
+		Mode:     signer.Mode(cfg.SignMode),
+		Agents:   cfg.AgentKeys != nil,
+		Requests: cfg.SignRequests,
+	}
+}
+
 // GetDBConnState реализует пинг состояния БД.
 func GetDBConnState(
 	sqlStorage dbstorage.SQLStorage,
@@ -130,11 +186,7 @@ func GetDBConnState(
 		// При успешной проверке хендлер должен вернуть HTTP-статус 200 OK, при неуспешной — 500 Internal Server Error.
 		if err := sqlStorage.Ping(r.Context()); err != nil {
 			log.Printf("Cannot ping database because %s", err)
-			http.Error(
-				w,
-				fmt.Sprintf("Cannot ping database %v", err),
-				http.StatusInternalServerError,
-			)
+			writeProblem(w, r, http.StatusInternalServerError, codeDatabase, "Database is not available")
 			return
 		}
 		// устанавливаем статус-код 200
@@ -143,6 +195,7 @@ func GetDBConnState(
 }
 
 // UpdateJSONMultipleMetricHandlerFunc обрабатывает обновления значений метрик, которыые приходят в виде массивов JSON.
+// Пакет применяется только если все его элементы корректны, иначе ответ содержит ошибку каждого элемента.
 func UpdateJSONMultipleMetricHandlerFunc(
 	metricStorage storage.Repository,
 	key string,
@@ -153,39 +206,45 @@ func UpdateJSONMultipleMetricHandlerFunc(
 			log.Printf(
 				"Error: [UpdateJSONMultipleMetricHandlerFunc] Metric info not found in context status-'500'",
 			)
-			http.Error(w, missingContextMessage, http.StatusInternalServerError)
+			writeProblem(w, r, http.StatusInternalServerError, codeInternal, missingContextMessage)
+			return
+		}
+		var items []problemItem
+		for i, m := range metrics {
+			if err := checkValue(m); err != nil {
+				items = append(items, newProblemItem(i, m.ID, err))
+			}
+		}
+		if len(items) > 0 {
+			log.Printf("Error: [UpdateJSONMultipleMetricHandlerFunc] %d invalid metrics in batch", len(items))
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidBatch, "Some metrics in the batch are invalid", items...)
 			return
 		}
 		err := metricStorage.AddMetrics(metrics)
 		if err != nil {
 			log.Printf("Error: [UpdateJSONMultipleMetricHandlerFunc] Add multiple metrics error: %v", err)
-			http.Error(
-				w,
-				fmt.Sprintf("Add multiple metrics error: %v", err),
-				http.StatusInternalServerError,
-			)
+			writeProblem(w, r, http.StatusInternalServerError, codeStorage, "Failed to store metrics")
 			return
 		}
+		attributeMetrics(r, len(metrics))
 		l := len(metrics)
 		retval := make([]model.Metrics, l)
 		for i, m := range metrics {
 			if key != "" {
 				hashObject := signer.NewHashObject(key)
-				sssignErr := hashObject.Sign(metrics[i])
-				if sssignErr != nil {
-					log.Printf(
-						resJSONSignErrorMessage,
-						err,
-					)
-					http.Error(w, httpJSONSignErrorMessage, http.StatusInternalServerError)
+				if err = hashObject.Sign(metrics[i]); err != nil {
+					log.Printf(resJSONSignErrorMessage, err)
+					writeProblem(w, r, http.StatusInternalServerError, codeInternal, httpJSONSignErrorMessage)
+					return
 				}
 			}
 			retval[i] = model.Metrics{
-				ID:    m.ID,
-				MType: m.MType,
-				Value: m.Value,
-				Delta: m.Delta,
-				Hash:  m.Hash,
+				ID:          m.ID,
+				MType:       m.MType,
+				Value:       m.Value,
+				Delta:       m.Delta,
+				Hash:        m.Hash,
+				HashVersion: m.HashVersion,
 			}
 		}
 
@@ -197,7 +256,6 @@ func UpdateJSONMultipleMetricHandlerFunc(
 		err = encoder.Encode(retval)
 		if err != nil {
 			log.Printf("Error: [updateJSONMetricHandlerFunc] Result Json encode error :%v", err)
-			http.Error(w, "sjosn encode error", http.StatusInternalServerError)
 		}
 		log.Printf(retUpdateJSONResultMessage, retval)
 	}
@@ -214,73 +272,49 @@ func UpdateJSONMetricHandlerFunc(
 			log.Printf(
 				missingContextMessageLong,
 			)
-			http.Error(w, missingContextMessage, http.StatusInternalServerError)
+			writeProblem(w, r, http.StatusInternalServerError, codeInternal, missingContextMessage)
+			return
+		}
+		if err := checkValue(metric); err != nil {
+			log.Printf("Error: [updateJSONMetricHandlerFunc] %v", err)
+			writeProblem(w, r, http.StatusBadRequest, problemCode(err), err.Error())
 			return
 		}
 
+		// ответ подписывается в том же формате, что и запрос
 		retval := &model.Metrics{
-			ID:    metric.ID,
-			MType: metric.MType,
+			ID:          metric.ID,
+			MType:       metric.MType,
+			HashVersion: metric.HashVersion,
 		}
 
 		switch metric.MType {
 		case counter:
-			{
-				if metric.Delta == nil {
-					log.Printf("Error: [updateJSONMetricHandlerFunc] Counter not found status- 400")
-					http.Error(w, "error: counter not found", http.StatusBadRequest)
-					return
-				}
-
-				delta, err := metricStorage.AddCounter(metric.ID, metric.Delta)
-				if err != nil {
-					log.Printf("Error: [updateJSONMetricHandlerFunc] Update counter error: %v", err)
-					http.Error(
-						w,
-						fmt.Sprintf("error: update counter failed: %v", err),
-						http.StatusInternalServerError,
-					)
-					return
-				}
-				retval.Delta = &delta
+			delta, err := metricStorage.AddCounter(metric.ID, metric.Delta)
+			if err != nil {
+				log.Printf("Error: [updateJSONMetricHandlerFunc] Update counter error: %v", err)
+				writeProblem(w, r, http.StatusInternalServerError, codeStorage, "Failed to update counter")
+				return
 			}
+			retval.Delta = &delta
 		case gauge:
-			{
-				if metric.Value == nil {
-					log.Printf("Error: [updateJSONMetricHandlerFunc] gauge not found status- 400")
-					http.Error(w, "error: gauge not found", http.StatusBadRequest)
-					return
-				}
-
-				value, err := metricStorage.AddGauge(metric.ID, metric.Value)
-				if err != nil {
-					log.Printf("Error: [updateJSONMetricHandlerFunc] Update gauge error: %v", err)
-					http.Error(
-						w,
-						fmt.Sprintf("Update counter error: %v", err),
-						http.StatusInternalServerError,
-					)
-					return
-				}
-				retval.Value = &value
-			}
-		default:
-			{
-				log.Printf("Error: [updateJSONMetricHandlerFunc] Unknown metric type status - 500")
-				http.Error(w, unkMTMessage, http.StatusInternalServerError)
+			value, err := metricStorage.AddGauge(metric.ID, metric.Value)
+			if err != nil {
+				log.Printf("Error: [updateJSONMetricHandlerFunc] Update gauge error: %v", err)
+				writeProblem(w, r, http.StatusInternalServerError, codeStorage, "Failed to update gauge")
 				return
 			}
+			retval.Value = &value
 		}
+		attributeMetrics(r, 1)
 
 		if key != "" {
 			hashObject := signer.NewHashObject(key)
 			err := hashObject.Sign(retval)
 			if err != nil {
-				log.Printf(
-					"Error: [updateJSONMetricHandlerFunc] Result Json Sign data error :%v",
-					err,
-				)
-				http.Error(w, "Result Json Sign error", http.StatusInternalServerError)
+				log.Printf(resJSONSignErrorMessage, err)
+				writeProblem(w, r, http.StatusInternalServerError, codeInternal, httpJSONSignErrorMessage)
+				return
 			}
 		}
 
@@ -290,9 +324,8 @@ func UpdateJSONMetricHandlerFunc(
 		err := encoder.Encode(retval)
 		if err != nil {
 			log.Printf("Error: [updateJSONMetricHandlerFunc] Result Json encode error :%v", err)
-			http.Error(w, "Result Json encode error", http.StatusInternalServerError)
 		}
-		log.Printf("Возвращаем UpdateJSON result :%v", retval)
+		log.Printf(retUpdateJSONResultMessage, retval)
 	}
 }
 
@@ -304,21 +337,28 @@ func UpdateMetricHandlerFunc(
 		mtype := chi.URLParam(r, "mtype")
 		name := chi.URLParam(r, "name")
 		val := chi.URLParam(r, "val")
-		var err error
+		err := authorizeMetric(r, name)
+		if err != nil {
+			writeProblem(w, r, http.StatusForbidden, codeForbidden, err.Error())
+			return
+		}
 		switch mtype {
 		case gauge:
 			_, err = metricStorage.AddGauge(name, val)
 		case counter:
 			_, err = metricStorage.AddCounter(name, val)
 		default:
-			{
-				http.Error(w, unkMTMessage, http.StatusNotImplemented)
-				return
-			}
+			writeProblem(w, r, http.StatusNotImplemented, codeUnknownType,
+				fmt.Sprintf("%s '%s'", unkMTMessage, mtype))
+			return
 		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest) // 400
+			log.Printf("Error: [UpdateMetricHandlerFunc] update %s '%s' error: %v", mtype, name, err)
+			writeProblem(w, r, http.StatusBadRequest, codeBadValue,
+				fmt.Sprintf("Value '%s' is not a valid %s value", val, mtype))
+			return
 		}
+		attributeMetrics(r, 1)
 		// устанавливаем статус-код 200
 		w.WriteHeader(http.StatusOK)
 	}
@@ -333,20 +373,21 @@ func GetJSONMetricHandlerFunc(
 		metric, ok := r.Context().Value(singleMetricContextKey{}).(*model.Metrics)
 		if !ok {
 			log.Printf("Error: [getJSONMetricHandlerFunc] Metric info not found in context")
-			http.Error(w, missingContextMessage, http.StatusInternalServerError)
+			writeProblem(w, r, http.StatusInternalServerError, codeInternal, missingContextMessage)
 			return
 		}
 
 		retval := &model.Metrics{
-			ID:    metric.ID,
-			MType: metric.MType,
+			ID:          metric.ID,
+			MType:       metric.MType,
+			HashVersion: metric.HashVersion,
 		}
 		switch metric.MType {
 		case counter:
 			delta, err := metricStorage.GetCounter(metric.ID)
 			if err != nil {
 				log.Printf("Error: [getJSONMetricHandlerFunc] Counter not found: %v", err)
-				http.Error(w, "Counter not found", http.StatusNotFound)
+				writeProblem(w, r, http.StatusNotFound, codeNotFound, fmt.Sprintf("Counter '%s' not found", metric.ID))
 				return
 			}
 			retval.Delta = &delta
@@ -356,14 +397,15 @@ func GetJSONMetricHandlerFunc(
 			value, err := metricStorage.GetGauge(metric.ID)
 			if err != nil {
 				log.Printf("Error: [getJSONMetricHandlerFunc] Gauge not found: %v", err)
-				http.Error(w, "Gauge not found", http.StatusNotFound)
+				writeProblem(w, r, http.StatusNotFound, codeNotFound, fmt.Sprintf("Gauge '%s' not found", metric.ID))
 				return
 			}
 			retval.Value = &value
 
 		default:
 			log.Printf("Error: [getJSONMetricHandlerFunc] Unknown metric type")
-			http.Error(w, unkMTMessage, http.StatusInternalServerError)
+			writeProblem(w, r, http.StatusBadRequest, codeUnknownType,
+				fmt.Sprintf("%s '%s'", unkMTMessage, metric.MType))
 			return
 		}
 
@@ -371,11 +413,9 @@ func GetJSONMetricHandlerFunc(
 			hashObject := signer.NewHashObject(key)
 			err := hashObject.Sign(retval)
 			if err != nil {
-				log.Printf(
-					"Error: [updateJSONMetricHandlerFunc] Result Json Sign data error :%v",
-					err,
-				)
-				http.Error(w, "Result Json Sign error", http.StatusInternalServerError)
+				log.Printf(resJSONSignErrorMessage, err)
+				writeProblem(w, r, http.StatusInternalServerError, codeInternal, httpJSONSignErrorMessage)
+				return
 			}
 		}
 
@@ -385,9 +425,80 @@ func GetJSONMetricHandlerFunc(
 		err := encoder.Encode(retval)
 		if err != nil {
 			log.Printf("Error: [getJSONMetricHandlerFunc] Result Json encode error")
-			http.Error(w, "Result Json encode error", http.StatusInternalServerError)
 		}
-		log.Printf("Возвращаем UpdateJSON result :%v", retval)
+		log.Printf(retUpdateJSONResultMessage, retval)
+	}
+}
+
+// metricValue элемент ответа POST /values. Для отсутствующей метрики значение не заполняется,
+// а признак NotFound равен true.
+type metricValue struct {
+	model.Metrics
+	NotFound bool `json:"not_found,omitempty"`
+}
+
+// GetJSONMultipleMetricsHandlerFunc возвращает значения списка метрик одним ответом в формате JSON.
+// Найденные значения подписываются ключом key.
+func GetJSONMultipleMetricsHandlerFunc(
+	metricStorage storage.Repository,
+	key string,
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics, ok := r.Context().Value(multipleMetricsContextKey{}).([]*model.Metrics)
+		if !ok {
+			log.Printf("Error: [GetJSONMultipleMetricsHandlerFunc] Metric info not found in context status-'500'")
+			writeProblem(w, r, http.StatusInternalServerError, codeInternal, missingContextMessage)
+			return
+		}
+
+		var items []problemItem
+		for i, metric := range metrics {
+			if metric.MType != counter && metric.MType != gauge {
+				items = append(items, newProblemItem(i, metric.ID, fmt.Errorf("%w '%s'", errUnknownType, metric.MType)))
+			}
+		}
+		if len(items) > 0 {
+			log.Printf("Error: [GetJSONMultipleMetricsHandlerFunc] %d invalid metrics in request", len(items))
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidBatch, "Some metrics in the request are invalid", items...)
+			return
+		}
+
+		retval := make([]metricValue, len(metrics))
+		for i, metric := range metrics {
+			retval[i].ID = metric.ID
+			retval[i].MType = metric.MType
+			retval[i].HashVersion = metric.HashVersion
+			switch metric.MType {
+			case counter:
+				delta, err := metricStorage.GetCounter(metric.ID)
+				if err != nil {
+					retval[i].NotFound = true
+					continue
+				}
+				retval[i].Delta = &delta
+			case gauge:
+				value, err := metricStorage.GetGauge(metric.ID)
+				if err != nil {
+					retval[i].NotFound = true
+					continue
+				}
+				retval[i].Value = &value
+			}
+			if key != "" {
+				hashObject := signer.NewHashObject(key)
+				if err := hashObject.Sign(&retval[i].Metrics); err != nil {
+					log.Printf(resJSONSignErrorMessage, err)
+					writeProblem(w, r, http.StatusInternalServerError, codeInternal, httpJSONSignErrorMessage)
+					return
+				}
+			}
+		}
+
+		w.Header().Set(keyCT, keyValueJSON)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(retval); err != nil {
+			log.Printf("Error: [GetJSONMultipleMetricsHandlerFunc] Result Json encode error :%v", err)
+		}
 	}
 }
 
@@ -400,13 +511,13 @@ func gzipMiddleware(next http.Handler) http.Handler {
 			gz, err := gzip.NewReader(r.Body)
 			if err != nil {
 				log.Printf("Failed to create gzip reader: %v", err.Error())
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeProblem(w, r, http.StatusBadRequest, codeBadBody, "Request body is not valid gzip")
 				return
 			}
 			buf, err = io.ReadAll(gz)
 			if err != nil {
 				log.Printf("Failed to read bytes from gzip reader: %v", err.Error())
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeProblem(w, r, http.StatusBadRequest, codeBadBody, "Request body is not valid gzip")
 				return
 			}
 			defer func() {
@@ -419,7 +530,7 @@ func gzipMiddleware(next http.Handler) http.Handler {
 
 			if err != nil {
 				log.Printf("Failed to read bytes from request body in gzip encoder: %v", err.Error())
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeProblem(w, r, http.StatusBadRequest, codeBadBody, "Failed to read request body")
 				return
 			}
 		}
@@ -428,7 +539,135 @@ func gzipMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func rsaMiddleware(key *rsa.PrivateKey) func(next http.Handler) http.Handler {
+// requestSignatureMiddleware проверяет подпись запроса целиком, если агент ее передал.
+// Подпись вычисляется по телу в том виде, в каком оно пришло по сети (до распаковки и расшифровки).
+func requestSignatureMiddleware(verifier *signer.RequestVerifier) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !signer.HasRequestSignature(r.Header) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				log.Printf("Error: [requestSignatureMiddleware] read body error: %v", err)
+				writeProblem(w, r, http.StatusBadRequest, codeBadBody, "Failed to read request body")
+				return
+			}
+			err = verifier.Verify(r.Method, r.URL.RequestURI(), r.Header, body)
+			switch {
+			case errors.Is(err, signer.ErrReplayedRequest):
+				log.Printf("Error: [requestSignatureMiddleware] %v", err)
+				writeProblem(w, r, http.StatusConflict, codeReplayedRequest, "Request has already been processed")
+				return
+			case errors.Is(err, signer.ErrStaleRequest):
+				log.Printf("Error: [requestSignatureMiddleware] %v", err)
+				writeProblem(w, r, http.StatusBadRequest, codeStaleRequest, "Request timestamp is outside the allowed window")
+				return
+			case err != nil:
+				log.Printf("Error: [requestSignatureMiddleware] %v", err)
+				writeProblem(w, r, http.StatusBadRequest, codeSignatureMismatch, "Request signature verification failed")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			ctx := context.WithValue(r.Context(), signedRequestContextKey{}, true)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// agentSignatureMiddleware проверяет подпись запроса ключом агента из реестра, если агент ее передал.
+// Идентификатор проверенного агента сохраняется в контексте запроса (см. requestAgent).
+func agentSignatureMiddleware(verifier *signer.AgentVerifier) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !signer.HasAgentSignature(r.Header) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				log.Printf("Error: [agentSignatureMiddleware] read body error: %v", err)
+				writeProblem(w, r, http.StatusBadRequest, codeBadBody, "Failed to read request body")
+				return
+			}
+			agent, err := verifier.Verify(r.Method, r.URL.RequestURI(), r.Header, body)
+			switch {
+			case errors.Is(err, signer.ErrUnknownAgent):
+				log.Printf("Error: [agentSignatureMiddleware] %v", err)
+				writeProblem(w, r, http.StatusForbidden, codeUnknownAgent,
+					fmt.Sprintf("Agent '%s' is not registered", r.Header.Get(signer.AgentIDHeader)))
+				return
+			case errors.Is(err, signer.ErrReplayedRequest):
+				log.Printf("Error: [agentSignatureMiddleware] %v", err)
+				writeProblem(w, r, http.StatusConflict, codeReplayedRequest, "Request has already been processed")
+				return
+			case errors.Is(err, signer.ErrStaleRequest):
+				log.Printf("Error: [agentSignatureMiddleware] %v", err)
+				writeProblem(w, r, http.StatusBadRequest, codeStaleRequest, "Request timestamp is outside the allowed window")
+				return
+			case err != nil:
+				log.Printf("Error: [agentSignatureMiddleware] agent '%s': %v", r.Header.Get(signer.AgentIDHeader), err)
+				writeProblem(w, r, http.StatusBadRequest, codeSignatureMismatch, "Agent signature verification failed")
+				return
+			}
+			log.Printf("Request %s signed by agent '%s'", r.URL.Path, agent)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			ctx := context.WithValue(r.Context(), signedRequestContextKey{}, true)
+			ctx = context.WithValue(ctx, agentContextKey{}, agent)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requestAgent возвращает идентификатор агента, подписавшего запрос, или пустую строку.
+func requestAgent(r *http.Request) string {
+	agent, _ := r.Context().Value(agentContextKey{}).(string)
+	return agent
+}
+
+// attributeMetrics учитывает n сохраненных метрик за агентом, подписавшим запрос.
+func attributeMetrics(r *http.Request, n int) {
+	if agent := requestAgent(r); agent != "" {
+		signer.AgentMetrics.Add(agent, int64(n))
+		log.Printf("Stored %d metrics from agent '%s'", n, agent)
+	}
+}
+
+// isRequestSigned сообщает, прошел ли запрос проверку подписи целиком.
+func isRequestSigned(r *http.Request) bool {
+	signed, _ := r.Context().Value(signedRequestContextKey{}).(bool)
+	return signed
+}
+
+// requireSignedRequest отклоняет запросы записи без подписи целиком, если этого требует policy.
+// perMetric означает, что формат запроса позволяет подписать отдельные метрики (см. signer.Policy.RequireRequest).
+// Наличие подписей метрик не учитывается: без подписи запроса их можно повторить.
+func requireSignedRequest(policy signer.Policy, perMetric bool) func(next http.Handler) http.Handler {
+	required := policy.RequireRequest(perMetric)
+	return func(next http.Handler) http.Handler {
+		if !required {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isRequestSigned(r) {
+				signer.Rejections.Add(signer.RejectMissing, 1)
+				log.Printf("Error: [requireSignedRequest] unsigned request %s rejected", r.URL.Path)
+				writeProblem(w, r, http.StatusBadRequest, codeSignatureRequired,
+					"Request signature is required, send "+signer.RequestSignatureHeader+" or "+
+						signer.AgentSignatureHeader+" headers")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rsaMiddleware расшифровывает тело запроса ключом из keys. Схема выбирается заголовком
+// signer.EncryptionHeader, без заголовка ожидается поблочный формат signer.DecryptMessage.
+// Ключ выбирается по заголовку signer.KeyIDHeader; агенты, не передающие идентификатор,
+// обслуживаются перебором всех ключей набора.
+func rsaMiddleware(keys *signer.Keyring) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			content, ok := r.Context().Value(bodyContextKey{}).([]byte)
@@ -436,22 +675,44 @@ func rsaMiddleware(key *rsa.PrivateKey) func(next http.Handler) http.Handler {
 				log.Printf(
 					missingContextMessageLong,
 				)
-				http.Error(w, missingContextMessage, http.StatusInternalServerError)
+				writeProblem(w, r, http.StatusInternalServerError, codeInternal, missingContextMessage)
 				return
 			}
-			var nextData []byte
-			var err error
-			if key != nil {
-				nextData, err = signer.DecryptMessage(key, content)
+			nextData := content
+			if keys != nil {
+				var decrypt func(*rsa.PrivateKey, []byte) ([]byte, error)
+				switch scheme := r.Header.Get(signer.EncryptionHeader); scheme {
+				case signer.EncryptionHybrid:
+					decrypt = signer.DecryptEnvelope
+				case "":
+					// устаревший поблочный формат, принимается на время перехода агентов на конверт
+					decrypt = signer.DecryptMessage
+				default:
+					writeProblem(w, r, http.StatusBadRequest, codeDecrypt,
+						fmt.Sprintf("Unsupported encryption scheme '%s'", scheme))
+					return
+				}
+				candidates := keys.Keys()
+				if id := r.Header.Get(signer.KeyIDHeader); id != "" {
+					key, err := keys.Key(id)
+					if err != nil {
+						log.Printf("Error: [rsaMiddleware] %v", err)
+						writeProblem(w, r, http.StatusBadRequest, codeDecrypt, fmt.Sprintf("Unknown key id '%s'", id))
+						return
+					}
+					candidates = []*rsa.PrivateKey{key}
+				}
+				var err error
+				for _, key := range candidates {
+					if nextData, err = decrypt(key, content); err == nil {
+						break
+					}
+				}
 				if err != nil {
-					log.Printf(
-						missingContextMessageLong,
-					)
-					http.Error(w, missingContextMessage, http.StatusInternalServerError)
+					log.Printf("Error: [rsaMiddleware] decrypt message error: %v", err)
+					writeProblem(w, r, http.StatusBadRequest, codeDecrypt, "Failed to decrypt request body")
 					return
 				}
-			} else {
-				nextData = content
 			}
 			ctx := context.WithValue(r.Context(), bodyContextKey{}, nextData)
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -460,7 +721,7 @@ func rsaMiddleware(key *rsa.PrivateKey) func(next http.Handler) http.Handler {
 }
 
 // MiddlewareGeneratorSingleJSON промежуточная функция обработки одиночной метрики в формате JSON.
-func MiddlewareGeneratorSingleJSON(key string) func(next http.Handler) http.Handler {
+func MiddlewareGeneratorSingleJSON(policy signer.Policy) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var reader io.Reader
@@ -469,7 +730,7 @@ func MiddlewareGeneratorSingleJSON(key string) func(next http.Handler) http.Hand
 				log.Printf(
 					missingContextMessageLong,
 				)
-				http.Error(w, missingContextMessage, http.StatusInternalServerError)
+				writeProblem(w, r, http.StatusInternalServerError, codeInternal, missingContextMessage)
 				return
 			}
 			reader = bytes.NewReader(content)
@@ -478,52 +739,16 @@ func MiddlewareGeneratorSingleJSON(key string) func(next http.Handler) http.Hand
 			decoder := json.NewDecoder(reader)
 
 			if err := decoder.Decode(metric); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-
-			if metric.ID == "" {
-				http.Error(w, "metric.ID is empty", http.StatusBadRequest)
-				return
-			}
-			if metric.MType == "" {
-				http.Error(w, "metric.MType is empty", http.StatusBadRequest)
+				log.Printf("Error: [MiddlewareGeneratorSingleJSON] decode metric error: %v", err)
+				writeProblem(w, r, http.StatusBadRequest, codeBadBody, "Request body is not a valid metric JSON")
 				return
 			}
 			log.Printf("Incoming request Method: %v, Body: %v", r.RequestURI, metric)
 
-			if key != "" && metric.Hash != "" {
-				hashObject := signer.NewHashObject(key)
-				passed, err := hashObject.Verify(metric)
-				if err != nil {
-					log.Printf(
-						"Incoming Metric could not pass signature verification: %v, \nBody: %v, \n error: %v",
-						r.RequestURI,
-						metric,
-						err,
-					)
-					http.Error(
-						w,
-						fmt.Sprintf(
-							"Incoming Metric could not pass signature verification, error:%v",
-							err,
-						),
-						http.StatusBadRequest,
-					)
-				}
-				if !passed {
-					log.Printf(
-						"Error: Incoming Metric could not pass signature verification: %v, Body: %v",
-						r.RequestURI,
-						metric,
-					)
-					http.Error(
-						w,
-						"Incoming Metric could not pass signature verification",
-						http.StatusBadRequest,
-					)
-					return
-				}
+			if err := checkRequestMetric(r, metric, policy); err != nil {
+				log.Printf("Error: [MiddlewareGeneratorSingleJSON] %v, Body: %v", r.RequestURI, metric)
+				writeProblem(w, r, problemStatus(err), problemCode(err), err.Error())
+				return
 			}
 			ctx := context.WithValue(r.Context(), singleMetricContextKey{}, metric)
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -531,8 +756,8 @@ func MiddlewareGeneratorSingleJSON(key string) func(next http.Handler) http.Hand
 	}
 }
 
-// MiddlewareGeneratorMultipleJSON промежуточная функция обработки vfccbdf метрик в формате JSON.
-func MiddlewareGeneratorMultipleJSON(key string) func(next http.Handler) http.Handler {
+// MiddlewareGeneratorMultipleJSON промежуточная функция обработки массива метрик в формате JSON.
+func MiddlewareGeneratorMultipleJSON(policy signer.Policy) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var reader io.Reader
@@ -541,7 +766,7 @@ func MiddlewareGeneratorMultipleJSON(key string) func(next http.Handler) http.Ha
 				log.Printf(
 					"Error: [MiddlewareGeneratorMultipleJSON] Metric info not found in context status-'500'",
 				)
-				http.Error(w, missingContextMessage, http.StatusInternalServerError)
+				writeProblem(w, r, http.StatusInternalServerError, codeInternal, missingContextMessage)
 				return
 			}
 			reader = bytes.NewReader(content)
@@ -556,24 +781,25 @@ func MiddlewareGeneratorMultipleJSON(key string) func(next http.Handler) http.Ha
 			err := json.Unmarshal(bodyBytes, &metrics)
 			if err != nil {
 				log.Printf("Failed to Decode incoming metricList %v, error: %v", string(bodyBytes), err)
-				http.Error(w, fmt.Sprintf("Failed to Decode incoming metricList %v", err), http.StatusBadRequest)
+				writeProblem(w, r, http.StatusBadRequest, codeBadBody, "Request body is not a valid JSON array of metrics")
 				return
 			}
 			log.Printf("Incoming request Method: %v, Body: %v", r.RequestURI, string(bodyBytes))
 			log.Printf("Try to update metrics: %v", metrics)
+			var items []problemItem
 			for i := range metrics {
-				if key != "" {
-					pass, err := checkMetric(metrics[i], key)
-					if err != nil || !pass {
-						http.Error(
-							w,
-							fmt.Sprintf("check Metric Error:%v", err),
-							http.StatusBadRequest,
-						)
-						return
-					}
+				if metrics[i] == nil {
+					items = append(items, newProblemItem(i, "", fmt.Errorf("%w: metric is null", errMissingField)))
+					continue
+				}
+				if err := checkRequestMetric(r, metrics[i], policy); err != nil {
+					items = append(items, newProblemItem(i, metrics[i].ID, err))
 				}
 			}
+			if len(items) > 0 {
+				writeProblem(w, r, http.StatusBadRequest, codeInvalidBatch, "Some metrics in the request are invalid", items...)
+				return
+			}
 
 			ctx := context.WithValue(r.Context(), multipleMetricsContextKey{}, metrics)
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -588,43 +814,33 @@ func GetMetricHandlerFunc(
 	return func(w http.ResponseWriter, r *http.Request) {
 		name := chi.URLParam(r, "name")
 		mtype := chi.URLParam(r, "mtype")
+		if err := authorizeMetric(r, name); err != nil {
+			writeProblem(w, r, http.StatusForbidden, codeForbidden, err.Error())
+			return
+		}
 
 		var val string
 
 		switch mtype {
 		case gauge:
-
 			g, err := metricStorage.GetGauge(name)
 			if err != nil {
-				w.WriteHeader(http.StatusNotFound)
-				_, err = w.Write([]byte(err.Error()))
-				if err != nil {
-					log.Printf("GetMetricHandlerFunc gauge writer.Write error:%v", err)
-				}
+				writeProblem(w, r, http.StatusNotFound, codeNotFound, fmt.Sprintf("Gauge '%s' not found", name))
 				return
 			}
 			val = fmt.Sprintf("%v", g)
 
 		case counter:
-
 			c, err := metricStorage.GetCounter(name)
 			if err != nil {
-				w.WriteHeader(http.StatusNotFound)
-				_, err = w.Write([]byte(err.Error()))
-				if err != nil {
-					log.Printf("GetMetricHandlerFunc counter writer.Write error:%v", err)
-				}
+				writeProblem(w, r, http.StatusNotFound, codeNotFound, fmt.Sprintf("Counter '%s' not found", name))
 				return
 			}
 			val = fmt.Sprintf("%v", c)
 
 		default:
-
-			w.WriteHeader(http.StatusNotImplemented)
-			_, err := w.Write([]byte(unkMTMessage))
-			if err != nil {
-				log.Printf("GetMetricHandlerFunc unknown type writer.Write error:%v", err)
-			}
+			writeProblem(w, r, http.StatusNotImplemented, codeUnknownType, fmt.Sprintf("%s '%s'", unkMTMessage, mtype))
+			return
 		}
 
 		w.WriteHeader(http.StatusOK)
@@ -635,57 +851,46 @@ func GetMetricHandlerFunc(
 	}
 }
 
-// GetAllHandler возвращает значения всех метрик в виде html-страницы.
-func GetAllHandler(metricStorage storage.Repository) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, _ *http.Request) {
-		metrics, err := metricStorage.GetView()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError) // 500
-			return
-		}
-		template, err := template.New("metrics").Parse(templ)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest) // 400
-			return
-		}
-		w.Header().Set("Content-Type", "text/html")
-		w.WriteHeader(http.StatusOK)
-		err = template.ExecuteTemplate(w, "metrics", metrics)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError) // 500
-			return
-		}
+// checkRequestMetric проверяет метрику запроса r: обязательные поля, подпись и доступ токена к метрике.
+func checkRequestMetric(r *http.Request, metric *model.Metrics, policy signer.Policy) error {
+	if _, err := checkMetric(metric, policy, isRequestSigned(r)); err != nil {
+		return err
 	}
+	return authorizeMetric(r, metric.ID)
 }
 
-// checkMetric внутренняя функция проверки целостности метрики.
-func checkMetric(metric *model.Metrics, key string) (bool, error) {
+// checkMetric внутренняя функция проверки целостности метрики. Подпись проверяется по правилам
+// policy; requestSigned означает, что запрос подписан целиком (см. requestSignatureMiddleware).
+func checkMetric(metric *model.Metrics, policy signer.Policy, requestSigned bool) (bool, error) {
 	if metric.ID == "" {
-		return false, fmt.Errorf("metric.ID is empty /n Body: %v", metric)
+		return false, fmt.Errorf("%w: id", errMissingField)
 	}
 	if metric.MType == "" {
-		return false, fmt.Errorf("metric.MType is empty /n Body: %v", metric)
+		return false, fmt.Errorf("%w: type", errMissingField)
 	}
-	if metric.Hash != "" {
-		hashObject := signer.NewHashObject(key)
-		passed, err := hashObject.Verify(metric)
-		if err != nil {
-			log.Printf(
-				"Incoming Metric verification error: \nBody: %v, \n error: %v",
-				metric,
-				err)
-			return false, fmt.Errorf("incoming Metric verification error: \nBody: %v, \n error: %w",
-				metric,
-				err)
-		}
-		if !passed {
-			log.Printf(
-				"Error: Incoming Metric could not pass signature verification: \nBody: %v",
-				metric)
+	if err := policy.Check(metric, requestSigned); err != nil {
+		log.Printf(
+			"Error: Incoming Metric could not pass signature verification: \nBody: %v, \n error: %v",
+			metric,
+			err)
+		return false, err
+	}
+	return true, nil
+}
 
-			return false, fmt.Errorf("error: Incoming Metric could not pass signature verification: \nBody: %v",
-				metric)
+// checkValue проверяет, что тип метрики известен и для него передано значение.
+func checkValue(metric *model.Metrics) error {
+	switch metric.MType {
+	case counter:
+		if metric.Delta == nil {
+			return fmt.Errorf("%w: delta", errMissingField)
+		}
+	case gauge:
+		if metric.Value == nil {
+			return fmt.Errorf("%w: value", errMissingField)
 		}
+	default:
+		return fmt.Errorf("%w '%s'", errUnknownType, metric.MType)
 	}
-	return true, nil
+	return nil
 }
@@ -4,15 +4,27 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"expvar"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rebus2015/praktikum-devops/internal/config"
 	"github.com/rebus2015/praktikum-devops/internal/model"
+	"github.com/rebus2015/praktikum-devops/internal/signer"
 	"github.com/rebus2015/praktikum-devops/internal/storage"
 	"github.com/rebus2015/praktikum-devops/internal/storage/dbstorage"
 	"github.com/rebus2015/praktikum-devops/internal/storage/filestorage"
@@ -148,6 +160,7 @@ func TestGetMetricHandlerFunc(t *testing.T) {
 		counters []memstorage.MetricStr
 		gauges   []memstorage.MetricStr
 		wantcode int
+		wantErr  string
 	}{
 		{
 			name:     "Positive test #1",
@@ -162,15 +175,25 @@ func TestGetMetricHandlerFunc(t *testing.T) {
 			},
 		},
 		{
-			name:     "Positive test #1",
+			name:     "Negative test #1 not found",
 			counters: []memstorage.MetricStr{{Name: "cnt1", Val: "123"}, {Name: "cnt2", Val: "64"}},
 			gauges:   []memstorage.MetricStr{{Name: "gauge1", Val: "12.003"}, {Name: "gauge2", Val: "-164"}},
 			method:   http.MethodGet,
 			wantcode: http.StatusNotFound,
+			wantErr:  codeNotFound,
 			want: args{
-				"gauge",
-				"cnt1",
-				"GetGauge error: cauge with name 'cnt1' is not found",
+				mtype: "gauge",
+				name:  "cnt1",
+			},
+		},
+		{
+			name:     "Negative test #2 unknown type",
+			method:   http.MethodGet,
+			wantcode: http.StatusNotImplemented,
+			wantErr:  codeUnknownType,
+			want: args{
+				mtype: "histogram",
+				name:  "cnt1",
 			},
 		},
 	}
@@ -202,6 +225,10 @@ func TestGetMetricHandlerFunc(t *testing.T) {
 			statusCode, val := testRequest(t, ts, tt.method, p)
 			// проверяем код ответа
 			assert.Equal(t, tt.wantcode, statusCode)
+			if tt.wantErr != "" {
+				assertProblem(t, []byte(val), tt.wantcode, tt.wantErr)
+				return
+			}
 			assert.EqualValues(t, val, tt.want.val)
 		})
 	}
@@ -226,16 +253,35 @@ func testRequest(t *testing.T, ts *httptest.Server, method, path string) (int, s
 	return resp.StatusCode, string(respBody)
 }
 
+// assertProblem проверяет тело ответа об ошибке в формате problem+json.
+func assertProblem(t *testing.T, body []byte, status int, code string) problem {
+	t.Helper()
+	var p problem
+	if !assert.NoError(t, json.Unmarshal(body, &p), string(body)) {
+		return p
+	}
+	assert.Equal(t, status, p.Status)
+	assert.Equal(t, code, p.Code)
+	assert.Equal(t, problemTypePrefix+code, p.Type)
+	assert.Equal(t, http.StatusText(status), p.Title)
+	assert.NotEmpty(t, p.RequestID)
+	return p
+}
+
 func ptr[T any](v T) *T {
 	return &v
 }
 
 func testRequestJSONstring(t *testing.T, ts *httptest.Server, method, path string, metric string) (int, []byte) {
+	return testRequestJSONclient(t, http.DefaultClient, ts, method, path, metric)
+}
+
+func testRequestJSONclient(t *testing.T, client *http.Client, ts *httptest.Server, method, path string, metric string) (int, []byte) {
 	req, err := http.NewRequest(method, ts.URL+path, bytes.NewBuffer([]byte(metric)))
 	assert.NoError(t, err)
 	req.Header.Add("content-type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	assert.NoError(t, err)
 
 	respBody, err := io.ReadAll(resp.Body)
@@ -252,6 +298,7 @@ func testRequestJSONstring(t *testing.T, ts *httptest.Server, method, path strin
 func Test_UpdateJSONMetricHandlerFunc(t *testing.T) {
 	type wantArgs struct {
 		data    string
+		errCode string
 		code    int
 		wantErr bool
 	}
@@ -299,6 +346,7 @@ func Test_UpdateJSONMetricHandlerFunc(t *testing.T) {
 			want: wantArgs{
 				code:    400,
 				wantErr: true,
+				errCode: codeBadBody,
 			},
 			request: requestArgs{
 				data:        "{\"id\":\"C1\",\"type\":\"unk\",\"delta\":1.47}",
@@ -312,6 +360,7 @@ func Test_UpdateJSONMetricHandlerFunc(t *testing.T) {
 			want: wantArgs{
 				code:    400,
 				wantErr: true,
+				errCode: codeMissingField,
 			},
 			request: requestArgs{
 				data:        "{\"id\":\"C1\",\"type\":\"counter\"}",
@@ -320,6 +369,34 @@ func Test_UpdateJSONMetricHandlerFunc(t *testing.T) {
 				contentType: "application/json",
 			},
 		},
+		{
+			name: "negative unknown type test #3",
+			want: wantArgs{
+				code:    400,
+				wantErr: true,
+				errCode: codeUnknownType,
+			},
+			request: requestArgs{
+				data:        "{\"id\":\"C1\",\"type\":\"unk\",\"delta\":1}",
+				path:        "/update",
+				method:      http.MethodPost,
+				contentType: "application/json",
+			},
+		},
+		{
+			name: "negative unsupported hash version test #4",
+			want: wantArgs{
+				code:    400,
+				wantErr: true,
+				errCode: codeBadValue,
+			},
+			request: requestArgs{
+				data:        "{\"id\":\"C1\",\"type\":\"counter\",\"delta\":1,\"hash_version\":9}",
+				path:        "/update",
+				method:      http.MethodPost,
+				contentType: "application/json",
+			},
+		},
 	}
 
 	var metricStorage storage.Repository = storage.NewRepositoryWrapper(
@@ -336,6 +413,7 @@ func Test_UpdateJSONMetricHandlerFunc(t *testing.T) {
 			// проверяем код ответа
 			assert.Equal(t, tt.want.code, statusCode)
 			if tt.want.wantErr {
+				assertProblem(t, body, tt.want.code, tt.want.errCode)
 				return
 			}
 			assert.EqualValues(t, body, []byte(tt.want.data))
@@ -343,9 +421,48 @@ func Test_UpdateJSONMetricHandlerFunc(t *testing.T) {
 	}
 }
 
+func Test_UpdateJSONMetricHandlerFunc_hashVersion(t *testing.T) {
+	const key = "secret"
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, config.Config{Key: key}))
+	defer ts.Close()
+	hashObject := signer.NewHashObject(key)
+	// в строгом режиме с ключом запрос записи должен быть подписан целиком
+	client := &http.Client{Transport: signer.NewRequestSigner(key).Transport(nil)}
+
+	for _, version := range []int{0, signer.HashV1, signer.HashV2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			metric := model.Metrics{ID: "tiny", MType: gauge, Value: ptr(1e-9), HashVersion: version}
+			assert.NoError(t, hashObject.Sign(&metric))
+			data, err := json.Marshal(metric)
+			assert.NoError(t, err)
+			status, body := testRequestJSONclient(t, client, ts, http.MethodPost, "/update", string(data))
+			assert.Equal(t, http.StatusOK, status)
+
+			var got model.Metrics
+			assert.NoError(t, json.Unmarshal(body, &got))
+			assert.Equal(t, version, got.HashVersion)
+			passed, err := hashObject.Verify(&got)
+			assert.NoError(t, err)
+			assert.True(t, passed)
+		})
+	}
+
+	// подпись v1 не принимается как v2
+	metric := model.Metrics{ID: "tiny", MType: gauge, Value: ptr(1.0)}
+	assert.NoError(t, hashObject.Sign(&metric))
+	metric.HashVersion = signer.HashV2
+	data, err := json.Marshal(metric)
+	assert.NoError(t, err)
+	status, body := testRequestJSONclient(t, client, ts, http.MethodPost, "/update", string(data))
+	assert.Equal(t, http.StatusBadRequest, status)
+	assertProblem(t, body, http.StatusBadRequest, codeSignatureMismatch)
+}
+
 func Test_UpdateJSONMultipleMetricHandlerFunc(t *testing.T) {
 	type wantArgs struct {
 		data    string
+		errCode string
 		code    int
 		wantErr bool
 	}
@@ -379,6 +496,7 @@ func Test_UpdateJSONMultipleMetricHandlerFunc(t *testing.T) {
 			want: wantArgs{
 				code:    400,
 				wantErr: true,
+				errCode: codeBadBody,
 			},
 			request: requestArgs{
 				data:        "[{\"id\":\"G1\",\"type\":\"gauge\",\"value\":100.47},{\"id\":\"C1\",\"type\":\"unk\",\"delta\":1.47}]",
@@ -387,6 +505,20 @@ func Test_UpdateJSONMultipleMetricHandlerFunc(t *testing.T) {
 				contentType: "application/json",
 			},
 		},
+		{
+			name: "negative invalid items test #3",
+			want: wantArgs{
+				code:    400,
+				wantErr: true,
+				errCode: codeInvalidBatch,
+			},
+			request: requestArgs{
+				data:        "[{\"id\":\"G1\",\"type\":\"gauge\",\"value\":1},{\"id\":\"C1\",\"type\":\"unk\",\"delta\":1},{\"id\":\"G2\",\"type\":\"gauge\"}]",
+				path:        "/updates",
+				method:      http.MethodPost,
+				contentType: "application/json",
+			},
+		},
 	}
 
 	var metricStorage storage.Repository = storage.NewRepositoryWrapper(
@@ -403,6 +535,7 @@ func Test_UpdateJSONMultipleMetricHandlerFunc(t *testing.T) {
 			// проверяем код ответа
 			assert.Equal(t, tt.want.code, statusCode)
 			if tt.want.wantErr {
+				assertProblem(t, body, tt.want.code, tt.want.errCode)
 				return
 			}
 			assert.EqualValues(t, body, []byte(tt.want.data))
@@ -410,54 +543,203 @@ func Test_UpdateJSONMultipleMetricHandlerFunc(t *testing.T) {
 	}
 }
 
+func Test_UpdateJSONMultipleMetricHandlerFunc_problemItems(t *testing.T) {
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, config.Config{}))
+	defer ts.Close()
+
+	data := `[{"id":"G1","type":"gauge","value":1},{"id":"C1","type":"unk","delta":1},` +
+		`{"id":"G2","type":"gauge"},{"type":"counter","delta":1}]`
+	statusCode, body := testRequestJSONstring(t, ts, http.MethodPost, "/updates", data)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	p := assertProblem(t, body, http.StatusBadRequest, codeInvalidBatch)
+	assert.Equal(t, "/updates", p.Instance)
+	// пустой id отклоняется при разборе, остальные элементы проверяются уже в обработчике
+	assert.Equal(t, []problemItem{{Index: 3, Code: codeMissingField, Detail: "required field is missing: id"}}, p.Errors)
+
+	data = `[{"id":"G1","type":"gauge","value":1},{"id":"C1","type":"unk","delta":1},{"id":"G2","type":"gauge"}]`
+	statusCode, body = testRequestJSONstring(t, ts, http.MethodPost, "/updates", data)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	p = assertProblem(t, body, http.StatusBadRequest, codeInvalidBatch)
+	assert.Equal(t, []problemItem{
+		{Index: 1, ID: "C1", Code: codeUnknownType, Detail: "unknown metric type 'unk'"},
+		{Index: 2, ID: "G2", Code: codeMissingField, Detail: "required field is missing: value"},
+	}, p.Errors)
+	_, err := metricStorage.GetGauge("G1")
+	assert.Error(t, err, "invalid batch must not be applied partially")
+}
+
+func Test_GetJSONMultipleMetricsHandlerFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		data     string
+		wantCode int
+		wantData string
+	}{
+		{
+			name:     "found and missing values",
+			data:     `[{"id":"G1","type":"gauge"},{"id":"C1","type":"counter"},{"id":"none","type":"gauge"}]`,
+			wantCode: http.StatusOK,
+			wantData: `[{"id":"G1","type":"gauge","value":100.47},{"id":"C1","type":"counter","delta":47},` +
+				`{"id":"none","type":"gauge","not_found":true}]`,
+		},
+		{
+			name:     "signed values",
+			key:      "secret",
+			data:     `[{"id":"C1","type":"counter"}]`,
+			wantCode: http.StatusOK,
+			wantData: `[{"id":"C1","type":"counter","delta":47}]`,
+		},
+		{
+			name:     "unknown type",
+			data:     `[{"id":"G1","type":"histogram"}]`,
+			wantCode: http.StatusBadRequest,
+			wantData: codeInvalidBatch,
+		},
+		{
+			name:     "empty id",
+			data:     `[{"type":"gauge"}]`,
+			key:      "secret",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	_, err := metricStorage.AddGauge("G1", "100.47")
+	assert.NoError(t, err)
+	_, err = metricStorage.AddCounter("C1", "47")
+	assert.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter(metricStorage, &sqlStorageMock{}, config.Config{Key: tt.key})
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			statusCode, body := testRequestJSONstring(t, ts, http.MethodPost, "/values", tt.data)
+			assert.Equal(t, tt.wantCode, statusCode)
+			if tt.wantCode != http.StatusOK {
+				if tt.wantData != "" {
+					assertProblem(t, body, tt.wantCode, tt.wantData)
+				}
+				return
+			}
+			if tt.key != "" {
+				var values []*model.Metrics
+				assert.NoError(t, json.Unmarshal(body, &values))
+				hashObject := signer.NewHashObject(tt.key)
+				for _, v := range values {
+					passed, err := hashObject.Verify(v)
+					assert.NoError(t, err)
+					assert.True(t, passed, v.ID)
+					v.Hash = ""
+				}
+				body, err = json.Marshal(values)
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantData, string(body))
+		})
+	}
+}
+
 func Test_checkMetric(t *testing.T) {
 	type args struct {
 		metric *model.Metrics
 		key    string
+		mode   signer.Mode
+		signed bool
 	}
 	tests := []struct {
 		name    string
 		args    args
 		want    bool
-		wantErr bool
+		wantErr error
 	}{
 		{
 			"test 1 negative",
 			args{
-				&model.Metrics{
+				metric: &model.Metrics{
 					ID:    "TotalMemory",
 					MType: "gauge",
 					Delta: nil,
 					Value: ptr(float64(7268679680)),
 					Hash:  "a3afa5537e12b6a83f982b8a286031b03d26ee12eb43f24c83beacff3ed81f87",
 				},
-				"SuperSecretKey",
+				key: "SuperSecretKey",
 			},
 			false,
-			true,
+			signer.ErrSignatureMismatch,
 		},
 		{
 			"test 2 positive",
 			args{
-				&model.Metrics{
+				metric: &model.Metrics{
 					ID:    "TotalMemory",
 					MType: "gauge",
 					Delta: nil,
 					Value: ptr(float64(7268679680)),
 					Hash:  "aed2c239d3824aa6a0013258d4c748a786b5de3dfa739919c5b741eb1bd30af9",
 				},
-				"SuperSecretKey",
+				key: "SuperSecretKey",
 			},
 			true,
+			nil,
+		},
+		{
+			"test 3 unsigned metric in strict mode",
+			args{
+				metric: &model.Metrics{ID: "TotalMemory", MType: "gauge", Value: ptr(float64(1))},
+				key:    "SuperSecretKey",
+			},
 			false,
+			signer.ErrSignatureMissing,
+		},
+		{
+			"test 4 unsigned metric in permissive mode",
+			args{
+				metric: &model.Metrics{ID: "TotalMemory", MType: "gauge", Value: ptr(float64(1))},
+				key:    "SuperSecretKey",
+				mode:   signer.ModePermissive,
+			},
+			true,
+			nil,
+		},
+		{
+			"test 5 unsigned metric in signed request",
+			args{
+				metric: &model.Metrics{ID: "TotalMemory", MType: "gauge", Value: ptr(float64(1))},
+				key:    "SuperSecretKey",
+				signed: true,
+			},
+			true,
+			nil,
+		},
+		{
+			"test 6 value query needs no signature",
+			args{
+				metric: &model.Metrics{ID: "TotalMemory", MType: "gauge"},
+				key:    "SuperSecretKey",
+			},
+			true,
+			nil,
+		},
+		{
+			"test 7 unsigned metric without key",
+			args{
+				metric: &model.Metrics{ID: "TotalMemory", MType: "gauge", Value: ptr(float64(1))},
+			},
+			true,
+			nil,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := checkMetric(tt.args.metric, tt.args.key)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("checkMetric() error = %v, wantErr %v", err, tt.wantErr)
-				return
+			policy := signer.Policy{Key: tt.args.key, Mode: tt.args.mode}
+			got, err := checkMetric(tt.args.metric, policy, tt.args.signed)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
 			}
 			assert.Equal(t, got, tt.want)
 		})
@@ -541,6 +823,22 @@ func TestGetDBConnState(t *testing.T) {
 	}
 }
 
+func TestGetDBConnStateSQLite(t *testing.T) {
+	sqlite, err := dbstorage.NewSQLiteStorage(context.Background(), filepath.Join(t.TempDir(), "ping.sqlite"), false)
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage() error = %v", err)
+	}
+	defer sqlite.Close()
+
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), sqlite)
+	r := NewRouter(metricStorage, sqlite, config.Config{})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	statusCode, _ := testRequest(t, ts, http.MethodGet, "/ping")
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
 func Test_getAllHandler(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -704,3 +1002,217 @@ func TestGzipMiddleware(t *testing.T) {
 		t.Errorf("Expected status code %d, but got %d", http.StatusOK, recorder.Code)
 	}
 }
+
+func TestRSAMiddleware(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "keys.pem")
+	var pemData []byte
+	for _, k := range []*rsa.PrivateKey{oldKey, key} {
+		pemData = append(pemData, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)})...)
+	}
+	assert.NoError(t, os.WriteFile(keyFile, pemData, 0o600))
+	keys, err := signer.NewKeyring(keyFile)
+	assert.NoError(t, err)
+
+	body := []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`)
+	envelope, err := signer.EncryptEnvelope(&key.PublicKey, body)
+	assert.NoError(t, err)
+	legacy, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey, body, []byte(""))
+	assert.NoError(t, err)
+	keyID := signer.KeyID(&key.PublicKey)
+
+	tests := []struct {
+		name    string
+		scheme  string
+		keyID   string
+		content []byte
+		status  int
+	}{
+		{"envelope", signer.EncryptionHybrid, keyID, envelope, http.StatusOK},
+		{"envelope without key id", signer.EncryptionHybrid, "", envelope, http.StatusOK},
+		{"legacy chunks", "", "", legacy, http.StatusOK},
+		{"legacy chunks with key id", "", keyID, legacy, http.StatusOK},
+		{"wrong key id", signer.EncryptionHybrid, signer.KeyID(&oldKey.PublicKey), envelope, http.StatusBadRequest},
+		{"unknown key id", signer.EncryptionHybrid, "0123456789abcdef", envelope, http.StatusBadRequest},
+		{"envelope as legacy", "", "", envelope, http.StatusBadRequest},
+		{"legacy as envelope", signer.EncryptionHybrid, "", legacy, http.StatusBadRequest},
+		{"unknown scheme", "rot13", "", envelope, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := middleware.RequestID(rsaMiddleware(keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				buf, _ := r.Context().Value(bodyContextKey{}).([]byte)
+				assert.Equal(t, body, buf)
+			})))
+			request := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+			if tt.scheme != "" {
+				request.Header.Set(signer.EncryptionHeader, tt.scheme)
+			}
+			if tt.keyID != "" {
+				request.Header.Set(signer.KeyIDHeader, tt.keyID)
+			}
+			request = request.WithContext(context.WithValue(request.Context(), bodyContextKey{}, tt.content))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if tt.status != http.StatusOK {
+				assertProblem(t, recorder.Body.Bytes(), tt.status, codeDecrypt)
+				return
+			}
+			assert.Equal(t, tt.status, recorder.Code)
+		})
+	}
+}
+
+func TestRequestSignatureMiddleware(t *testing.T) {
+	const key = "secret"
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, config.Config{Key: key}))
+	defer ts.Close()
+	requestSigner := signer.NewRequestSigner(key)
+	// агент подписывает и метрики, и запрос целиком
+	metric := model.Metrics{ID: "PollCount", MType: counter, Delta: ptr(int64(1)), HashVersion: signer.HashV2}
+	hashObject := signer.NewHashObject(key)
+	assert.NoError(t, hashObject.Sign(&metric))
+	body, err := json.Marshal([]model.Metrics{metric})
+	assert.NoError(t, err)
+
+	send := func(header http.Header, body []byte) (int, []byte) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", bytes.NewReader(body))
+		assert.NoError(t, err)
+		req.Header = header.Clone()
+		req.Header.Set(keyCT, keyValueJSON)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, data
+	}
+	signed := func() http.Header {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+		assert.NoError(t, requestSigner.Sign(req, body))
+		return req.Header
+	}
+
+	header := signed()
+	status, _ := send(header, body)
+	assert.Equal(t, http.StatusOK, status)
+	status, data := send(header, body)
+	assertProblem(t, data, http.StatusConflict, codeReplayedRequest)
+	assert.Equal(t, http.StatusConflict, status)
+
+	_, data = send(signed(), []byte(`[{"id":"PollCount","type":"counter","delta":100}]`))
+	assertProblem(t, data, http.StatusBadRequest, codeSignatureMismatch)
+
+	// перехваченный запрос без заголовков подписи нельзя повторить, хотя метрики подписаны
+	status, data = send(http.Header{}, body)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assertProblem(t, data, http.StatusBadRequest, codeSignatureRequired)
+	for _, path := range []string{"/update/", "/api/v2/updates"} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set(keyCT, keyValueJSON)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		data, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assertProblem(t, data, http.StatusBadRequest, codeSignatureRequired)
+	}
+
+	header = signed()
+	header.Set(signer.RequestTimestampHeader, "1000000000")
+	_, data = send(header, body)
+	assertProblem(t, data, http.StatusBadRequest, codeStaleRequest)
+
+	delta, err := metricStorage.GetCounter("PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), delta)
+}
+
+func TestAgentSignatureMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "host-1.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+	registry, err := signer.NewAgentRegistry(dir)
+	assert.NoError(t, err)
+	_, stranger, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, config.Config{AgentKeys: registry}))
+	defer ts.Close()
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1},{"id":"Alloc","type":"gauge","value":2}]`)
+
+	send := func(s *signer.AgentSigner, body []byte) (int, []byte) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", bytes.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set(keyCT, keyValueJSON)
+		client := http.DefaultClient
+		if s != nil {
+			client = &http.Client{Transport: s.Transport(nil)}
+		}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, data
+	}
+	stored := func() int64 {
+		v, ok := signer.AgentMetrics.Get("host-1").(*expvar.Int)
+		if !ok {
+			return 0
+		}
+		return v.Value()
+	}
+
+	before := stored()
+	status, _ := send(signer.NewAgentSigner("host-1", key), body)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, before+2, stored())
+
+	_, data := send(signer.NewAgentSigner("host-1", stranger), body)
+	assertProblem(t, data, http.StatusBadRequest, codeSignatureMismatch)
+	_, data = send(signer.NewAgentSigner("host-2", stranger), body)
+	assertProblem(t, data, http.StatusForbidden, codeUnknownAgent)
+
+	// реестр агентов делает подпись обязательной в строгом режиме
+	_, data = send(nil, body)
+	assertProblem(t, data, http.StatusBadRequest, codeInvalidBatch)
+
+	delta, err := metricStorage.GetCounter("PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), delta)
+}
+
+func TestProfilerMount(t *testing.T) {
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	tests := []struct {
+		name   string
+		router http.Handler
+		status int
+	}{
+		{"disabled by default", NewRouter(metricStorage, &sqlStorageMock{}, config.Config{}), http.StatusNotFound},
+		{"main port", NewRouter(metricStorage, &sqlStorageMock{}, config.Config{Profiler: true}), http.StatusOK},
+		{"moved to admin listener", NewRouter(metricStorage, &sqlStorageMock{},
+			config.Config{Profiler: true, AdminAddress: "127.0.0.1:6060"}), http.StatusNotFound},
+		{"admin listener", NewAdminRouter(), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"/debug/vars", "/debug/pprof/"} {
+				w := httptest.NewRecorder()
+				tt.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				assert.Equal(t, tt.status, w.Code, path)
+			}
+		})
+	}
+}
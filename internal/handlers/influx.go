@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/influx"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+)
+
+// WriteInfluxHandlerFunc принимает метрики в текстовом протоколе InfluxDB (line protocol).
+// Тело запроса должно быть подготовлено gzipMiddleware. Все точки применяются одним вызовом AddMetrics.
+func WriteInfluxHandlerFunc(
+	metricStorage storage.Repository,
+	opts influx.Options,
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		content, ok := r.Context().Value(bodyContextKey{}).([]byte)
+		if !ok {
+			log.Printf("Error: [WriteInfluxHandlerFunc] Request body not found in context status-'500'")
+			writeProblem(w, r, http.StatusInternalServerError, codeInternal, missingContextMessage)
+			return
+		}
+		metrics, err := influx.Parse(bytes.NewReader(content), opts)
+		if err != nil {
+			log.Printf("Error: [WriteInfluxHandlerFunc] line protocol parse error: %v", err)
+			writeProblem(w, r, http.StatusBadRequest, codeBadBody, err.Error())
+			return
+		}
+		var items []problemItem
+		for i, m := range metrics {
+			if aerr := authorizeMetric(r, m.ID); aerr != nil {
+				items = append(items, newProblemItem(i, m.ID, aerr))
+			}
+		}
+		if len(items) > 0 {
+			writeProblem(w, r, http.StatusForbidden, codeForbidden, "Token has no access to some metrics", items...)
+			return
+		}
+		if err = metricStorage.AddMetrics(metrics); err != nil {
+			log.Printf("Error: [WriteInfluxHandlerFunc] Add multiple metrics error: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, codeStorage, "Failed to store metrics")
+			return
+		}
+		attributeMetrics(r, len(metrics))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
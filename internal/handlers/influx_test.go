@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rebus2015/praktikum-devops/internal/config"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+func TestWriteInfluxHandlerFunc(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		gzip       bool
+		tagsPrefix bool
+		counters   bool
+		wantStatus int
+		wantGauge  string
+		wantValue  float64
+	}{
+		{
+			name:       "tags as labels",
+			body:       "cpu,host=server01 usage=12.5,count=2i\ncpu,host=server01 count=3i",
+			wantStatus: http.StatusNoContent,
+			wantGauge:  `cpu_usage{host="server01"}`,
+			wantValue:  12.5,
+		},
+		{
+			name:       "integers as counters",
+			body:       "cpu,host=server01 usage=12.5,count=2i\ncpu,host=server01 count=3i",
+			counters:   true,
+			wantStatus: http.StatusNoContent,
+			wantGauge:  `cpu_usage{host="server01"}`,
+			wantValue:  12.5,
+		},
+		{
+			name:       "gzip body, tags as prefix",
+			body:       "mem,host=server02 value=64",
+			gzip:       true,
+			tagsPrefix: true,
+			wantStatus: http.StatusNoContent,
+			wantGauge:  "server02.mem",
+			wantValue:  64,
+		},
+		{
+			name:       "syntax error",
+			body:       "cpu usage=abc",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+			r := NewRouter(metricStorage, &sqlStorageMock{}, config.Config{InfluxTagsAsPrefix: tt.tagsPrefix, InfluxCounters: tt.counters})
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			body := []byte(tt.body)
+			if tt.gzip {
+				var buf bytes.Buffer
+				zw := gzip.NewWriter(&buf)
+				_, err := zw.Write(body)
+				assert.NoError(t, err)
+				assert.NoError(t, zw.Close())
+				body = buf.Bytes()
+			}
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/write", bytes.NewReader(body))
+			assert.NoError(t, err)
+			req.Header.Set(keyCT, "text/plain")
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", compressed)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantGauge == "" {
+				return
+			}
+			val, err := metricStorage.GetGauge(tt.wantGauge)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantValue, val)
+			switch {
+			case tt.counters:
+				cnt, err := metricStorage.GetCounter(`cpu_count{host="server01"}`)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(5), cnt)
+			case !tt.tagsPrefix:
+				// целые поля по умолчанию — абсолютные показания, сохраняется последнее
+				val, err := metricStorage.GetGauge(`cpu_count{host="server01"}`)
+				assert.NoError(t, err)
+				assert.Equal(t, 3.0, val)
+			}
+		})
+	}
+}
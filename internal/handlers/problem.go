@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/auth"
+	"github.com/rebus2015/praktikum-devops/internal/signer"
+)
+
+const (
+	problemContentType string = "application/problem+json"
+	problemTypePrefix  string = "urn:praktikum-devops:problem:"
+)
+
+// Коды ошибок API. Значения стабильны: клиенты могут опираться на них вместо текста сообщения.
+const (
+	codeInternal          string = "internal_error"
+	codeStorage           string = "storage_error"
+	codeUnavailable       string = "unavailable"
+	codeBadBody           string = "invalid_body"
+	codeDecrypt           string = "decrypt_failed"
+	codeBadQuery          string = "invalid_query"
+	codeMissingField      string = "missing_field"
+	codeBadValue          string = "invalid_value"
+	codeUnknownType       string = "unknown_metric_type"
+	codeNotFound          string = "metric_not_found"
+	codeSignatureMismatch string = "signature_mismatch"
+	codeDatabase          string = "database_unavailable"
+	codeInvalidBatch      string = "invalid_batch"
+	codeUnsupportedMedia  string = "unsupported_media_type"
+	codeNotAcceptable     string = "not_acceptable"
+	codeStaleRequest      string = "stale_request"
+	codeReplayedRequest   string = "replayed_request"
+	codeSignatureRequired string = "signature_required"
+	codeUnknownAgent      string = "unknown_agent"
+	codeUnauthorized      string = "unauthorized"
+	codeForbidden         string = "forbidden"
+	codeUntrustedSubnet   string = "untrusted_subnet"
+)
+
+var (
+	errMissingField = errors.New("required field is missing")
+	errUnknownType  = errors.New("unknown metric type")
+)
+
+// problem тело ответа об ошибке в формате RFC 7807 (application/problem+json).
+type problem struct {
+	Type      string        `json:"type"`
+	Title     string        `json:"title"`
+	Detail    string        `json:"detail,omitempty"`
+	Instance  string        `json:"instance,omitempty"`
+	Code      string        `json:"code"`
+	RequestID string        `json:"request_id,omitempty"`
+	Errors    []problemItem `json:"errors,omitempty"`
+	Status    int           `json:"status"`
+}
+
+// problemItem ошибка отдельного элемента пакетного запроса.
+type problemItem struct {
+	ID     string `json:"id,omitempty"`
+	Code   string `json:"code"`
+	Detail string `json:"detail,omitempty"`
+	Index  int    `json:"index"`
+}
+
+// writeProblem отвечает ошибкой в формате problem+json. detail попадает к клиенту как есть,
+// поэтому тексты внутренних ошибок в него передавать не следует — их нужно писать в лог.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string, items ...problemItem) {
+	p := problem{
+		Type:      problemTypePrefix + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: middleware.GetReqID(r.Context()),
+		Errors:    items,
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set(keyCT, problemContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Printf("Error: [writeProblem] encode problem error: %v", err)
+	}
+}
+
+// problemCode возвращает код ошибки проверки метрики.
+func problemCode(err error) string {
+	switch {
+	case errors.Is(err, errMissingField):
+		return codeMissingField
+	case errors.Is(err, errUnknownType):
+		return codeUnknownType
+	case errors.Is(err, signer.ErrSignatureMismatch):
+		return codeSignatureMismatch
+	case errors.Is(err, signer.ErrSignatureMissing):
+		return codeSignatureRequired
+	case errors.Is(err, auth.ErrForbidden):
+		return codeForbidden
+	default:
+		return codeBadValue
+	}
+}
+
+// problemStatus возвращает статус ответа для ошибки проверки одиночной метрики.
+func problemStatus(err error) int {
+	if errors.Is(err, auth.ErrForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+func newProblemItem(index int, id string, err error) problemItem {
+	return problemItem{Index: index, ID: id, Code: problemCode(err), Detail: err.Error()}
+}
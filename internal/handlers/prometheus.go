@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+)
+
+const (
+	prometheusContentType  string = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType string = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	openMetricsMediaType   string = "application/openmetrics-text"
+)
+
+// promFamily группа метрик с одинаковым именем и типом.
+type promFamily struct {
+	name    string
+	mtype   string
+	samples []promSample
+}
+
+type promSample struct {
+	labels []model.Label
+	value  string
+}
+
+// GetPrometheusHandler возвращает все метрики в текстовом формате Prometheus,
+// либо в формате OpenMetrics, если клиент запросил его в заголовке Accept.
+func GetPrometheusHandler(metricStorage storage.Repository) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics, err := metricStorage.GetMetrics()
+		if err != nil {
+			log.Printf("Error: [GetPrometheusHandler] get metrics error: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, codeStorage, "Failed to read metrics")
+			return
+		}
+		openMetrics := strings.Contains(r.Header.Get("Accept"), openMetricsMediaType)
+		if openMetrics {
+			w.Header().Set(keyCT, openMetricsContentType)
+		} else {
+			w.Header().Set(keyCT, prometheusContentType)
+		}
+		w.WriteHeader(http.StatusOK)
+
+		bw := bufio.NewWriter(w)
+		writeExposition(bw, promFamilies(metrics), openMetrics)
+		if err = bw.Flush(); err != nil {
+			log.Printf("Error: [GetPrometheusHandler] write response error: %v", err)
+		}
+	}
+}
+
+// promFamilies группирует метрики по очищенному имени. Метрика, имя которой совпало
+// с семейством другого типа, пропускается: Prometheus не допускает таких конфликтов.
+// Так же пропускаются метрики, которые после очистки имен совпали с уже добавленной серией
+// (например, a.b и a b) или получили повторяющиеся имена меток: дубликаты делают
+// некорректной всю выдачу.
+func promFamilies(metrics []model.Metrics) []*promFamily {
+	families := []*promFamily{}
+	index := map[string]*promFamily{}
+	series := map[string]string{}
+	for i := range metrics {
+		m := &metrics[i]
+		base, labels := model.ParseID(m.ID)
+		name := sanitizeMetricName(base)
+		var value string
+		switch m.MType {
+		case counter:
+			if m.Delta == nil {
+				continue
+			}
+			value = strconv.FormatInt(*m.Delta, 10)
+		case gauge:
+			if m.Value == nil {
+				continue
+			}
+			value = formatPromFloat(*m.Value)
+		default:
+			continue
+		}
+		for j := range labels {
+			labels[j].Name = sanitizeLabelName(labels[j].Name)
+		}
+		key, ok := seriesKey(name, labels)
+		if !ok {
+			log.Printf("Prometheus exposition: %s '%s' has duplicate label names after sanitizing, skipped", m.MType, m.ID)
+			continue
+		}
+		if prev, dup := series[key]; dup {
+			log.Printf("Prometheus exposition: %s '%s' collides with '%s' after sanitizing, skipped", m.MType, m.ID, prev)
+			continue
+		}
+		f, ok := index[name]
+		if !ok {
+			f = &promFamily{name: name, mtype: m.MType}
+			index[name] = f
+			families = append(families, f)
+		}
+		if f.mtype != m.MType {
+			log.Printf("Prometheus exposition: %s '%s' conflicts with %s family '%s', skipped", m.MType, m.ID, f.mtype, name)
+			continue
+		}
+		series[key] = m.ID
+		f.samples = append(f.samples, promSample{labels: labels, value: value})
+	}
+	return families
+}
+
+// seriesKey возвращает ключ серии, не зависящий от порядка меток.
+// Возвращает false, если имена меток повторяются.
+func seriesKey(name string, labels []model.Label) (string, bool) {
+	sorted := make([]model.Label, len(labels))
+	copy(sorted, labels)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	var b strings.Builder
+	b.WriteString(name)
+	for i, l := range sorted {
+		if i > 0 && sorted[i-1].Name == l.Name {
+			return "", false
+		}
+		b.WriteByte(0)
+		b.WriteString(l.Name)
+		b.WriteByte(0)
+		b.WriteString(l.Value)
+	}
+	return b.String(), true
+}
+
+func writeExposition(w *bufio.Writer, families []*promFamily, openMetrics bool) {
+	for _, f := range families {
+		familyName, sampleName := f.name, f.name
+		if openMetrics && f.mtype == counter {
+			// в OpenMetrics имя семейства счетчика не содержит суффикса _total, а имя значения обязано его иметь
+			familyName = strings.TrimSuffix(f.name, "_total")
+			sampleName = familyName + "_total"
+		}
+		w.WriteString("# TYPE ")
+		w.WriteString(familyName)
+		w.WriteByte(' ')
+		w.WriteString(f.mtype)
+		w.WriteByte('\n')
+		for _, s := range f.samples {
+			w.WriteString(sampleName)
+			if len(s.labels) != 0 {
+				w.WriteByte('{')
+				for i, l := range s.labels {
+					if i > 0 {
+						w.WriteByte(',')
+					}
+					w.WriteString(l.Name)
+					w.WriteString(`="`)
+					w.WriteString(model.EscapeLabelValue(l.Value))
+					w.WriteByte('"')
+				}
+				w.WriteByte('}')
+			}
+			w.WriteByte(' ')
+			w.WriteString(s.value)
+			w.WriteByte('\n')
+		}
+	}
+	if openMetrics {
+		w.WriteString("# EOF\n")
+	}
+}
+
+// sanitizeMetricName приводит имя к виду [a-zA-Z_:][a-zA-Z0-9_:]*.
+func sanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// sanitizeLabelName приводит имя метки к виду [a-zA-Z_][a-zA-Z0-9_]*.
+func sanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+func sanitizeName(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' ||
+			(c >= 'a' && c <= 'z') ||
+			(c >= 'A' && c <= 'Z') ||
+			(c >= '0' && c <= '9') ||
+			(c == ':' && allowColon)
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	if b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+func formatPromFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rebus2015/praktikum-devops/internal/config"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+func TestGetPrometheusHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		wantCT   string
+		wantBody string
+	}{
+		{
+			name:   "prometheus text format",
+			wantCT: prometheusContentType,
+			wantBody: `# TYPE PollCount counter
+PollCount 5
+# TYPE requests counter
+requests{method="GET",code="200"} 7
+# TYPE _1st_metric gauge
+_1st_metric 0.001
+# TYPE Alloc gauge
+Alloc 1024.5
+# TYPE cpu_usage gauge
+cpu_usage{host="a\"b"} 12
+`,
+		},
+		{
+			name:   "openmetrics format",
+			accept: "application/openmetrics-text;version=1.0.0",
+			wantCT: openMetricsContentType,
+			wantBody: `# TYPE PollCount counter
+PollCount_total 5
+# TYPE requests counter
+requests_total{method="GET",code="200"} 7
+# TYPE _1st_metric gauge
+_1st_metric 0.001
+# TYPE Alloc gauge
+Alloc 1024.5
+# TYPE cpu_usage gauge
+cpu_usage{host="a\"b"} 12
+# EOF
+`,
+		},
+	}
+
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	for _, c := range []struct{ name, val string }{
+		{"PollCount", "5"},
+		{`requests{method="GET",code="200"}`, "7"},
+	} {
+		_, err := metricStorage.AddCounter(c.name, c.val)
+		assert.NoError(t, err)
+	}
+	for _, g := range []struct{ name, val string }{
+		{"Alloc", "1024.5"},
+		{"1st.metric", "0.001"},
+		{`cpu-usage{host="a\"b"}`, "12"},
+		{"PollCount", "1"},               // конфликт с одноименным счетчиком
+		{`cpu.usage{host="a\"b"}`, "13"}, // совпадает с cpu-usage после очистки имени
+		{`temp{a.b="1",a-b="2"}`, "20"},  // повторяющиеся имена меток после очистки
+	} {
+		_, err := metricStorage.AddGauge(g.name, g.val)
+		assert.NoError(t, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter(metricStorage, &sqlStorageMock{}, config.Config{})
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/metrics", nil)
+			assert.NoError(t, err)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, tt.wantCT, resp.Header.Get(keyCT))
+			assert.Equal(t, tt.wantBody, string(body))
+		})
+	}
+}
+
+func Test_sanitizeMetricName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Alloc", "Alloc"},
+		{"http.requests-total", "http_requests_total"},
+		{"ns:sub:metric", "ns:sub:metric"},
+		{"9lives", "_9lives"},
+		{"счетчик", "______________"},
+		{"", "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizeMetricName(tt.name))
+		})
+	}
+	assert.Equal(t, "a_b", sanitizeLabelName("a:b"))
+}
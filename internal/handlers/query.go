@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+const (
+	defaultListLimit int = 100
+	maxListLimit     int = 1000
+)
+
+var errBadQuery = errors.New("bad query parameter")
+
+// metricsList ответ GET /api/v1/metrics.
+type metricsList struct {
+	Metrics    []jsonMetric `json:"metrics"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+	Total      int          `json:"total"`
+}
+
+// jsonMetric метрика в JSON-ответе. JSON не допускает NaN и ±Inf, поэтому такие значения gauge
+// передаются строками "NaN", "+Inf" и "-Inf", как в формате Prometheus.
+type jsonMetric model.Metrics
+
+func (m jsonMetric) MarshalJSON() ([]byte, error) {
+	type plain model.Metrics
+	if m.Value == nil || !math.IsNaN(*m.Value) && !math.IsInf(*m.Value, 0) {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Value string `json:"value"`
+	}{plain: plain(m), Value: strconv.FormatFloat(*m.Value, 'g', -1, 64)})
+}
+
+// jsonMetrics преобразует метрики для JSON-ответа.
+func jsonMetrics(metrics []model.Metrics) []jsonMetric {
+	res := make([]jsonMetric, len(metrics))
+	for i := range metrics {
+		res[i] = jsonMetric(metrics[i])
+	}
+	return res
+}
+
+// ListMetricsHandler возвращает список метрик в виде model.Metrics.
+//
+// Параметры запроса:
+//
+//	type            counter или gauge
+//	name            шаблон имени (glob): cpu_*, Alloc?
+//	regex           регулярное выражение для имени
+//	updated_within  только метрики, обновленные за указанный период (5m)
+//	stale_for       только метрики, не обновлявшиеся указанный период, включая восстановленные
+//	sort            id, type, value или updated; префикс '-' задает обратный порядок
+//	limit           размер страницы, по умолчанию 100, не более 1000
+//	cursor          значение next_cursor предыдущей страницы
+func ListMetricsHandler(metricStorage storage.Repository) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseListQuery(r.URL.Query(), time.Now())
+		if err != nil {
+			log.Printf("Error: [ListMetricsHandler] %v", err)
+			writeProblem(w, r, http.StatusBadRequest, codeBadQuery, err.Error())
+			return
+		}
+		page, err := metricStorage.QueryMetrics(q)
+		if err != nil {
+			log.Printf("Error: [ListMetricsHandler] query metrics error: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, codeStorage, "Failed to query metrics")
+			return
+		}
+		resp := metricsList{Metrics: jsonMetrics(page.Metrics), Total: page.Total}
+		if page.Next != nil {
+			if resp.NextCursor, err = page.Next.Encode(); err != nil {
+				log.Printf("Error: [ListMetricsHandler] cursor encode error: %v", err)
+				writeProblem(w, r, http.StatusInternalServerError, codeInternal, "Failed to encode cursor")
+				return
+			}
+		}
+		w.Header().Set(keyCT, keyValueJSON)
+		w.WriteHeader(http.StatusOK)
+		if err = json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("Error: [ListMetricsHandler] encode response error: %v", err)
+		}
+	}
+}
+
+func parseListQuery(values url.Values, now time.Time) (memstorage.Query, error) {
+	q := memstorage.Query{Limit: defaultListLimit, Sort: memstorage.SortID}
+
+	switch mtype := values.Get("type"); mtype {
+	case "", counter, gauge:
+		q.Type = mtype
+	default:
+		return q, fmt.Errorf("%w: unknown metric type '%s'", errBadQuery, mtype)
+	}
+
+	var matchers []func(string) bool
+	if glob := values.Get("name"); glob != "" {
+		if _, err := path.Match(glob, ""); err != nil {
+			return q, fmt.Errorf("%w: name: %w", errBadQuery, err)
+		}
+		matchers = append(matchers, func(id string) bool {
+			ok, _ := path.Match(glob, id)
+			return ok
+		})
+	}
+	if expr := values.Get("regex"); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return q, fmt.Errorf("%w: regex: %w", errBadQuery, err)
+		}
+		matchers = append(matchers, re.MatchString)
+	}
+	if len(matchers) != 0 {
+		q.Match = func(id string) bool {
+			for _, match := range matchers {
+				if !match(id) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	if v := values.Get("updated_within"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return q, fmt.Errorf("%w: bad updated_within '%s'", errBadQuery, v)
+		}
+		q.UpdatedSince = now.Add(-d)
+	}
+	if v := values.Get("stale_for"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return q, fmt.Errorf("%w: bad stale_for '%s'", errBadQuery, v)
+		}
+		q.StaleSince = now.Add(-d)
+	}
+
+	if v := values.Get("sort"); v != "" {
+		q.Sort, q.Desc = strings.TrimPrefix(v, "-"), strings.HasPrefix(v, "-")
+		switch q.Sort {
+		case memstorage.SortID, memstorage.SortType, memstorage.SortValue, memstorage.SortUpdated:
+		default:
+			return q, fmt.Errorf("%w: unknown sort field '%s'", errBadQuery, q.Sort)
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			return q, fmt.Errorf("%w: limit must be in range 1..%d", errBadQuery, maxListLimit)
+		}
+		q.Limit = limit
+	}
+	if v := values.Get("cursor"); v != "" {
+		c, err := memstorage.ParseCursor(v)
+		if err != nil {
+			return q, fmt.Errorf("%w: %w", errBadQuery, err)
+		}
+		if c.Sort != q.Sort || c.Desc != q.Desc {
+			return q, fmt.Errorf("%w: cursor was issued for a different sort order", errBadQuery)
+		}
+		q.After = c
+	}
+	return q, nil
+}
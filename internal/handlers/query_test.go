@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/config"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+func TestListMetricsHandler(t *testing.T) {
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	for _, c := range []struct{ name, val string }{{"PollCount", "5"}, {"requests", "7"}} {
+		_, err := metricStorage.AddCounter(c.name, c.val)
+		require.NoError(t, err)
+	}
+	for _, g := range []struct{ name, val string }{{"Alloc", "1.5"}, {"cpu_user", "3"}, {"cpu_system", "9"}} {
+		_, err := metricStorage.AddGauge(g.name, g.val)
+		require.NoError(t, err)
+	}
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, config.Config{}))
+	defer ts.Close()
+
+	get := func(query string) (int, metricsList) {
+		resp, err := http.Get(ts.URL + "/api/v1/metrics?" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var list metricsList
+		if resp.StatusCode == http.StatusOK {
+			assert.Equal(t, keyValueJSON, resp.Header.Get(keyCT))
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+		}
+		return resp.StatusCode, list
+	}
+	listIDs := func(list metricsList) []string {
+		res := make([]string, len(list.Metrics))
+		for i, m := range list.Metrics {
+			res[i] = m.ID
+		}
+		return res
+	}
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		wantStatus int
+		want       []string
+	}{
+		{name: "all", query: url.Values{}, wantStatus: http.StatusOK,
+			want: []string{"Alloc", "PollCount", "cpu_system", "cpu_user", "requests"}},
+		{name: "type", query: url.Values{"type": {"counter"}}, wantStatus: http.StatusOK,
+			want: []string{"PollCount", "requests"}},
+		{name: "glob", query: url.Values{"name": {"cpu_*"}, "sort": {"-value"}}, wantStatus: http.StatusOK,
+			want: []string{"cpu_system", "cpu_user"}},
+		{name: "regex", query: url.Values{"regex": {"^[A-Z]"}}, wantStatus: http.StatusOK,
+			want: []string{"Alloc", "PollCount"}},
+		{name: "fresh", query: url.Values{"updated_within": {"1h"}, "type": {"gauge"}}, wantStatus: http.StatusOK,
+			want: []string{"Alloc", "cpu_system", "cpu_user"}},
+		{name: "stale", query: url.Values{"stale_for": {"1h"}}, wantStatus: http.StatusOK, want: []string{}},
+		{name: "bad type", query: url.Values{"type": {"histogram"}}, wantStatus: http.StatusBadRequest},
+		{name: "bad glob", query: url.Values{"name": {"[a"}}, wantStatus: http.StatusBadRequest},
+		{name: "bad regex", query: url.Values{"regex": {"("}}, wantStatus: http.StatusBadRequest},
+		{name: "bad sort", query: url.Values{"sort": {"color"}}, wantStatus: http.StatusBadRequest},
+		{name: "bad limit", query: url.Values{"limit": {"5000"}}, wantStatus: http.StatusBadRequest},
+		{name: "bad duration", query: url.Values{"stale_for": {"soon"}}, wantStatus: http.StatusBadRequest},
+		{name: "bad cursor", query: url.Values{"cursor": {"!!"}}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, list := get(tt.query.Encode())
+			assert.Equal(t, tt.wantStatus, status)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.want, listIDs(list))
+				assert.Empty(t, list.NextCursor)
+			}
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		status, first := get("limit=2&sort=-id")
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []string{"requests", "cpu_user"}, listIDs(first))
+		assert.Equal(t, 5, first.Total)
+		require.NotEmpty(t, first.NextCursor)
+
+		status, _ = get("limit=2&sort=id&cursor=" + first.NextCursor)
+		assert.Equal(t, http.StatusBadRequest, status, "cursor must match sort order")
+
+		status, second := get("limit=2&sort=-id&cursor=" + first.NextCursor)
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []string{"cpu_system", "PollCount"}, listIDs(second))
+
+		status, last := get("limit=2&sort=-id&cursor=" + second.NextCursor)
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []string{"Alloc"}, listIDs(last))
+		assert.Empty(t, last.NextCursor)
+	})
+}
+
+func TestListMetricsHandler_nonFinite(t *testing.T) {
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	for _, g := range []struct{ name, val string }{{"a", "NaN"}, {"b", "+Inf"}, {"c", "-Inf"}, {"d", "1"}} {
+		_, err := metricStorage.AddGauge(g.name, g.val)
+		require.NoError(t, err)
+	}
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, config.Config{}))
+	defer ts.Close()
+
+	// страницы по одной метрике: курсор каждой страницы указывает на нечисловое значение
+	var values []any
+	query := url.Values{"sort": {"value"}, "limit": {"1"}}
+	for {
+		resp, err := http.Get(ts.URL + "/api/v1/metrics?" + query.Encode())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var list struct {
+			Metrics []struct {
+				Value any `json:"value"`
+			} `json:"metrics"`
+			NextCursor string `json:"next_cursor"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+		resp.Body.Close()
+		require.Len(t, list.Metrics, 1)
+		values = append(values, list.Metrics[0].Value)
+		if list.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", list.NextCursor)
+	}
+	assert.Equal(t, []any{"NaN", "-Inf", 1.0, "+Inf"}, values)
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/stream"
+)
+
+const (
+	eventStreamContentType string        = "text/event-stream"
+	streamHeartbeat        time.Duration = 15 * time.Second
+	wsWriteTimeout         time.Duration = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// streamControl служебное сообщение потока: reset — часть истории потеряна и состояние нужно
+// перечитать, lagged — клиент не успевал читать события и был отключен.
+type streamControl struct {
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq"`
+}
+
+// streamEvent stream.Event в формате JSON. Значение метрики кодируется как jsonMetric:
+// изменение gauge на NaN или ±Inf не должно обрывать поток подписчиков.
+type streamEvent struct {
+	Time   time.Time  `json:"time"`
+	Metric jsonMetric `json:"metric"`
+	Seq    uint64     `json:"seq"`
+}
+
+func newStreamEvent(e stream.Event) streamEvent {
+	return streamEvent{Time: e.Time, Metric: jsonMetric(e.Metric), Seq: e.Seq}
+}
+
+// parseStreamRequest разбирает фильтр подписки и номер, с которого нужно продолжить.
+//
+// Параметры запроса: type (counter или gauge), name (шаблон glob), since (номер последнего
+// полученного события). Для SSE номер также берется из заголовка Last-Event-ID.
+func parseStreamRequest(r *http.Request) (stream.Filter, uint64, error) {
+	values := r.URL.Query()
+	filter := stream.Filter{}
+	switch mtype := values.Get("type"); mtype {
+	case "", counter, gauge:
+		filter.Type = mtype
+	default:
+		return filter, 0, fmt.Errorf("%w: unknown metric type '%s'", errBadQuery, mtype)
+	}
+	if glob := values.Get("name"); glob != "" {
+		if _, err := path.Match(glob, ""); err != nil {
+			return filter, 0, fmt.Errorf("%w: name: %w", errBadQuery, err)
+		}
+		filter.Match = func(id string) bool {
+			ok, _ := path.Match(glob, id)
+			return ok
+		}
+	}
+	since := values.Get("since")
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		since = lastID
+	}
+	if since == "" {
+		return filter, 0, nil
+	}
+	seq, err := strconv.ParseUint(since, 10, 64)
+	if err != nil {
+		return filter, 0, fmt.Errorf("%w: bad sequence number '%s'", errBadQuery, since)
+	}
+	return filter, seq, nil
+}
+
+// StreamSSEHandler передает изменения метрик в формате Server-Sent Events.
+// Каждое событие metric содержит stream.Event (streamEvent), поле id — его номер.
+func StreamSSEHandler(metricStorage storage.Repository) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, since, err := parseStreamRequest(r)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, codeBadQuery, err.Error())
+			return
+		}
+		broker := metricStorage.Stream()
+		sub, err := broker.Subscribe(filter, since)
+		if err != nil {
+			writeProblem(w, r, http.StatusServiceUnavailable, codeUnavailable, "Metric stream is closed")
+			return
+		}
+		defer sub.Close()
+
+		rc := http.NewResponseController(w)
+		// поток живет дольше WriteTimeout сервера
+		if err = rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("Error: [StreamSSEHandler] reset write deadline: %v", err)
+		}
+		w.Header().Set(keyCT, eventStreamContentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if sub.Gap {
+			if err = writeSSE(w, "reset", "", streamControl{Type: "reset", Seq: broker.Seq()}); err != nil {
+				return
+			}
+		}
+		if err = rc.Flush(); err != nil {
+			log.Printf("Error: [StreamSSEHandler] flush: %v", err)
+			return
+		}
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				_, err = fmt.Fprint(w, ": ping\n\n")
+			case e, ok := <-sub.Events():
+				if !ok {
+					if errors.Is(sub.Err(), stream.ErrLagged) {
+						if writeSSE(w, "lagged", "", streamControl{Type: "lagged", Seq: broker.Seq()}) == nil {
+							_ = rc.Flush()
+						}
+					}
+					return
+				}
+				err = writeSSE(w, "metric", strconv.FormatUint(e.Seq, 10), newStreamEvent(e))
+			}
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, event, id string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("sse marshal error: %w", err)
+	}
+	if id != "" {
+		if _, err = fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return fmt.Errorf("sse write error: %w", err)
+		}
+	}
+	if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return fmt.Errorf("sse write error: %w", err)
+	}
+	return nil
+}
+
+// StreamWSHandler передает изменения метрик через WebSocket. Каждое текстовое сообщение —
+// stream.Event в формате JSON (streamEvent) либо служебное сообщение streamControl.
+func StreamWSHandler(metricStorage storage.Repository) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, since, err := parseStreamRequest(r)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, codeBadQuery, err.Error())
+			return
+		}
+		broker := metricStorage.Stream()
+		sub, err := broker.Subscribe(filter, since)
+		if err != nil {
+			writeProblem(w, r, http.StatusServiceUnavailable, codeUnavailable, "Metric stream is closed")
+			return
+		}
+		defer sub.Close()
+
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade уже ответил клиенту ошибкой
+			log.Printf("Error: [StreamWSHandler] upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		// входящие сообщения не ожидаются, чтение нужно для обработки ping/close
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		send := func(v any) error {
+			if err := conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+				return fmt.Errorf("websocket deadline error: %w", err)
+			}
+			if err := conn.WriteJSON(v); err != nil {
+				return fmt.Errorf("websocket write error: %w", err)
+			}
+			return nil
+		}
+		if sub.Gap {
+			if err = send(streamControl{Type: "reset", Seq: broker.Seq()}); err != nil {
+				return
+			}
+		}
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case <-heartbeat.C:
+				err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			case e, ok := <-sub.Events():
+				if !ok {
+					if errors.Is(sub.Err(), stream.ErrLagged) {
+						_ = send(streamControl{Type: "lagged", Seq: broker.Seq()})
+					}
+					_ = conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "resubscribe with since"),
+						time.Now().Add(wsWriteTimeout))
+					return
+				}
+				err = send(newStreamEvent(e))
+			}
+			if err != nil {
+				log.Printf("Error: [StreamWSHandler] %v", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/config"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+	"github.com/rebus2015/praktikum-devops/internal/stream"
+)
+
+type sseMessage struct {
+	id    string
+	event string
+	data  string
+}
+
+func readSSE(t *testing.T, r *bufio.Reader) sseMessage {
+	t.Helper()
+	msg := sseMessage{}
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if msg.event != "" {
+				return msg
+			}
+		case strings.HasPrefix(line, "id: "):
+			msg.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			msg.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			msg.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestStreamSSEHandler(t *testing.T) {
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	_, err := metricStorage.AddCounter("PollCount", "1")
+	require.NoError(t, err)
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, config.Config{}))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/stream?type=counter", nil)
+	require.NoError(t, err)
+	// продолжаем с первого события: его повтор не ожидается
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, eventStreamContentType, resp.Header.Get(keyCT))
+
+	_, err = metricStorage.AddGauge("Alloc", "2.5") // отфильтровывается по типу
+	require.NoError(t, err)
+	_, err = metricStorage.AddCounter("PollCount", "4")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(resp.Body)
+	msg := readSSE(t, reader)
+	assert.Equal(t, "metric", msg.event)
+	assert.Equal(t, "3", msg.id)
+	var e stream.Event
+	require.NoError(t, json.Unmarshal([]byte(msg.data), &e))
+	assert.Equal(t, uint64(3), e.Seq)
+	assert.Equal(t, "PollCount", e.Metric.ID)
+	require.NotNil(t, e.Metric.Delta)
+	assert.Equal(t, int64(5), *e.Metric.Delta)
+
+	resp2, err := http.Get(ts.URL + "/api/v1/stream?since=abc")
+	require.NoError(t, err)
+	resp2.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp2.StatusCode)
+}
+
+func TestStreamWSHandler(t *testing.T) {
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, config.Config{}))
+	defer ts.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/v1/stream/ws"
+
+	for i := 0; i < 3; i++ {
+		_, err := metricStorage.AddGauge("cpu_user", "1")
+		require.NoError(t, err)
+	}
+
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL+"?name=cpu_*&since=1", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	defer conn.Close()
+	_, err = metricStorage.AddGauge("Alloc", "1")
+	require.NoError(t, err)
+	_, err = metricStorage.AddGauge("cpu_system", "7")
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	seqs := []uint64{}
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		var e stream.Event
+		require.NoError(t, conn.ReadJSON(&e))
+		seqs = append(seqs, e.Seq)
+		ids = append(ids, e.Metric.ID)
+	}
+	assert.Equal(t, []uint64{2, 3, 5}, seqs)
+	assert.Equal(t, []string{"cpu_user", "cpu_user", "cpu_system"}, ids)
+
+	// номер из будущего: сервер сообщает о потере истории
+	gapConn, gapResp, err := websocket.DefaultDialer.Dial(wsURL+"?since=1000", nil)
+	require.NoError(t, err)
+	defer gapResp.Body.Close()
+	defer gapConn.Close()
+	require.NoError(t, gapConn.SetReadDeadline(time.Now().Add(time.Second)))
+	var ctrl streamControl
+	require.NoError(t, gapConn.ReadJSON(&ctrl))
+	assert.Equal(t, streamControl{Type: "reset", Seq: 5}, ctrl)
+}
+
+func TestStream_nonFinite(t *testing.T) {
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, config.Config{}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/v1/stream/ws"
+	conn, wsResp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer wsResp.Body.Close()
+	defer conn.Close()
+
+	for _, val := range []string{"NaN", "+Inf", "-Inf", "1"} {
+		_, err = metricStorage.AddGauge("x", val)
+		require.NoError(t, err)
+	}
+
+	// нечисловые значения передаются строками, подписчики не отключаются
+	type event struct {
+		Metric struct {
+			Value any `json:"value"`
+		} `json:"metric"`
+		Seq uint64 `json:"seq"`
+	}
+	want := []any{"NaN", "+Inf", "-Inf", 1.0}
+	reader := bufio.NewReader(resp.Body)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	for i, v := range want {
+		var e event
+		require.NoError(t, json.Unmarshal([]byte(readSSE(t, reader).data), &e))
+		assert.Equal(t, v, e.Metric.Value)
+		assert.Equal(t, uint64(i+1), e.Seq)
+
+		e = event{}
+		require.NoError(t, conn.ReadJSON(&e))
+		assert.Equal(t, v, e.Metric.Value)
+		assert.Equal(t, uint64(i+1), e.Seq)
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/subnet"
+)
+
+// realIPMiddleware заменяет RemoteAddr реальным адресом клиента. В отличие от middleware.RealIP
+// заголовки X-Forwarded-For и X-Real-IP учитываются только от доверенных прокси proxies.
+func realIPMiddleware(proxies subnet.Set) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := subnet.ClientIP(r, proxies); ip != nil {
+				r.RemoteAddr = ip.String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trustedSubnetMiddleware отклоняет запросы, если адрес клиента или переданный агентом
+// заголовок X-Real-IP не входит в сети nets. Пустой список сетей снимает ограничение.
+// Адрес клиента определяется realIPMiddleware.
+func trustedSubnetMiddleware(nets subnet.Set) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(nets) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addrs := []string{r.RemoteAddr}
+			if real := r.Header.Get(subnet.RealIPHeader); real != "" {
+				addrs = append(addrs, real)
+			}
+			for _, addr := range addrs {
+				if !nets.Contains(subnet.HostIP(addr)) {
+					log.Printf("Error: [trustedSubnetMiddleware] address '%s' is not in trusted subnet %v", addr, nets)
+					writeProblem(w, r, http.StatusForbidden, codeUntrustedSubnet,
+						fmt.Sprintf("Address '%s' is not in trusted subnet", addr))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/config"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+	"github.com/rebus2015/praktikum-devops/internal/subnet"
+)
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	nets, err := subnet.Parse("192.168.1.0/24")
+	require.NoError(t, err)
+	proxies, err := subnet.Parse("10.0.0.1")
+	require.NoError(t, err)
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	r := NewRouter(metricStorage, &sqlStorageMock{isOpened: true},
+		config.Config{TrustedNets: nets, TrustedProxyNets: proxies, SignMode: "permissive"})
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		remote    string
+		realIP    string
+		forwarded string
+		status    int
+	}{
+		{"trusted agent", http.MethodPost, "/update/gauge/load/1", "192.168.1.5:4000", "192.168.1.5", "", http.StatusOK},
+		{"no real ip header", http.MethodPost, "/update/gauge/load/1", "192.168.1.5:4000", "", "", http.StatusOK},
+		{"untrusted connection", http.MethodPost, "/update/gauge/load/1", "172.16.0.1:4000", "192.168.1.5", "", http.StatusForbidden},
+		{"untrusted real ip", http.MethodPost, "/update/gauge/load/1", "192.168.1.5:4000", "172.16.0.1", "", http.StatusForbidden},
+		{"forwarded by untrusted proxy", http.MethodPost, "/update/gauge/load/1", "172.16.0.1:4000", "", "192.168.1.5",
+			http.StatusForbidden},
+		{"forwarded by trusted proxy", http.MethodPost, "/update/gauge/load/1", "10.0.0.1:4000", "", "192.168.1.5", http.StatusOK},
+		{"v2 write", http.MethodPost, "/api/v2/update", "172.16.0.1:4000", "", "", http.StatusForbidden},
+		{"read is not restricted", http.MethodGet, "/value/gauge/load", "172.16.0.1:4000", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.RemoteAddr = tt.remote
+			if tt.realIP != "" {
+				req.Header.Set(subnet.RealIPHeader, tt.realIP)
+			}
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			if tt.status == http.StatusForbidden {
+				assertProblem(t, w.Body.Bytes(), tt.status, codeUntrustedSubnet)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/auth"
+	"github.com/rebus2015/praktikum-devops/internal/codec"
+	"github.com/rebus2015/praktikum-devops/internal/config"
+	"github.com/rebus2015/praktikum-devops/internal/model"
+	"github.com/rebus2015/praktikum-devops/internal/signer"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+)
+
+type codecsContextKey struct{}
+
+// v2Codecs форматы тела запроса и ответа.
+type v2Codecs struct {
+	request  codec.Codec
+	response codec.Codec
+}
+
+// routeV2 описывает эндпоинты /api/v2. Они повторяют JSON-эндпоинты /update, /updates, /value и /values,
+// но формат тела выбирается заголовками Content-Type и Accept. Ошибки возвращаются в формате problem+json.
+func routeV2(r chi.Router, metricStorage storage.Repository, cfg config.Config, authn *authenticator) {
+	trusted := trustedSubnetMiddleware(cfg.TrustedNets)
+	write := authn.require(auth.ScopeWrite)
+	read := authn.require(auth.ScopeRead)
+	r.Use(gzipMiddleware)
+	r.Use(rsaMiddleware(cfg.CryptoKeys))
+	policy := signPolicy(cfg)
+	signed := requireSignedRequest(policy, true)
+	r.With(trusted, write, signed, MiddlewareGeneratorV2(policy, false)).Post("/update", UpdateV2HandlerFunc(metricStorage, cfg.Key))
+	r.With(trusted, write, signed, MiddlewareGeneratorV2(policy, true)).Post("/updates", UpdatesV2HandlerFunc(metricStorage, cfg.Key))
+	r.With(read, MiddlewareGeneratorV2(policy, false)).Post("/value", ValueV2HandlerFunc(metricStorage, cfg.Key))
+	r.With(read, MiddlewareGeneratorV2(policy, true)).Post("/values", ValuesV2HandlerFunc(metricStorage, cfg.Key))
+}
+
+// MiddlewareGeneratorV2 промежуточная функция /api/v2: выбирает форматы запроса и ответа,
+// разбирает тело и проверяет подписи метрик. list задает разбор списка метрик вместо одиночной.
+func MiddlewareGeneratorV2(policy signer.Policy, list bool) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			content, ok := r.Context().Value(bodyContextKey{}).([]byte)
+			if !ok {
+				log.Printf("Error: [MiddlewareGeneratorV2] Request body not found in context status-'500'")
+				writeProblem(w, r, http.StatusInternalServerError, codeInternal, missingContextMessage)
+				return
+			}
+			codecs := v2Codecs{}
+			if codecs.request, ok = codec.ForContentType(r.Header.Get(keyCT)); !ok {
+				writeProblem(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMedia,
+					fmt.Sprintf("Content-Type '%s' is not supported", r.Header.Get(keyCT)))
+				return
+			}
+			if codecs.response, ok = codec.Negotiate(r.Header.Get("Accept"), codecs.request); !ok {
+				writeProblem(w, r, http.StatusNotAcceptable, codeNotAcceptable,
+					fmt.Sprintf("None of the accepted types '%s' is supported", r.Header.Get("Accept")))
+				return
+			}
+			ctx := context.WithValue(r.Context(), codecsContextKey{}, codecs)
+
+			if !list {
+				var m codec.Metric
+				if err := codecs.request.Unmarshal(content, &m); err != nil {
+					log.Printf("Error: [MiddlewareGeneratorV2] decode metric error: %v", err)
+					writeProblem(w, r, http.StatusBadRequest, codeBadBody, "Request body is not a valid metric")
+					return
+				}
+				if err := checkRequestMetric(r, &m.Metrics, policy); err != nil {
+					writeProblem(w, r, problemStatus(err), problemCode(err), err.Error())
+					return
+				}
+				ctx = context.WithValue(ctx, singleMetricContextKey{}, &m.Metrics)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			decoded, err := codecs.request.UnmarshalList(content)
+			if err != nil {
+				log.Printf("Error: [MiddlewareGeneratorV2] decode metrics error: %v", err)
+				writeProblem(w, r, http.StatusBadRequest, codeBadBody, "Request body is not a valid list of metrics")
+				return
+			}
+			metrics := make([]*model.Metrics, len(decoded))
+			var items []problemItem
+			for i := range decoded {
+				metrics[i] = &decoded[i].Metrics
+				if err = checkRequestMetric(r, metrics[i], policy); err != nil {
+					items = append(items, newProblemItem(i, metrics[i].ID, err))
+				}
+			}
+			if len(items) > 0 {
+				writeProblem(w, r, http.StatusBadRequest, codeInvalidBatch, "Some metrics in the request are invalid", items...)
+				return
+			}
+			ctx = context.WithValue(ctx, multipleMetricsContextKey{}, metrics)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// UpdateV2HandlerFunc обновляет одиночную метрику и возвращает ее новое значение.
+func UpdateV2HandlerFunc(metricStorage storage.Repository, key string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metric, codecs, ok := singleV2Request(w, r)
+		if !ok {
+			return
+		}
+		if err := checkValue(metric); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, problemCode(err), err.Error())
+			return
+		}
+		retval := codec.Metric{Metrics: model.Metrics{ID: metric.ID, MType: metric.MType, HashVersion: metric.HashVersion}}
+		switch metric.MType {
+		case counter:
+			delta, err := metricStorage.AddCounter(metric.ID, metric.Delta)
+			if err != nil {
+				log.Printf("Error: [UpdateV2HandlerFunc] Update counter error: %v", err)
+				writeProblem(w, r, http.StatusInternalServerError, codeStorage, "Failed to update counter")
+				return
+			}
+			retval.Delta = &delta
+		case gauge:
+			value, err := metricStorage.AddGauge(metric.ID, metric.Value)
+			if err != nil {
+				log.Printf("Error: [UpdateV2HandlerFunc] Update gauge error: %v", err)
+				writeProblem(w, r, http.StatusInternalServerError, codeStorage, "Failed to update gauge")
+				return
+			}
+			retval.Value = &value
+		}
+		attributeMetrics(r, 1)
+		writeV2Metric(w, r, codecs.response, key, &retval)
+	}
+}
+
+// UpdatesV2HandlerFunc применяет список метрик. Список применяется только целиком.
+func UpdatesV2HandlerFunc(metricStorage storage.Repository, key string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics, codecs, ok := multipleV2Request(w, r)
+		if !ok {
+			return
+		}
+		var items []problemItem
+		for i, m := range metrics {
+			if err := checkValue(m); err != nil {
+				items = append(items, newProblemItem(i, m.ID, err))
+			}
+		}
+		if len(items) > 0 {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidBatch, "Some metrics in the batch are invalid", items...)
+			return
+		}
+		if err := metricStorage.AddMetrics(metrics); err != nil {
+			log.Printf("Error: [UpdatesV2HandlerFunc] Add multiple metrics error: %v", err)
+			writeProblem(w, r, http.StatusInternalServerError, codeStorage, "Failed to store metrics")
+			return
+		}
+		attributeMetrics(r, len(metrics))
+		retval := make([]codec.Metric, len(metrics))
+		for i, m := range metrics {
+			retval[i].Metrics = model.Metrics{ID: m.ID, MType: m.MType, Delta: m.Delta, Value: m.Value, HashVersion: m.HashVersion}
+		}
+		writeV2List(w, r, codecs.response, key, retval)
+	}
+}
+
+// ValueV2HandlerFunc возвращает значение одиночной метрики.
+func ValueV2HandlerFunc(metricStorage storage.Repository, key string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metric, codecs, ok := singleV2Request(w, r)
+		if !ok {
+			return
+		}
+		retval := codec.Metric{Metrics: model.Metrics{ID: metric.ID, MType: metric.MType, HashVersion: metric.HashVersion}}
+		if err := lookupValue(metricStorage, &retval); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, problemCode(err), err.Error())
+			return
+		}
+		if retval.NotFound {
+			writeProblem(w, r, http.StatusNotFound, codeNotFound, fmt.Sprintf("Metric '%s' not found", metric.ID))
+			return
+		}
+		writeV2Metric(w, r, codecs.response, key, &retval)
+	}
+}
+
+// ValuesV2HandlerFunc возвращает значения списка метрик. Отсутствующие метрики отмечаются признаком not_found.
+func ValuesV2HandlerFunc(metricStorage storage.Repository, key string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics, codecs, ok := multipleV2Request(w, r)
+		if !ok {
+			return
+		}
+		retval := make([]codec.Metric, len(metrics))
+		var items []problemItem
+		for i, m := range metrics {
+			retval[i].Metrics = model.Metrics{ID: m.ID, MType: m.MType, HashVersion: m.HashVersion}
+			if err := lookupValue(metricStorage, &retval[i]); err != nil {
+				items = append(items, newProblemItem(i, m.ID, err))
+			}
+		}
+		if len(items) > 0 {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidBatch, "Some metrics in the request are invalid", items...)
+			return
+		}
+		writeV2List(w, r, codecs.response, key, retval)
+	}
+}
+
+// lookupValue заполняет значение метрики из хранилища либо признак NotFound.
+func lookupValue(metricStorage storage.Repository, m *codec.Metric) error {
+	switch m.MType {
+	case counter:
+		delta, err := metricStorage.GetCounter(m.ID)
+		if err != nil {
+			m.NotFound = true
+			return nil
+		}
+		m.Delta = &delta
+	case gauge:
+		value, err := metricStorage.GetGauge(m.ID)
+		if err != nil {
+			m.NotFound = true
+			return nil
+		}
+		m.Value = &value
+	default:
+		return fmt.Errorf("%w '%s'", errUnknownType, m.MType)
+	}
+	return nil
+}
+
+func singleV2Request(w http.ResponseWriter, r *http.Request) (*model.Metrics, v2Codecs, bool) {
+	metric, ok := r.Context().Value(singleMetricContextKey{}).(*model.Metrics)
+	codecs, okCodecs := r.Context().Value(codecsContextKey{}).(v2Codecs)
+	if !ok || !okCodecs {
+		log.Printf("Error: [singleV2Request] Metric info not found in context status-'500'")
+		writeProblem(w, r, http.StatusInternalServerError, codeInternal, missingContextMessage)
+		return nil, codecs, false
+	}
+	return metric, codecs, true
+}
+
+func multipleV2Request(w http.ResponseWriter, r *http.Request) ([]*model.Metrics, v2Codecs, bool) {
+	metrics, ok := r.Context().Value(multipleMetricsContextKey{}).([]*model.Metrics)
+	codecs, okCodecs := r.Context().Value(codecsContextKey{}).(v2Codecs)
+	if !ok || !okCodecs {
+		log.Printf("Error: [multipleV2Request] Metric info not found in context status-'500'")
+		writeProblem(w, r, http.StatusInternalServerError, codeInternal, missingContextMessage)
+		return nil, codecs, false
+	}
+	return metrics, codecs, true
+}
+
+func writeV2Metric(w http.ResponseWriter, r *http.Request, c codec.Codec, key string, m *codec.Metric) {
+	if key != "" {
+		hashObject := signer.NewHashObject(key)
+		if err := hashObject.Sign(&m.Metrics); err != nil {
+			log.Printf(resJSONSignErrorMessage, err)
+			writeProblem(w, r, http.StatusInternalServerError, codeInternal, httpJSONSignErrorMessage)
+			return
+		}
+	}
+	data, err := c.Marshal(m)
+	writeV2(w, r, c, data, err)
+}
+
+func writeV2List(w http.ResponseWriter, r *http.Request, c codec.Codec, key string, metrics []codec.Metric) {
+	if key != "" {
+		hashObject := signer.NewHashObject(key)
+		for i := range metrics {
+			if metrics[i].NotFound {
+				continue
+			}
+			if err := hashObject.Sign(&metrics[i].Metrics); err != nil {
+				log.Printf(resJSONSignErrorMessage, err)
+				writeProblem(w, r, http.StatusInternalServerError, codeInternal, httpJSONSignErrorMessage)
+				return
+			}
+		}
+	}
+	data, err := c.MarshalList(metrics)
+	writeV2(w, r, c, data, err)
+}
+
+func writeV2(w http.ResponseWriter, r *http.Request, c codec.Codec, data []byte, err error) {
+	if err != nil {
+		log.Printf("Error: [writeV2] %s encode error: %v", c.ContentType(), err)
+		writeProblem(w, r, http.StatusInternalServerError, codeInternal, "Failed to encode response")
+		return
+	}
+	w.Header().Set(keyCT, c.ContentType())
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		log.Printf("Error: [writeV2] write response error: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/codec"
+	"github.com/rebus2015/praktikum-devops/internal/config"
+	"github.com/rebus2015/praktikum-devops/internal/model"
+	"github.com/rebus2015/praktikum-devops/internal/signer"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+func requestV2(t *testing.T, ts *httptest.Server, path, contentType, accept string, body []byte) (int, string, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set(keyCT, contentType)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, resp.Header.Get(keyCT), data
+}
+
+func TestAPIv2_codecs(t *testing.T) {
+	const key = "secret"
+	for _, c := range []codec.Codec{codec.JSON{}, codec.Msgpack{}, codec.Protobuf{}} {
+		t.Run(c.ContentType(), func(t *testing.T) {
+			var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+			// тест проверяет форматы, а не подписи запросов: метрики отправляются без подписи
+			cfg := config.Config{Key: key, SignMode: string(signer.ModePermissive)}
+			ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, cfg))
+			defer ts.Close()
+
+			body, err := c.MarshalList([]codec.Metric{
+				{Metrics: model.Metrics{ID: "PollCount", MType: counter, Delta: ptr(int64(5))}},
+				{Metrics: model.Metrics{ID: "Alloc", MType: gauge, Value: ptr(1.5)}},
+			})
+			require.NoError(t, err)
+			status, ct, _ := requestV2(t, ts, "/api/v2/updates", c.ContentType(), "", body)
+			require.Equal(t, http.StatusOK, status)
+			assert.Equal(t, c.ContentType(), ct)
+
+			body, err = c.Marshal(&codec.Metric{Metrics: model.Metrics{ID: "PollCount", MType: counter, Delta: ptr(int64(2))}})
+			require.NoError(t, err)
+			status, _, data := requestV2(t, ts, "/api/v2/update", c.ContentType(), "", body)
+			require.Equal(t, http.StatusOK, status)
+			var updated codec.Metric
+			require.NoError(t, c.Unmarshal(data, &updated))
+			require.NotNil(t, updated.Delta)
+			assert.Equal(t, int64(7), *updated.Delta)
+
+			body, err = c.MarshalList([]codec.Metric{
+				{Metrics: model.Metrics{ID: "PollCount", MType: counter}},
+				{Metrics: model.Metrics{ID: "Alloc", MType: gauge}},
+				{Metrics: model.Metrics{ID: "none", MType: gauge}},
+			})
+			require.NoError(t, err)
+			status, _, data = requestV2(t, ts, "/api/v2/values", c.ContentType(), "", body)
+			require.Equal(t, http.StatusOK, status)
+			values, err := c.UnmarshalList(data)
+			require.NoError(t, err)
+			require.Len(t, values, 3)
+			hashObject := signer.NewHashObject(key)
+			for _, v := range values[:2] {
+				passed, err := hashObject.Verify(&v.Metrics)
+				require.NoError(t, err)
+				assert.True(t, passed, v.ID)
+			}
+			assert.Equal(t, int64(7), *values[0].Delta)
+			assert.Equal(t, 1.5, *values[1].Value)
+			assert.True(t, values[2].NotFound)
+		})
+	}
+}
+
+func TestAPIv2_negotiation(t *testing.T) {
+	var metricStorage storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	_, err := metricStorage.AddGauge("Alloc", "2.5")
+	require.NoError(t, err)
+	ts := httptest.NewServer(NewRouter(metricStorage, &sqlStorageMock{}, config.Config{}))
+	defer ts.Close()
+
+	body := []byte(`{"id":"Alloc","type":"gauge"}`)
+	status, ct, data := requestV2(t, ts, "/api/v2/value", codec.JSONContentType, codec.ProtobufContentType, body)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, codec.ProtobufContentType, ct)
+	var m codec.Metric
+	require.NoError(t, codec.Protobuf{}.Unmarshal(data, &m))
+	assert.Equal(t, 2.5, *m.Value)
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		accept      string
+		body        []byte
+		status      int
+		code        string
+	}{
+		{"unsupported content type", "/api/v2/value", "text/plain", "", body, http.StatusUnsupportedMediaType, codeUnsupportedMedia},
+		{"not acceptable", "/api/v2/value", codec.JSONContentType, "text/csv", body, http.StatusNotAcceptable, codeNotAcceptable},
+		{"malformed protobuf", "/api/v2/update", codec.ProtobufContentType, "", []byte{0x0a, 0x05}, http.StatusBadRequest, codeBadBody},
+		{"not found", "/api/v2/value", codec.JSONContentType, "", []byte(`{"id":"none","type":"gauge"}`), http.StatusNotFound, codeNotFound},
+		{"invalid item", "/api/v2/values", codec.JSONContentType, "", []byte(`[{"id":"a","type":"histogram"}]`), http.StatusBadRequest, codeInvalidBatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, ct, data := requestV2(t, ts, tt.path, tt.contentType, tt.accept, tt.body)
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, problemContentType, ct)
+			assertProblem(t, data, tt.status, tt.code)
+		})
+	}
+}
@@ -0,0 +1,260 @@
+// Package influx разбирает текстовый протокол InfluxDB (line protocol) и преобразует его в метрики сервиса.
+//
+// Каждая строка имеет вид
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// Числовые и логические поля становятся метриками типа gauge: целые числа (суффикс i или u)
+// в line protocol обычно передают абсолютные или накопленные показания (mem,used=123i).
+// С Options.IntegersAsCounters целые поля становятся приращениями метрик типа counter.
+// Строковые поля пропускаются.
+// Имя метрики формируется как measurement_field, для поля с именем value — просто measurement.
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+)
+
+const (
+	counter    string = "counter"
+	gauge      string = "gauge"
+	valueField string = "value"
+	maxLineLen int    = 64 * 1024
+)
+
+var ErrSyntax = errors.New("line protocol syntax error")
+
+// Options параметры преобразования точек в метрики.
+type Options struct {
+	// TagsAsPrefix добавляет значения тегов префиксом к имени (server01.cpu_usage)
+	// вместо меток в формате cpu_usage{host="server01"}.
+	TagsAsPrefix bool
+	// IntegersAsCounters трактует целые поля как приращения счетчиков вместо значений gauge.
+	IntegersAsCounters bool
+}
+
+// Parse разбирает все строки из r. При первой ошибке разбор прекращается,
+// ошибка содержит номер строки.
+func Parse(r io.Reader, opts Options) ([]*model.Metrics, error) {
+	metrics := []*model.Metrics{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineLen)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		parsed, err := parseLine(string(line), opts)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		metrics = append(metrics, parsed...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: read error: %w", lineNum+1, err)
+	}
+	return metrics, nil
+}
+
+func parseLine(line string, opts Options) ([]*model.Metrics, error) {
+	seriesPart, rest, ok := cutUnescaped(line, ' ')
+	if !ok {
+		return nil, fmt.Errorf("%w: missing fields", ErrSyntax)
+	}
+	fieldsPart, tsPart, _ := cutUnescapedOutsideQuotes(rest, ' ')
+	if tsPart = strings.TrimSpace(tsPart); tsPart != "" {
+		if _, err := strconv.ParseInt(tsPart, 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: bad timestamp '%s'", ErrSyntax, tsPart)
+		}
+	}
+
+	seriesItems := splitUnescaped(seriesPart, ',')
+	measurement := unescape(seriesItems[0])
+	if measurement == "" {
+		return nil, fmt.Errorf("%w: empty measurement", ErrSyntax)
+	}
+	labels := make([]model.Label, 0, len(seriesItems)-1)
+	for _, item := range seriesItems[1:] {
+		k, v, ok := cutUnescaped(item, '=')
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("%w: bad tag '%s'", ErrSyntax, item)
+		}
+		labels = append(labels, model.Label{Name: unescape(k), Value: unescape(v)})
+	}
+
+	fields, err := splitFields(fieldsPart)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]*model.Metrics, 0, len(fields))
+	for _, f := range fields {
+		k, v, ok := cutUnescaped(f, '=')
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("%w: bad field '%s'", ErrSyntax, f)
+		}
+		m, numeric, err := fieldMetric(v, opts)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s': %w", unescape(k), err)
+		}
+		if !numeric {
+			continue
+		}
+		m.ID = metricID(measurement, unescape(k), labels, opts)
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// fieldMetric определяет тип метрики по значению поля. Для строковых полей numeric == false.
+func fieldMetric(v string, opts Options) (m *model.Metrics, numeric bool, err error) {
+	switch {
+	case v[0] == '"':
+		if len(v) < 2 || v[len(v)-1] != '"' {
+			return nil, false, fmt.Errorf("%w: unterminated string", ErrSyntax)
+		}
+		return nil, false, nil
+	case v[len(v)-1] == 'i':
+		d, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: bad integer '%s'", ErrSyntax, v)
+		}
+		return integerMetric(d, opts), true, nil
+	case v[len(v)-1] == 'u':
+		u, err := strconv.ParseUint(v[:len(v)-1], 10, 63)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: bad unsigned integer '%s'", ErrSyntax, v)
+		}
+		return integerMetric(int64(u), opts), true, nil
+	}
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		one := 1.0
+		return &model.Metrics{MType: gauge, Value: &one}, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		zero := 0.0
+		return &model.Metrics{MType: gauge, Value: &zero}, true, nil
+	}
+	f, errf := strconv.ParseFloat(v, 64)
+	if errf != nil {
+		return nil, false, fmt.Errorf("%w: bad float '%s'", ErrSyntax, v)
+	}
+	return &model.Metrics{MType: gauge, Value: &f}, true, nil
+}
+
+func integerMetric(d int64, opts Options) *model.Metrics {
+	if opts.IntegersAsCounters {
+		return &model.Metrics{MType: counter, Delta: &d}
+	}
+	f := float64(d)
+	return &model.Metrics{MType: gauge, Value: &f}
+}
+
+func metricID(measurement, field string, labels []model.Label, opts Options) string {
+	name := measurement
+	if field != valueField {
+		name = measurement + "_" + field
+	}
+	if !opts.TagsAsPrefix {
+		return model.FormatID(name, labels)
+	}
+	sorted := make([]model.Label, len(labels))
+	copy(sorted, labels)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	parts := make([]string, 0, len(sorted)+1)
+	for _, l := range sorted {
+		parts = append(parts, l.Value)
+	}
+	return strings.Join(append(parts, name), ".")
+}
+
+// splitFields делит набор полей по запятым, не учитывая запятые внутри строковых значений.
+func splitFields(s string) ([]string, error) {
+	fields := []string{}
+	start := 0
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				fields = append(fields, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("%w: unterminated string", ErrSyntax)
+	}
+	return append(fields, s[start:]), nil
+}
+
+// cutUnescaped делит строку по первому неэкранированному разделителю sep.
+func cutUnescaped(s string, sep byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// cutUnescapedOutsideQuotes делит строку по первому разделителю sep вне строковых значений.
+func cutUnescapedOutsideQuotes(s string, sep byte) (string, string, bool) {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case sep:
+			if !inQuotes {
+				return s[:i], s[i+1:], true
+			}
+		}
+	}
+	return s, "", false
+}
+
+func splitUnescaped(s string, sep byte) []string {
+	parts := []string{}
+	for {
+		head, tail, ok := cutUnescaped(s, sep)
+		parts = append(parts, head)
+		if !ok {
+			return parts
+		}
+		s = tail
+	}
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package influx
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+)
+
+func gaugeM(id string, v float64) *model.Metrics {
+	return &model.Metrics{ID: id, MType: gauge, Value: &v}
+}
+
+func counterM(id string, d int64) *model.Metrics {
+	return &model.Metrics{ID: id, MType: counter, Delta: &d}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		opts    Options
+		want    []*model.Metrics
+		wantErr string
+	}{
+		{
+			name:  "tags as labels",
+			input: "cpu,region=us-west,host=server01 usage=12.5,count=3i 1465839830100400200\n",
+			want: []*model.Metrics{
+				gaugeM(`cpu_usage{host="server01",region="us-west"}`, 12.5),
+				gaugeM(`cpu_count{host="server01",region="us-west"}`, 3),
+			},
+		},
+		{
+			name:  "integers as counters",
+			input: "cpu,host=server01 usage=12.5,count=3i,total=4u",
+			opts:  Options{IntegersAsCounters: true},
+			want: []*model.Metrics{
+				gaugeM(`cpu_usage{host="server01"}`, 12.5),
+				counterM(`cpu_count{host="server01"}`, 3),
+				counterM(`cpu_total{host="server01"}`, 4),
+			},
+		},
+		{
+			name:  "tags as prefix",
+			input: "cpu,region=us-west,host=server01 usage=12.5",
+			opts:  Options{TagsAsPrefix: true},
+			want:  []*model.Metrics{gaugeM("server01.us-west.cpu_usage", 12.5)},
+		},
+		{
+			name:  "value field, bool, unsigned, string skipped",
+			input: "# comment\n\ntemp value=-1e3\nup ok=true,err=F,msg=\"a, b c\",n=7u",
+			want: []*model.Metrics{
+				gaugeM("temp", -1000),
+				gaugeM("up_ok", 1),
+				gaugeM("up_err", 0),
+				gaugeM("up_n", 7),
+			},
+		},
+		{
+			name:  "escaped characters",
+			input: `my\ measure,path=a\,b\=c value=1`,
+			want:  []*model.Metrics{gaugeM(`my measure{path="a,b=c"}`, 1)},
+		},
+		{name: "missing fields", input: "cpu", wantErr: "line 1: line protocol syntax error: missing fields"},
+		{name: "bad integer", input: "a value=1\nb value=xi", wantErr: "line 2: field 'value'"},
+		{name: "bad tag", input: "cpu,host value=1", wantErr: "bad tag 'host'"},
+		{name: "bad timestamp", input: "cpu value=1 now", wantErr: "bad timestamp 'now'"},
+		{name: "unterminated string", input: `cpu msg="abc`, wantErr: "unterminated string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input), tt.opts)
+			if tt.wantErr != "" {
+				assert.ErrorIs(t, err, ErrSyntax)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
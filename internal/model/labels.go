@@ -0,0 +1,104 @@
+package model
+
+import (
+	"sort"
+	"strings"
+)
+
+// Label метка метрики. Метки передаются в имени метрики в формате Prometheus: name{key="value",...}.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// FormatID формирует имя метрики с метками, отсортированными по имени.
+func FormatID(name string, labels []Label) string {
+	if len(labels) == 0 {
+		return name
+	}
+	sorted := make([]Label, len(labels))
+	copy(sorted, labels)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, l := range sorted {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(EscapeLabelValue(l.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// ParseID выделяет из имени метрики базовое имя и метки.
+// Если имя не содержит корректного блока меток, оно возвращается целиком без меток.
+func ParseID(id string) (string, []Label) {
+	open := strings.IndexByte(id, '{')
+	if open <= 0 || !strings.HasSuffix(id, "}") {
+		return id, nil
+	}
+	labels, ok := parseLabels(id[open+1 : len(id)-1])
+	if !ok {
+		return id, nil
+	}
+	return id[:open], labels
+}
+
+func parseLabels(s string) ([]Label, bool) {
+	labels := []Label{}
+	for s != "" {
+		eq := strings.Index(s, `="`)
+		if eq <= 0 {
+			return nil, false
+		}
+		l := Label{Name: s[:eq]}
+		s = s[eq+2:]
+		var val strings.Builder
+		closed := false
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+			if c == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					val.WriteByte('\n')
+				default:
+					val.WriteByte(s[i])
+				}
+				continue
+			}
+			if c == '"' {
+				s = s[i+1:]
+				closed = true
+				break
+			}
+			val.WriteByte(c)
+		}
+		if !closed {
+			return nil, false
+		}
+		l.Value = val.String()
+		labels = append(labels, l)
+		if s == "" {
+			break
+		}
+		if s[0] != ',' {
+			return nil, false
+		}
+		s = s[1:]
+	}
+	return labels, true
+}
+
+// EscapeLabelValue экранирует обратную косую черту, кавычки и перевод строки в значении метки.
+func EscapeLabelValue(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
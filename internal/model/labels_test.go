@@ -0,0 +1,52 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		id         string
+		wantName   string
+		wantLabels []Label
+	}{
+		{id: "Alloc", wantName: "Alloc"},
+		{
+			id:         `cpu{host="server01",region="us-west"}`,
+			wantName:   "cpu",
+			wantLabels: []Label{{"host", "server01"}, {"region", "us-west"}},
+		},
+		{
+			id:         `m{path="a\"b\\c\nd"}`,
+			wantName:   "m",
+			wantLabels: []Label{{"path", "a\"b\\c\nd"}},
+		},
+		{id: `broken{host=server01}`, wantName: `broken{host=server01}`},
+		{id: `broken{host="x"`, wantName: `broken{host="x"`},
+		{id: `{host="x"}`, wantName: `{host="x"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			name, labels := ParseID(tt.id)
+			assert.Equal(t, tt.wantName, name)
+			if len(tt.wantLabels) == 0 {
+				assert.Empty(t, labels)
+				return
+			}
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+}
+
+func TestFormatID(t *testing.T) {
+	assert.Equal(t, "Alloc", FormatID("Alloc", nil))
+
+	id := FormatID("cpu", []Label{{"region", "us-west"}, {"host", `a"b`}})
+	assert.Equal(t, `cpu{host="a\"b",region="us-west"}`, id)
+
+	name, labels := ParseID(id)
+	assert.Equal(t, "cpu", name)
+	assert.Equal(t, []Label{{"host", `a"b`}, {"region", "us-west"}}, labels)
+}
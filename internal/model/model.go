@@ -9,4 +9,6 @@ type Metrics struct {
 	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
 	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
 	Hash  string   `json:"hash,omitempty"`  // значение хэш-функции
+	// HashVersion формат подписи Hash: 0 или 1 — исходный, 2 — канонический (см. signer.HashV2)
+	HashVersion int `json:"hash_version,omitempty"`
 }
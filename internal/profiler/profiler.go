@@ -0,0 +1,100 @@
+// Package profiler снимает профили CPU и кучи сервера по расписанию для последующего сравнения
+// командой go tool pprof -diff_base.
+package profiler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// timeLayout формат времени в именах файлов профилей, сортировка по имени совпадает с хронологической.
+const timeLayout = "20060102T150405"
+
+// Capture снимает профиль CPU длительностью cpu и профиль кучи и записывает их в каталог dir
+// в файлы cpu-<время>.pprof и heap-<время>.pprof. Отмена ctx сокращает профиль CPU.
+// Возвращает имена записанных файлов.
+func Capture(ctx context.Context, dir string, cpu time.Duration) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("profiles dir: %w", err)
+	}
+	stamp := time.Now().Format(timeLayout)
+	cpuFile := filepath.Join(dir, "cpu-"+stamp+".pprof")
+	if err := writeCPU(ctx, cpuFile, cpu); err != nil {
+		return nil, err
+	}
+	heapFile := filepath.Join(dir, "heap-"+stamp+".pprof")
+	if err := writeHeap(heapFile); err != nil {
+		return []string{cpuFile}, err
+	}
+	return []string{cpuFile, heapFile}, nil
+}
+
+func writeCPU(ctx context.Context, name string, d time.Duration) (err error) {
+	f, err := os.Create(filepath.Clean(name))
+	if err != nil {
+		return fmt.Errorf("cpu profile: %w", err)
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("cpu profile: %w", cerr)
+		}
+	}()
+	// профиль CPU может уже сниматься через /debug/pprof/profile
+	if err = pprof.StartCPUProfile(f); err != nil {
+		return fmt.Errorf("cpu profile: %w", err)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	pprof.StopCPUProfile()
+	return nil
+}
+
+func writeHeap(name string) (err error) {
+	f, err := os.Create(filepath.Clean(name))
+	if err != nil {
+		return fmt.Errorf("heap profile: %w", err)
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("heap profile: %w", cerr)
+		}
+	}()
+	// профиль кучи отражает состояние на момент последней сборки мусора
+	runtime.GC()
+	if err = pprof.WriteHeapProfile(f); err != nil {
+		return fmt.Errorf("heap profile: %w", err)
+	}
+	return nil
+}
+
+// Run снимает профили каждые interval до отмены ctx. Ошибки записываются в лог,
+// расписание при этом не прерывается.
+func Run(ctx context.Context, dir string, interval, cpu time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			files, err := Capture(ctx, dir, cpu)
+			if err != nil {
+				log.Printf("Error: [profiler] %v", err)
+				continue
+			}
+			log.Printf("Profiles captured: %v", files)
+		case <-ctx.Done():
+			log.Println("profiler stopped")
+			return
+		}
+	}
+}
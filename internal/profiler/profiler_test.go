@@ -0,0 +1,46 @@
+package profiler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapture(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "profiles")
+	files, err := Capture(context.Background(), dir, 50*time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Regexp(t, `cpu-\d{8}T\d{6}\.pprof$`, files[0])
+	assert.Regexp(t, `heap-\d{8}T\d{6}\.pprof$`, files[1])
+	for _, name := range files {
+		stat, serr := os.Stat(name)
+		require.NoError(t, serr)
+		assert.NotZero(t, stat.Size(), name)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(ctx, dir, 20*time.Millisecond, 10*time.Millisecond)
+	}()
+	assert.Eventually(t, func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "heap-*.pprof"))
+		return len(files) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop after context cancel")
+	}
+}
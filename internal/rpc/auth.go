@@ -0,0 +1,152 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/rebus2015/praktikum-devops/internal/auth"
+	"github.com/rebus2015/praktikum-devops/internal/codec"
+)
+
+// Ключи метаданных с токеном доступа, как заголовки Authorization и auth.APIKeyHeader в HTTP API.
+const (
+	authorizationMD string = "authorization"
+	apiKeyMD        string = "x-api-key"
+)
+
+// methodScopes области доступа, которые требуются для методов сервиса.
+var methodScopes = map[string]auth.Scope{
+	methodGet:    auth.ScopeRead,
+	methodList:   auth.ScopeRead,
+	methodUpdate: auth.ScopeWrite,
+}
+
+// authenticator проверяет токены доступа из метаданных вызова и записывает отклоненные вызовы в журнал аудита.
+type authenticator struct {
+	tokens *auth.Store
+	audit  *auth.AuditLog
+}
+
+// AuthUnaryInterceptor требует токен с областью доступа метода и доступом к запрошенной метрике.
+// Токен, ограниченный префиксами имен, не может получить список всех метрик.
+func AuthUnaryInterceptor(tokens *auth.Store, audit *auth.AuditLog) grpc.UnaryServerInterceptor {
+	a := &authenticator{tokens: tokens, audit: audit}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		token, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		switch m := req.(type) {
+		case *codec.Metric:
+			if err = a.authorizeMetric(ctx, info.FullMethod, token, m.ID); err != nil {
+				return nil, err
+			}
+		case *ListRequest:
+			if token.Restricted() {
+				a.record(ctx, info.FullMethod, auth.ReasonPrefixRestricted, token, "", "")
+				return nil, status.Errorf(codes.PermissionDenied,
+					"token '%s' is restricted to metric prefixes %v", token.Name, token.Prefixes)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// AuthStreamInterceptor требует токен с областью доступа метода и проверяет доступ
+// к каждой метрике пакетов потока.
+func AuthStreamInterceptor(tokens *auth.Store, audit *auth.AuditLog) grpc.StreamServerInterceptor {
+	a := &authenticator{tokens: tokens, audit: audit}
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		token, err := a.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authorizingStream{ServerStream: ss, authn: a, method: info.FullMethod, token: token})
+	}
+}
+
+type authorizingStream struct {
+	grpc.ServerStream
+	authn  *authenticator
+	method string
+	token  *auth.Token
+}
+
+func (s *authorizingStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if batch, ok := m.(*MetricList); ok {
+		for i := range batch.Metrics {
+			if err := s.authn.authorizeMetric(s.Context(), s.method, s.token, batch.Metrics[i].ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// authenticate проверяет токен из метаданных и его область доступа для метода.
+func (a *authenticator) authenticate(ctx context.Context, method string) (*auth.Token, error) {
+	scope, ok := methodScopes[method]
+	if !ok {
+		scope = auth.ScopeAdmin
+	}
+	token, err := a.tokens.Authenticate(tokenFromContext(ctx))
+	if err != nil {
+		reason := auth.ReasonInvalidToken
+		if errors.Is(err, auth.ErrNoToken) {
+			reason = auth.ReasonNoToken
+		}
+		a.record(ctx, method, reason, nil, scope, "")
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !token.Allows(scope) {
+		a.record(ctx, method, auth.ReasonScope, token, scope, "")
+		return nil, status.Errorf(codes.PermissionDenied, "token '%s' has no '%s' scope", token.Name, scope)
+	}
+	return token, nil
+}
+
+func (a *authenticator) authorizeMetric(ctx context.Context, method string, token *auth.Token, name string) error {
+	if token.AllowsMetric(name) {
+		return nil
+	}
+	a.record(ctx, method, auth.ReasonMetricForbidden, token, "", name)
+	return status.Errorf(codes.PermissionDenied, "token '%s' has no access to metric '%s'", token.Name, name)
+}
+
+func (a *authenticator) record(ctx context.Context, method, reason string, token *auth.Token, scope auth.Scope, metric string) {
+	e := auth.Event{
+		Reason: reason,
+		Scope:  scope,
+		Metric: metric,
+		Method: "gRPC",
+		Path:   method,
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		e.Remote = p.Addr.String()
+	}
+	if token != nil {
+		e.Token = token.Name
+	}
+	a.audit.Record(e)
+}
+
+// tokenFromContext извлекает токен из метаданных authorization (схема Bearer) или x-api-key.
+func tokenFromContext(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	return auth.FromValues(first(authorizationMD), first(apiKeyMD))
+}
@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+
+	"github.com/rebus2015/praktikum-devops/internal/codec"
+)
+
+// Client клиент сервиса метрик.
+type Client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewClient создает клиента поверх установленного соединения.
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{cc: cc}
+}
+
+// UpdateStream клиентская часть потока UpdateMetrics.
+type UpdateStream struct {
+	stream grpc.ClientStream
+}
+
+// Send отправляет пакет метрик.
+func (s *UpdateStream) Send(batch *MetricList) error {
+	return s.stream.SendMsg(batch)
+}
+
+// CloseAndRecv завершает отправку и возвращает итог потока.
+func (s *UpdateStream) CloseAndRecv() (*UpdateSummary, error) {
+	if err := s.stream.CloseSend(); err != nil {
+		return nil, fmt.Errorf("close send: %w", err)
+	}
+	summary := &UpdateSummary{}
+	if err := s.stream.RecvMsg(summary); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// UpdateMetrics открывает поток отправки пакетов метрик.
+func (c *Client) UpdateMetrics(ctx context.Context, opts ...grpc.CallOption) (*UpdateStream, error) {
+	desc := &serviceDesc.Streams[0]
+	stream, err := c.cc.NewStream(ctx, desc, methodUpdate, callOptions(opts)...)
+	if err != nil {
+		return nil, err
+	}
+	return &UpdateStream{stream: stream}, nil
+}
+
+// GetMetric запрашивает значение метрики.
+func (c *Client) GetMetric(ctx context.Context, req *codec.Metric, opts ...grpc.CallOption) (*codec.Metric, error) {
+	resp := &codec.Metric{}
+	if err := c.cc.Invoke(ctx, methodGet, req, resp, callOptions(opts)...); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ListMetrics запрашивает страницу метрик.
+func (c *Client) ListMetrics(ctx context.Context, req *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	resp := &ListResponse{}
+	if err := c.cc.Invoke(ctx, methodList, req, resp, callOptions(opts)...); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func callOptions(opts []grpc.CallOption) []grpc.CallOption {
+	return append([]grpc.CallOption{grpc.ForceCodec(wireCodec{})}, opts...)
+}
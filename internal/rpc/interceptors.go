@@ -0,0 +1,134 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/rebus2015/praktikum-devops/internal/codec"
+	"github.com/rebus2015/praktikum-devops/internal/signer"
+	"github.com/rebus2015/praktikum-devops/internal/subnet"
+)
+
+// updateMethods методы, изменяющие метрики. Ограничение доверенной сетью, как и в HTTP API,
+// распространяется только на них: чтение доступно из любой сети.
+var updateMethods = map[string]bool{
+	methodUpdate: true,
+}
+
+// TrustedSubnetUnaryInterceptor отклоняет вызовы методов обновления от клиентов вне сетей nets.
+func TrustedSubnetUnaryInterceptor(nets subnet.Set) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if updateMethods[info.FullMethod] {
+			if err := checkPeer(ctx, nets); err != nil {
+				return nil, err
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TrustedSubnetStreamInterceptor отклоняет потоки обновления от клиентов вне сетей nets.
+func TrustedSubnetStreamInterceptor(nets subnet.Set) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if updateMethods[info.FullMethod] {
+			if err := checkPeer(ss.Context(), nets); err != nil {
+				return err
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+func checkPeer(ctx context.Context, nets subnet.Set) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "client address is unknown")
+	}
+	if !nets.Contains(subnet.HostIP(p.Addr.String())) {
+		return status.Errorf(codes.PermissionDenied, "client %s is not in trusted subnet", p.Addr)
+	}
+	return nil
+}
+
+// HashUnaryInterceptor проверяет подпись метрики в запросе по правилам policy
+// и подписывает метрики ответа ключом policy.Key, если он задан.
+func HashUnaryInterceptor(policy signer.Policy) grpc.UnaryServerInterceptor {
+	key := policy.Key
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if m, ok := req.(*codec.Metric); ok {
+			if err := verify(policy, []codec.Metric{*m}); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := handler(ctx, req)
+		if err != nil || key == "" {
+			return resp, err
+		}
+		switch m := resp.(type) {
+		case *codec.Metric:
+			hashObject := signer.NewHashObject(key)
+			err = hashObject.Sign(&m.Metrics)
+		case *ListResponse:
+			err = sign(key, m.Metrics)
+		}
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to sign response")
+		}
+		return resp, nil
+	}
+}
+
+// HashStreamInterceptor проверяет подписи метрик каждого пакета потока.
+func HashStreamInterceptor(policy signer.Policy) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &verifyingStream{ServerStream: ss, policy: policy})
+	}
+}
+
+type verifyingStream struct {
+	grpc.ServerStream
+	policy signer.Policy
+}
+
+func (s *verifyingStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if batch, ok := m.(*MetricList); ok {
+		return verify(s.policy, batch.Metrics)
+	}
+	return nil
+}
+
+// verify проверяет подписи метрик. Без подписи метрики принимаются только в нестрогом режиме.
+func verify(policy signer.Policy, metrics []codec.Metric) error {
+	for i := range metrics {
+		err := policy.Check(&metrics[i].Metrics, false)
+		if errors.Is(err, signer.ErrSignatureMissing) {
+			return status.Errorf(codes.Unauthenticated, "metric %d '%s': signature is required", i, metrics[i].ID)
+		}
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "metric %d '%s': %v", i, metrics[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// sign подписывает найденные метрики.
+func sign(key string, metrics []codec.Metric) error {
+	hashObject := signer.NewHashObject(key)
+	for i := range metrics {
+		if metrics[i].NotFound {
+			continue
+		}
+		if err := hashObject.Sign(&metrics[i].Metrics); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package rpc
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/rebus2015/praktikum-devops/internal/codec"
+)
+
+// MetricList пакет метрик потока UpdateMetrics.
+type MetricList struct {
+	Metrics []codec.Metric
+}
+
+// UpdateSummary итог потока UpdateMetrics.
+type UpdateSummary struct {
+	Batches int64
+	Metrics int64
+}
+
+// ListRequest параметры ListMetrics.
+type ListRequest struct {
+	Type      string
+	Name      string
+	PageToken string
+	Limit     int32
+}
+
+// ListResponse страница ListMetrics.
+type ListResponse struct {
+	NextPageToken string
+	Metrics       []codec.Metric
+	Total         int32
+}
+
+// message сообщение с ручной реализацией кодирования protobuf.
+type message interface {
+	marshalProto() ([]byte, error)
+	unmarshalProto(b []byte) error
+}
+
+// wireCodec кодек gRPC для сообщений сервиса. Регистрируется под именем proto,
+// поэтому совместим с клиентами, сгенерированными из service.proto.
+type wireCodec struct{}
+
+func (wireCodec) Name() string {
+	return "proto"
+}
+
+func (wireCodec) Marshal(v any) ([]byte, error) {
+	switch m := v.(type) {
+	case *codec.Metric:
+		return codec.Protobuf{}.Marshal(m)
+	case message:
+		return m.marshalProto()
+	default:
+		return nil, fmt.Errorf("rpc: unsupported message type %T", v)
+	}
+}
+
+func (wireCodec) Unmarshal(data []byte, v any) error {
+	switch m := v.(type) {
+	case *codec.Metric:
+		return codec.Protobuf{}.Unmarshal(data, m)
+	case message:
+		return m.unmarshalProto(data)
+	default:
+		return fmt.Errorf("rpc: unsupported message type %T", v)
+	}
+}
+
+func (m *MetricList) marshalProto() ([]byte, error) {
+	return codec.Protobuf{}.MarshalList(m.Metrics)
+}
+
+func (m *MetricList) unmarshalProto(b []byte) (err error) {
+	m.Metrics, err = codec.Protobuf{}.UnmarshalList(b)
+	return err
+}
+
+func (m *UpdateSummary) marshalProto() ([]byte, error) {
+	var b []byte
+	b = appendVarint(b, 1, uint64(m.Batches))
+	b = appendVarint(b, 2, uint64(m.Metrics))
+	return b, nil
+}
+
+func (m *UpdateSummary) unmarshalProto(b []byte) error {
+	*m = UpdateSummary{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if typ != protowire.VarintType || (num != 1 && num != 2) {
+			return skipField
+		}
+		v, n := protowire.ConsumeVarint(b)
+		if num == 1 {
+			m.Batches = int64(v)
+		} else {
+			m.Metrics = int64(v)
+		}
+		return n
+	})
+}
+
+func (m *ListRequest) marshalProto() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, m.Type)
+	b = appendString(b, 2, m.Name)
+	b = appendVarint(b, 3, uint64(int64(m.Limit)))
+	b = appendString(b, 4, m.PageToken)
+	return b, nil
+}
+
+func (m *ListRequest) unmarshalProto(b []byte) error {
+	*m = ListRequest{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		var n int
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			m.Type, n = protowire.ConsumeString(b)
+		case num == 2 && typ == protowire.BytesType:
+			m.Name, n = protowire.ConsumeString(b)
+		case num == 3 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			m.Limit = int32(v)
+		case num == 4 && typ == protowire.BytesType:
+			m.PageToken, n = protowire.ConsumeString(b)
+		default:
+			return skipField
+		}
+		return n
+	})
+}
+
+func (m *ListResponse) marshalProto() ([]byte, error) {
+	b, err := codec.Protobuf{}.MarshalList(m.Metrics)
+	if err != nil {
+		return nil, err
+	}
+	b = appendString(b, 2, m.NextPageToken)
+	b = appendVarint(b, 3, uint64(int64(m.Total)))
+	return b, nil
+}
+
+func (m *ListResponse) unmarshalProto(b []byte) error {
+	metrics, err := codec.Protobuf{}.UnmarshalList(b)
+	if err != nil {
+		return err
+	}
+	*m = ListResponse{Metrics: metrics}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		var n int
+		switch {
+		case num == 2 && typ == protowire.BytesType:
+			m.NextPageToken, n = protowire.ConsumeString(b)
+		case num == 3 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			m.Total = int32(v)
+		default:
+			return skipField
+		}
+		return n
+	})
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// skipField результат разбора неизвестного поля. Отрицательные значения protowire — коды ошибок,
+// поэтому выбрано значение вне их диапазона.
+const skipField = math.MinInt32
+
+// consumeFields перебирает поля сообщения. field разбирает значение поля и возвращает число
+// прочитанных байт, либо skipField для пропуска неизвестного поля.
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) int) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protoError(n)
+		}
+		b = b[n:]
+		if n = field(num, typ, b); n == skipField {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protoError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func protoError(n int) error {
+	return fmt.Errorf("%w: %v", codec.ErrDecode, protowire.ParseError(n))
+}
@@ -0,0 +1,329 @@
+// Package rpc реализует gRPC-сервис метрик поверх storage.Repository.
+//
+// Сервис описан в service.proto. Сообщения кодируются вручную (см. messages.go) в стандартном
+// формате protobuf, поэтому сервер совместим с клиентами, сгенерированными protoc.
+// Проверка подписей метрик и доверенной сети выполняется перехватчиками (interceptors.go),
+// проверка токенов доступа — перехватчиками auth.go.
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/rebus2015/praktikum-devops/internal/auth"
+	"github.com/rebus2015/praktikum-devops/internal/codec"
+	"github.com/rebus2015/praktikum-devops/internal/model"
+	"github.com/rebus2015/praktikum-devops/internal/signer"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+	"github.com/rebus2015/praktikum-devops/internal/subnet"
+)
+
+const (
+	counter string = "counter"
+	gauge   string = "gauge"
+
+	serviceName      string = "praktikum.metrics.v2.Metrics"
+	methodGet        string = "/" + serviceName + "/GetMetric"
+	methodList       string = "/" + serviceName + "/ListMetrics"
+	methodUpdate     string = "/" + serviceName + "/UpdateMetrics"
+	defaultListLimit int32  = 100
+	maxListLimit     int32  = 1000
+	// shutdownTimeout время ожидания завершения активных вызовов при остановке.
+	shutdownTimeout = 10 * time.Second
+)
+
+// Options параметры сервера.
+type Options struct {
+	// TrustedSubnet разрешенные сети клиентов для методов обновления, пустой список — без ограничений.
+	TrustedSubnet subnet.Set
+	// Key ключ подписи метрик, пустое значение отключает проверку и подпись.
+	Key string
+	// SignMode режим проверки подписей, см. signer.Mode.
+	SignMode signer.Mode
+	// Agents реестр ключей агентов. Подпись запроса ключом агента передается только в HTTP API,
+	// но, как и там, с реестром метрики без подписи в строгом режиме отклоняются.
+	Agents *signer.AgentRegistry
+	// TLS настройки TLS, nil — соединения без шифрования.
+	TLS *tls.Config
+	// Tokens токены доступа, nil отключает аутентификацию. Отклоненные вызовы записываются в Audit.
+	Tokens *auth.Store
+	Audit  *auth.AuditLog
+}
+
+// ErrAgentSignatures в строгом режиме без общего ключа gRPC не может принять ни одной метрики:
+// подписи ключами агентов в gRPC не передаются.
+var ErrAgentSignatures = errors.New("agent signatures are not supported over gRPC, set a shared key or permissive sign mode")
+
+// Server gRPC-сервер метрик.
+type Server struct {
+	repo     storage.Repository
+	listener net.Listener
+	grpc     *grpc.Server
+}
+
+// NewServer открывает TCP-порт addr и регистрирует сервис метрик.
+func NewServer(addr string, repo storage.Repository, opts Options) (*Server, error) {
+	policy := signer.Policy{Key: opts.Key, Mode: opts.SignMode, Agents: opts.Agents != nil}
+	if policy.Agents && policy.Key == "" && policy.Strict() {
+		return nil, ErrAgentSignatures
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("grpc listen %s: %w", addr, err)
+	}
+	s := &Server{repo: repo, listener: listener}
+	unary := []grpc.UnaryServerInterceptor{}
+	stream := []grpc.StreamServerInterceptor{}
+	if len(opts.TrustedSubnet) > 0 {
+		unary = append(unary, TrustedSubnetUnaryInterceptor(opts.TrustedSubnet))
+		stream = append(stream, TrustedSubnetStreamInterceptor(opts.TrustedSubnet))
+	}
+	if opts.Tokens != nil {
+		audit := opts.Audit
+		if audit == nil {
+			audit = auth.NewAuditLog("")
+		}
+		unary = append(unary, AuthUnaryInterceptor(opts.Tokens, audit))
+		stream = append(stream, AuthStreamInterceptor(opts.Tokens, audit))
+	}
+	if policy.Key != "" || policy.Agents {
+		unary = append(unary, HashUnaryInterceptor(policy))
+		stream = append(stream, HashStreamInterceptor(policy))
+	}
+	serverOpts := []grpc.ServerOption{
+		grpc.ForceServerCodec(wireCodec{}),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+	if opts.TLS != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(opts.TLS)))
+	}
+	s.grpc = grpc.NewServer(serverOpts...)
+	s.grpc.RegisterService(&serviceDesc, s)
+	return s, nil
+}
+
+// Addr адрес, на котором сервер принимает соединения.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Run обслуживает соединения до отмены ctx. При остановке активные вызовы получают
+// shutdownTimeout на завершение, после чего соединения закрываются.
+func (s *Server) Run(ctx context.Context) {
+	served := make(chan error, 1)
+	go func() {
+		served <- s.grpc.Serve(s.listener)
+	}()
+	select {
+	case err := <-served:
+		if err != nil {
+			log.Printf("Error: [rpc.Server] serve: %v", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		log.Printf("gRPC server graceful stop timed out, closing connections")
+		s.grpc.Stop()
+	}
+	<-served
+}
+
+// UpdateMetrics применяет пакеты метрик из потока. Пакет с некорректной метрикой не применяется,
+// а поток завершается ошибкой InvalidArgument.
+func (s *Server) UpdateMetrics(stream grpc.ServerStream) error {
+	summary := &UpdateSummary{}
+	for {
+		batch := &MetricList{}
+		err := stream.RecvMsg(batch)
+		if errors.Is(err, io.EOF) {
+			return stream.SendMsg(summary)
+		}
+		if err != nil {
+			return err
+		}
+		metrics := make([]*model.Metrics, len(batch.Metrics))
+		for i := range batch.Metrics {
+			metrics[i] = &batch.Metrics[i].Metrics
+			if err = checkMetric(metrics[i]); err != nil {
+				return status.Errorf(codes.InvalidArgument, "batch %d, metric %d: %v", summary.Batches, i, err)
+			}
+		}
+		if err = s.repo.AddMetrics(metrics); err != nil {
+			log.Printf("Error: [rpc.UpdateMetrics] Add multiple metrics error: %v", err)
+			return status.Error(codes.Internal, "failed to store metrics")
+		}
+		summary.Batches++
+		summary.Metrics += int64(len(metrics))
+	}
+}
+
+// GetMetric возвращает значение метрики.
+func (s *Server) GetMetric(_ context.Context, req *codec.Metric) (*codec.Metric, error) {
+	resp := &codec.Metric{Metrics: model.Metrics{ID: req.ID, MType: req.MType, HashVersion: req.HashVersion}}
+	switch req.MType {
+	case counter:
+		delta, err := s.repo.GetCounter(req.ID)
+		if err != nil {
+			return nil, status.Errorf(codes.NotFound, "counter '%s' not found", req.ID)
+		}
+		resp.Delta = &delta
+	case gauge:
+		value, err := s.repo.GetGauge(req.ID)
+		if err != nil {
+			return nil, status.Errorf(codes.NotFound, "gauge '%s' not found", req.ID)
+		}
+		resp.Value = &value
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown metric type '%s'", req.MType)
+	}
+	return resp, nil
+}
+
+// ListMetrics возвращает страницу метрик, отсортированных по имени.
+func (s *Server) ListMetrics(_ context.Context, req *ListRequest) (*ListResponse, error) {
+	q := memstorage.Query{Sort: memstorage.SortID, Limit: int(defaultListLimit)}
+	switch req.Type {
+	case "", counter, gauge:
+		q.Type = req.Type
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown metric type '%s'", req.Type)
+	}
+	if req.Name != "" {
+		if _, err := path.Match(req.Name, ""); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "name: %v", err)
+		}
+		q.Match = func(id string) bool {
+			ok, _ := path.Match(req.Name, id)
+			return ok
+		}
+	}
+	if req.Limit < 0 || req.Limit > maxListLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be in range 1..%d", maxListLimit)
+	}
+	if req.Limit > 0 {
+		q.Limit = int(req.Limit)
+	}
+	if req.PageToken != "" {
+		c, err := memstorage.ParseCursor(req.PageToken)
+		if err != nil || c.Sort != q.Sort || c.Desc {
+			return nil, status.Error(codes.InvalidArgument, "malformed page token")
+		}
+		q.After = c
+	}
+	page, err := s.repo.QueryMetrics(q)
+	if err != nil {
+		log.Printf("Error: [rpc.ListMetrics] query metrics error: %v", err)
+		return nil, status.Error(codes.Internal, "failed to query metrics")
+	}
+	resp := &ListResponse{Metrics: make([]codec.Metric, len(page.Metrics)), Total: int32(page.Total)}
+	for i := range page.Metrics {
+		resp.Metrics[i].Metrics = page.Metrics[i]
+	}
+	if page.Next != nil {
+		if resp.NextPageToken, err = page.Next.Encode(); err != nil {
+			return nil, status.Error(codes.Internal, "failed to encode page token")
+		}
+	}
+	return resp, nil
+}
+
+// checkMetric проверяет, что у метрики заданы имя, известный тип и значение.
+func checkMetric(m *model.Metrics) error {
+	if m.ID == "" {
+		return errors.New("id is empty")
+	}
+	switch m.MType {
+	case counter:
+		if m.Delta == nil {
+			return errors.New("counter delta is missing")
+		}
+	case gauge:
+		if m.Value == nil {
+			return errors.New("gauge value is missing")
+		}
+	default:
+		return fmt.Errorf("unknown metric type '%s'", m.MType)
+	}
+	return nil
+}
+
+// metricsService набор методов, которые вызывает serviceDesc.
+type metricsService interface {
+	UpdateMetrics(stream grpc.ServerStream) error
+	GetMetric(ctx context.Context, req *codec.Metric) (*codec.Metric, error)
+	ListMetrics(ctx context.Context, req *ListRequest) (*ListResponse, error)
+}
+
+// serviceDesc описание сервиса, которое в сгенерированном коде создает protoc-gen-go-grpc.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*metricsService)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetMetric",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				req := &codec.Metric{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, req any) (any, error) {
+					return srv.(metricsService).GetMetric(ctx, req.(*codec.Metric))
+				}
+				if interceptor == nil {
+					return handler(ctx, req)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: methodGet}
+				return interceptor(ctx, req, info, handler)
+			},
+		},
+		{
+			MethodName: "ListMetrics",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				req := &ListRequest{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, req any) (any, error) {
+					return srv.(metricsService).ListMetrics(ctx, req.(*ListRequest))
+				}
+				if interceptor == nil {
+					return handler(ctx, req)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: methodList}
+				return interceptor(ctx, req, info, handler)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "UpdateMetrics",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(metricsService).UpdateMetrics(stream)
+			},
+			ClientStreams: true,
+		},
+	},
+	Metadata: "service.proto",
+}
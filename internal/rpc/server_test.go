@@ -0,0 +1,306 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/rebus2015/praktikum-devops/internal/auth"
+	"github.com/rebus2015/praktikum-devops/internal/codec"
+	"github.com/rebus2015/praktikum-devops/internal/model"
+	"github.com/rebus2015/praktikum-devops/internal/signer"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+	"github.com/rebus2015/praktikum-devops/internal/subnet"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func startServer(t *testing.T, opts Options) (storage.Repository, *Client) {
+	t.Helper()
+	var repo storage.Repository = storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	srv, err := NewServer("127.0.0.1:0", repo, opts)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Run(ctx)
+	}()
+	conn, err := grpc.Dial(srv.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		<-done
+	})
+	return repo, NewClient(conn)
+}
+
+func metric(id, mtype string, delta *int64, value *float64) codec.Metric {
+	return codec.Metric{Metrics: model.Metrics{ID: id, MType: mtype, Delta: delta, Value: value}}
+}
+
+func TestServer_UpdateGetList(t *testing.T) {
+	repo, client := startServer(t, Options{})
+	ctx := context.Background()
+
+	stream, err := client.UpdateMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&MetricList{Metrics: []codec.Metric{
+		metric("PollCount", counter, ptr(int64(2)), nil),
+		metric("Alloc", gauge, nil, ptr(1.5)),
+	}}))
+	require.NoError(t, stream.Send(&MetricList{Metrics: []codec.Metric{
+		metric("PollCount", counter, ptr(int64(3)), nil),
+		metric("cpu_1", gauge, nil, ptr(0.5)),
+	}}))
+	summary, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, &UpdateSummary{Batches: 2, Metrics: 4}, summary)
+
+	got, err := client.GetMetric(ctx, &codec.Metric{Metrics: model.Metrics{ID: "PollCount", MType: counter}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *got.Delta)
+
+	_, err = client.GetMetric(ctx, &codec.Metric{Metrics: model.Metrics{ID: "none", MType: gauge}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	page, err := client.ListMetrics(ctx, &ListRequest{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), page.Total)
+	require.Len(t, page.Metrics, 2)
+	assert.Equal(t, "Alloc", page.Metrics[0].ID)
+	assert.Equal(t, "PollCount", page.Metrics[1].ID)
+	require.NotEmpty(t, page.NextPageToken)
+	page, err = client.ListMetrics(ctx, &ListRequest{Limit: 2, PageToken: page.NextPageToken})
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 1)
+	assert.Equal(t, "cpu_1", page.Metrics[0].ID)
+	assert.Empty(t, page.NextPageToken)
+
+	page, err = client.ListMetrics(ctx, &ListRequest{Type: gauge, Name: "cpu_*"})
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 1)
+	assert.Equal(t, 0.5, *page.Metrics[0].Value)
+
+	_, err = client.ListMetrics(ctx, &ListRequest{PageToken: "!"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// пакет с некорректной метрикой не применяется
+	stream, err = client.UpdateMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&MetricList{Metrics: []codec.Metric{
+		metric("fresh", gauge, nil, ptr(1.0)),
+		metric("broken", gauge, nil, nil),
+	}}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = repo.GetGauge("fresh")
+	assert.Error(t, err)
+}
+
+func TestServer_HashInterceptor(t *testing.T) {
+	const key = "secret"
+	_, client := startServer(t, Options{Key: key})
+	ctx := context.Background()
+	hashObject := signer.NewHashObject(key)
+
+	signed := metric("Alloc", gauge, nil, ptr(2.0))
+	require.NoError(t, hashObject.Sign(&signed.Metrics))
+	forged := metric("Alloc", gauge, nil, ptr(3.0))
+	forged.Hash = signed.Hash
+
+	stream, err := client.UpdateMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&MetricList{Metrics: []codec.Metric{signed}}))
+	_, err = stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	stream, err = client.UpdateMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&MetricList{Metrics: []codec.Metric{forged}}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err = client.UpdateMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&MetricList{Metrics: []codec.Metric{metric("Alloc", gauge, nil, ptr(4.0))}}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	got, err := client.GetMetric(ctx, &codec.Metric{Metrics: model.Metrics{ID: "Alloc", MType: gauge}})
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, *got.Value)
+	passed, err := hashObject.Verify(&got.Metrics)
+	require.NoError(t, err)
+	assert.True(t, passed)
+}
+
+func TestServer_HashInterceptorPermissive(t *testing.T) {
+	_, client := startServer(t, Options{Key: "secret", SignMode: signer.ModePermissive})
+	ctx := context.Background()
+
+	stream, err := client.UpdateMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&MetricList{Metrics: []codec.Metric{metric("Alloc", gauge, nil, ptr(4.0))}}))
+	_, err = stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	got, err := client.GetMetric(ctx, &codec.Metric{Metrics: model.Metrics{ID: "Alloc", MType: gauge}})
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, *got.Value)
+}
+
+func TestServer_AgentRegistry(t *testing.T) {
+	dir := t.TempDir()
+	pub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "agent-1.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+	agents, err := signer.NewAgentRegistry(dir)
+	require.NoError(t, err)
+
+	// в строгом режиме без общего ключа gRPC не смог бы принять ни одной метрики
+	_, err = NewServer("127.0.0.1:0", nil, Options{Agents: agents})
+	assert.ErrorIs(t, err, ErrAgentSignatures)
+
+	send := func(client *Client, m codec.Metric) error {
+		stream, err := client.UpdateMetrics(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&MetricList{Metrics: []codec.Metric{m}}))
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	// с общим ключом метрики без подписи отклоняются, подписанные принимаются
+	_, client := startServer(t, Options{Key: "secret", Agents: agents})
+	assert.Equal(t, codes.Unauthenticated, status.Code(send(client, metric("Alloc", gauge, nil, ptr(1.0)))))
+	signed := metric("Alloc", gauge, nil, ptr(2.0))
+	hashObject := signer.NewHashObject("secret")
+	require.NoError(t, hashObject.Sign(&signed.Metrics))
+	assert.NoError(t, send(client, signed))
+
+	_, client = startServer(t, Options{Agents: agents, SignMode: signer.ModePermissive})
+	assert.NoError(t, send(client, metric("Alloc", gauge, nil, ptr(1.0))))
+	got, err := client.GetMetric(context.Background(), &codec.Metric{Metrics: model.Metrics{ID: "Alloc", MType: gauge}})
+	require.NoError(t, err)
+	assert.Empty(t, got.Hash)
+}
+
+func TestServer_TrustedSubnet(t *testing.T) {
+	loopback, err := subnet.Parse("10.0.0.0/8,127.0.0.0/8")
+	require.NoError(t, err)
+	_, client := startServer(t, Options{TrustedSubnet: loopback})
+	_, err = client.ListMetrics(context.Background(), &ListRequest{})
+	assert.NoError(t, err)
+
+	private, err := subnet.Parse("10.0.0.0/8")
+	require.NoError(t, err)
+	_, client = startServer(t, Options{TrustedSubnet: private})
+	// чтение не ограничивается доверенной сетью
+	_, err = client.ListMetrics(context.Background(), &ListRequest{})
+	assert.NoError(t, err)
+	_, err = client.GetMetric(context.Background(), &codec.Metric{Metrics: model.Metrics{ID: "none", MType: gauge}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	stream, err := client.UpdateMetrics(context.Background())
+	require.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestServer_Tokens(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "tokens.json")
+	require.NoError(t, os.WriteFile(tokenFile, []byte(`[
+		{"name": "agent", "sha256": "`+auth.HashToken("agent-secret")+`", "scopes": ["write"], "prefixes": ["web_"]},
+		{"name": "viewer", "sha256": "`+auth.HashToken("viewer-secret")+`", "scopes": ["read"]}
+	]`), 0o600))
+	tokens, err := auth.NewStore(tokenFile)
+	require.NoError(t, err)
+	auditFile := filepath.Join(dir, "audit.log")
+	repo, client := startServer(t, Options{Tokens: tokens, Audit: auth.NewAuditLog(auditFile)})
+
+	withToken := func(md ...string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), md...)
+	}
+	update := func(ctx context.Context, id string) error {
+		stream, err := client.UpdateMetrics(ctx)
+		require.NoError(t, err)
+		if err = stream.Send(&MetricList{Metrics: []codec.Metric{metric(id, gauge, nil, ptr(1.0))}}); err != nil {
+			return err
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+	agent := withToken("authorization", "Bearer agent-secret")
+	viewer := withToken("x-api-key", "viewer-secret")
+	get := &codec.Metric{Metrics: model.Metrics{ID: "web_rps", MType: gauge}}
+
+	assert.NoError(t, update(agent, "web_rps"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(update(agent, "db_rps")))
+	assert.Equal(t, codes.PermissionDenied, status.Code(update(viewer, "web_rps")))
+	assert.Equal(t, codes.Unauthenticated, status.Code(update(context.Background(), "web_rps")))
+	assert.Equal(t, codes.Unauthenticated, status.Code(update(withToken("x-api-key", "guess"), "web_rps")))
+	_, err = repo.GetGauge("db_rps")
+	assert.Error(t, err)
+
+	got, err := client.GetMetric(viewer, get)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, *got.Value)
+	_, err = client.GetMetric(agent, get)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.ListMetrics(viewer, &ListRequest{})
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(auditFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 5)
+	assert.Contains(t, lines[0], `"reason":"metric_forbidden"`)
+	assert.Contains(t, lines[0], `"metric":"db_rps"`)
+	assert.Contains(t, lines[1], `"reason":"insufficient_scope"`)
+	assert.Contains(t, lines[2], `"reason":"missing_token"`)
+	assert.Contains(t, lines[3], `"reason":"invalid_token"`)
+	assert.Contains(t, lines[4], `"path":"/praktikum.metrics.v2.Metrics/GetMetric"`)
+}
+
+func TestMessages_roundTrip(t *testing.T) {
+	c := wireCodec{}
+	msgs := []struct {
+		in  any
+		out any
+	}{
+		{&UpdateSummary{Batches: 3, Metrics: 70}, &UpdateSummary{}},
+		{&ListRequest{Type: gauge, Name: "cpu_*", Limit: 10, PageToken: "abc"}, &ListRequest{}},
+		{&ListResponse{Metrics: []codec.Metric{metric("a", counter, ptr(int64(1)), nil)}, NextPageToken: "n", Total: 7},
+			&ListResponse{}},
+		{&MetricList{Metrics: []codec.Metric{metric("b", gauge, nil, ptr(2.5))}}, &MetricList{}},
+	}
+	for _, m := range msgs {
+		data, err := c.Marshal(m.in)
+		require.NoError(t, err)
+		require.NoError(t, c.Unmarshal(data, m.out))
+		assert.Equal(t, m.in, m.out)
+	}
+	_, err := c.Marshal(struct{}{})
+	assert.Error(t, err)
+	assert.ErrorIs(t, c.Unmarshal([]byte{0x1a, 0x05}, &ListRequest{}), codec.ErrDecode)
+}
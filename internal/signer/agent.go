@@ -0,0 +1,247 @@
+package signer
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Заголовки подписи запроса ключом агента. Время и nonce передаются в тех же заголовках,
+// что и при подписи общим ключом (RequestTimestampHeader, RequestNonceHeader).
+const (
+	AgentIDHeader        string = "X-Agent-ID"
+	AgentSignatureHeader string = "X-Agent-Signature"
+)
+
+var (
+	ErrUnknownAgent   = errors.New("unknown agent")
+	ErrAgentSignature = errors.New("agent signature mismatch")
+)
+
+var (
+	// AgentRequests счетчики запросов, подпись которых проверена ключом агента, по идентификаторам агентов.
+	AgentRequests = expvar.NewMap("agent_requests")
+	// AgentMetrics счетчики метрик, принятых от агентов с проверенной подписью.
+	AgentMetrics = expvar.NewMap("agent_metrics")
+)
+
+// HasAgentSignature сообщает, подписан ли запрос ключом агента.
+func HasAgentSignature(h http.Header) bool {
+	return h.Get(AgentIDHeader) != ""
+}
+
+// LoadAgentKey читает закрытый ключ Ed25519 агента из PEM-файла в формате PKCS#8,
+// например созданного командой openssl genpkey -algorithm ed25519.
+func LoadAgentKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("agent key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("agent key '%s': no PEM block found", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("agent key '%s': %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("agent key '%s': not an Ed25519 key", path)
+	}
+	return key, nil
+}
+
+// AgentSigner подписывает HTTP-запросы закрытым ключом агента Ed25519.
+// Подписывается та же каноническая строка, что и при подписи общим ключом.
+type AgentSigner struct {
+	id  string
+	key ed25519.PrivateKey
+	now func() time.Time
+}
+
+// NewAgentSigner создает подписчика запросов агента id с ключом key.
+func NewAgentSigner(id string, key ed25519.PrivateKey) *AgentSigner {
+	return &AgentSigner{id: id, key: key, now: time.Now}
+}
+
+// Sign устанавливает заголовки подписи агента для запроса с телом body.
+func (s *AgentSigner) Sign(req *http.Request, body []byte) error {
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	sig := ed25519.Sign(s.key, requestMessage(req.Method, req.URL.RequestURI(), timestamp, nonce, body))
+	req.Header.Set(AgentIDHeader, s.id)
+	req.Header.Set(RequestTimestampHeader, timestamp)
+	req.Header.Set(RequestNonceHeader, nonce)
+	req.Header.Set(AgentSignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	return nil
+}
+
+// Transport возвращает http.RoundTripper, подписывающий каждую попытку отправки запроса.
+func (s *AgentSigner) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &signingTransport{signer: s, base: base}
+}
+
+// AgentRegistry реестр открытых ключей агентов.
+//
+// Ключи читаются из каталога: файл <id>.pem содержит открытый ключ Ed25519 агента id в формате PKIX,
+// например полученный командой openssl pkey -pubout. Reload перечитывает каталог без перезапуска сервера.
+type AgentRegistry struct {
+	path string
+	mu   sync.RWMutex
+	keys map[string]ed25519.PublicKey
+}
+
+// NewAgentRegistry загружает ключи агентов из каталога path.
+func NewAgentRegistry(path string) (*AgentRegistry, error) {
+	r := &AgentRegistry{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload перечитывает ключи агентов. При ошибке реестр не меняется.
+func (r *AgentRegistry) Reload() error {
+	keys, err := loadAgentKeys(r.path)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.keys = keys
+	r.mu.Unlock()
+	return nil
+}
+
+// Key возвращает открытый ключ агента id.
+func (r *AgentRegistry) Key(id string) (ed25519.PublicKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownAgent, id)
+	}
+	return key, nil
+}
+
+// IDs возвращает идентификаторы агентов по алфавиту.
+func (r *AgentRegistry) IDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.keys))
+	for id := range r.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func loadAgentKeys(path string) (map[string]ed25519.PublicKey, error) {
+	files, err := filepath.Glob(filepath.Join(path, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("agent registry: %w", err)
+	}
+	keys := make(map[string]ed25519.PublicKey, len(files))
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("agent registry: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("agent registry '%s': no PEM block found", name)
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("agent registry '%s': %w", name, err)
+		}
+		key, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("agent registry '%s': not an Ed25519 public key", name)
+		}
+		keys[strings.TrimSuffix(filepath.Base(name), ".pem")] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("agent registry '%s': no public keys found", path)
+	}
+	return keys, nil
+}
+
+// AgentVerifier проверяет подпись запроса ключом агента из реестра, окно расхождения часов
+// и однократность nonce.
+type AgentVerifier struct {
+	registry *AgentRegistry
+	maxSkew  time.Duration
+	nonces   *NonceCache
+	now      func() time.Time
+}
+
+// NewAgentVerifier создает проверяющего подписи агентов из registry с допустимым расхождением часов maxSkew.
+func NewAgentVerifier(registry *AgentRegistry, maxSkew time.Duration) *AgentVerifier {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	return &AgentVerifier{
+		registry: registry,
+		maxSkew:  maxSkew,
+		nonces:   NewNonceCache(2 * maxSkew),
+		now:      time.Now,
+	}
+}
+
+// Verify проверяет запрос, подписанный агентом, и возвращает идентификатор агента.
+// Возвращает ErrUnknownAgent, ErrStaleRequest, ErrAgentSignature или ErrReplayedRequest.
+// Отклонения учитываются в Rejections, принятые запросы — в AgentRequests.
+func (v *AgentVerifier) Verify(method, uri string, h http.Header, body []byte) (string, error) {
+	id := h.Get(AgentIDHeader)
+	err := v.verify(id, method, uri, h, body)
+	countRejection(err)
+	if err != nil {
+		return "", err
+	}
+	AgentRequests.Add(id, 1)
+	return id, nil
+}
+
+func (v *AgentVerifier) verify(id, method, uri string, h http.Header, body []byte) error {
+	key, err := v.registry.Key(id)
+	if err != nil {
+		return err
+	}
+	timestamp := h.Get(RequestTimestampHeader)
+	nonce := h.Get(RequestNonceHeader)
+	if timestamp == "" || nonce == "" {
+		return fmt.Errorf("%w: timestamp and nonce are required", ErrAgentSignature)
+	}
+	now := v.now()
+	if err := checkTimestamp(timestamp, now, v.maxSkew); err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(h.Get(AgentSignatureHeader))
+	if err != nil || !ed25519.Verify(key, requestMessage(method, uri, timestamp, nonce, body), sig) {
+		return ErrAgentSignature
+	}
+	// nonce уникален в пределах агента
+	if !v.nonces.Add(id+"/"+nonce, now) {
+		return ErrReplayedRequest
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func agentRequests(id string) int64 {
+	v, ok := AgentRequests.Get(id).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+func writeAgentKey(t *testing.T, dir, id string) ed25519.PrivateKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, id+".pem"), data, 0o600))
+	return priv
+}
+
+func TestLoadAgentKey(t *testing.T) {
+	dir := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	name := filepath.Join(dir, "agent.pem")
+	require.NoError(t, os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	got, err := LoadAgentKey(name)
+	require.NoError(t, err)
+	assert.Equal(t, priv, got)
+
+	_, err = LoadAgentKey(filepath.Join(dir, "none.pem"))
+	assert.Error(t, err)
+	rsaName := filepath.Join(dir, "rsa.pem")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	writeKeys(t, rsaName, true, rsaKey)
+	_, err = LoadAgentKey(rsaName)
+	assert.Error(t, err)
+}
+
+func TestAgentRegistry(t *testing.T) {
+	dir := t.TempDir()
+	writeAgentKey(t, dir, "host-1")
+	writeAgentKey(t, dir, "host-2")
+
+	registry, err := NewAgentRegistry(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"host-1", "host-2"}, registry.IDs())
+	_, err = registry.Key("host-3")
+	assert.ErrorIs(t, err, ErrUnknownAgent)
+
+	writeAgentKey(t, dir, "host-3")
+	require.NoError(t, registry.Reload())
+	_, err = registry.Key("host-3")
+	assert.NoError(t, err)
+
+	// некорректный файл не заменяет загруженный реестр
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("garbage"), 0o600))
+	assert.Error(t, registry.Reload())
+	assert.Len(t, registry.IDs(), 3)
+
+	_, err = NewAgentRegistry(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestAgentVerifier(t *testing.T) {
+	dir := t.TempDir()
+	key1 := writeAgentKey(t, dir, "host-1")
+	writeAgentKey(t, dir, "host-2")
+	registry, err := NewAgentRegistry(dir)
+	require.NoError(t, err)
+
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	now := time.Unix(1700000000, 0)
+	signed := func(t *testing.T, id string, key ed25519.PrivateKey) *http.Request {
+		t.Helper()
+		s := NewAgentSigner(id, key)
+		s.now = func() time.Time { return now }
+		req := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(body))
+		require.NoError(t, s.Sign(req, body))
+		return req
+	}
+	verifier := func(skew time.Duration) *AgentVerifier {
+		v := NewAgentVerifier(registry, time.Minute)
+		v.now = func() time.Time { return now.Add(skew) }
+		return v
+	}
+	_, stranger, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		body    []byte
+		verify  *AgentVerifier
+		wantErr error
+		reason  string
+	}{
+		{"valid", signed(t, "host-1", key1), body, verifier(0), nil, ""},
+		{"key of another agent", signed(t, "host-2", key1), body, verifier(0), ErrAgentSignature, RejectMismatch},
+		{"unknown agent", signed(t, "host-9", stranger), body, verifier(0), ErrUnknownAgent, RejectUnknownAgent},
+		{"body tampered", signed(t, "host-1", key1), body[1:], verifier(0), ErrAgentSignature, RejectMismatch},
+		{"stale", signed(t, "host-1", key1), body, verifier(2 * time.Minute), ErrStaleRequest, RejectStale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accepted := agentRequests("host-1")
+			rejected := rejections(tt.reason)
+			id, err := tt.verify.Verify(tt.req.Method, tt.req.URL.RequestURI(), tt.req.Header, tt.body)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Equal(t, rejected+1, rejections(tt.reason))
+				assert.Equal(t, accepted, agentRequests("host-1"))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "host-1", id)
+			assert.Equal(t, accepted+1, agentRequests("host-1"))
+		})
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		v := verifier(0)
+		req := signed(t, "host-1", key1)
+		_, err := v.Verify(req.Method, req.URL.RequestURI(), req.Header, body)
+		require.NoError(t, err)
+		_, err = v.Verify(req.Method, req.URL.RequestURI(), req.Header, body)
+		assert.ErrorIs(t, err, ErrReplayedRequest)
+	})
+}
@@ -0,0 +1,103 @@
+package signer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// EncryptionHeader заголовок HTTP, задающий схему шифрования тела запроса.
+	// Без заголовка тело считается зашифрованным поблочно (DecryptMessage).
+	EncryptionHeader string = "X-Encryption"
+	// EncryptionHybrid значение EncryptionHeader для конверта EncryptEnvelope.
+	EncryptionHybrid string = "rsa-oaep+aes-256-gcm"
+
+	envelopeVersion byte = 1
+	aesKeySize      int  = 32
+)
+
+// envelopeLabel метка RSA-OAEP: ключ, обернутый для конверта, нельзя подставить в другой протокол.
+var envelopeLabel = []byte("praktikum-devops envelope v1")
+
+var ErrEnvelope = errors.New("malformed envelope")
+
+// EncryptEnvelope шифрует сообщение случайным ключом AES-256-GCM и оборачивает ключ
+// открытым ключом RSA (OAEP, SHA-256).
+//
+// Формат конверта:
+//
+//	версия (1 байт) | длина обернутого ключа (2 байта, big endian) | обернутый ключ | nonce | шифротекст с тегом GCM
+//
+// Заголовок конверта до nonce включительно защищен как дополнительные данные GCM.
+func EncryptEnvelope(pub *rsa.PublicKey, msg []byte) ([]byte, error) {
+	key := make([]byte, aesKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, envelopeLabel)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 3, 3+len(wrapped)+gcm.NonceSize())
+	header[0] = envelopeVersion
+	binary.BigEndian.PutUint16(header[1:], uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	header = append(header, nonce...)
+	return gcm.Seal(header, nonce, msg, header), nil
+}
+
+// DecryptEnvelope расшифровывает конверт, созданный EncryptEnvelope.
+func DecryptEnvelope(key *rsa.PrivateKey, envelope []byte) ([]byte, error) {
+	if len(envelope) < 3 || envelope[0] != envelopeVersion {
+		return nil, fmt.Errorf("%w: unknown version", ErrEnvelope)
+	}
+	keyLen := int(binary.BigEndian.Uint16(envelope[1:3]))
+	if keyLen != key.Size() || len(envelope) < 3+keyLen {
+		return nil, fmt.Errorf("%w: bad wrapped key length", ErrEnvelope)
+	}
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, envelope[3:3+keyLen], envelopeLabel)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unwrap data key: %w", ErrEnvelope, err)
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	headerLen := 3 + keyLen + gcm.NonceSize()
+	if len(envelope) < headerLen+gcm.Overhead() {
+		return nil, fmt.Errorf("%w: truncated", ErrEnvelope)
+	}
+	header := envelope[:headerLen]
+	msg, err := gcm.Open(nil, header[3+keyLen:], envelope[headerLen:], header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEnvelope, err)
+	}
+	return msg, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("gcm: %w", err)
+	}
+	return gcm, nil
+}
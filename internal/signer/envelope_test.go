@@ -0,0 +1,49 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	// сообщение заметно больше одного блока OAEP
+	message := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1.5}`), 200)
+	envelope, err := EncryptEnvelope(&key.PublicKey, message)
+	require.NoError(t, err)
+	assert.Len(t, envelope, 3+key.Size()+12+len(message)+16)
+
+	decrypted, err := DecryptEnvelope(key, envelope)
+	require.NoError(t, err)
+	assert.Equal(t, message, decrypted)
+
+	tests := []struct {
+		name   string
+		key    *rsa.PrivateKey
+		mutate func([]byte) []byte
+	}{
+		{"wrong key", other, func(b []byte) []byte { return b }},
+		{"bad version", key, func(b []byte) []byte { b[0] = 2; return b }},
+		{"wrapped key tampered", key, func(b []byte) []byte { b[10] ^= 1; return b }},
+		{"nonce tampered", key, func(b []byte) []byte { b[3+key.Size()] ^= 1; return b }},
+		{"ciphertext tampered", key, func(b []byte) []byte { b[len(b)-20] ^= 1; return b }},
+		{"truncated", key, func(b []byte) []byte { return b[:3+key.Size()+5] }},
+		{"empty", key, func([]byte) []byte { return nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.mutate(append([]byte(nil), envelope...))
+			_, err := DecryptEnvelope(tt.key, tampered)
+			assert.ErrorIs(t, err, ErrEnvelope)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package signer
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeyIDHeader заголовок HTTP с идентификатором открытого ключа, которым агент зашифровал тело запроса.
+const KeyIDHeader string = "X-Key-ID"
+
+var ErrUnknownKey = errors.New("unknown key id")
+
+// KeyID идентификатор ключа: первые 8 байт SHA-256 от открытого ключа в формате PKIX, в hex.
+// Агент и сервер вычисляют его независимо, поэтому идентификаторы не нужно настраивать.
+func KeyID(pub *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Keyring набор закрытых ключей сервера для ротации.
+//
+// Ключи читаются из PEM-файла, который может содержать несколько ключей, или из каталога
+// с файлами *.pem. Reload перечитывает источник без перезапуска сервера.
+type Keyring struct {
+	path string
+	mu   sync.RWMutex
+	ids  []string
+	keys map[string]*rsa.PrivateKey
+}
+
+// NewKeyring загружает ключи из файла или каталога path.
+func NewKeyring(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload перечитывает ключи. При ошибке набор ключей не меняется.
+func (k *Keyring) Reload() error {
+	ids, keys, err := loadKeys(k.path)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.ids, k.keys = ids, keys
+	k.mu.Unlock()
+	return nil
+}
+
+// Key возвращает ключ с идентификатором id.
+func (k *Keyring) Key(id string) (*rsa.PrivateKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// Keys возвращает все ключи в порядке загрузки.
+func (k *Keyring) Keys() []*rsa.PrivateKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make([]*rsa.PrivateKey, len(k.ids))
+	for i, id := range k.ids {
+		keys[i] = k.keys[id]
+	}
+	return keys
+}
+
+// IDs возвращает идентификаторы ключей в порядке загрузки.
+func (k *Keyring) IDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]string(nil), k.ids...)
+}
+
+func loadKeys(path string) ([]string, map[string]*rsa.PrivateKey, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("keyring: %w", err)
+	}
+	files := []string{path}
+	if stat.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.pem")); err != nil {
+			return nil, nil, fmt.Errorf("keyring: %w", err)
+		}
+		sort.Strings(files)
+	}
+	ids := make([]string, 0, len(files))
+	keys := make(map[string]*rsa.PrivateKey, len(files))
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("keyring: %w", err)
+		}
+		parsed, err := parsePrivateKeys(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("keyring '%s': %w", name, err)
+		}
+		for _, key := range parsed {
+			id := KeyID(&key.PublicKey)
+			if _, ok := keys[id]; ok {
+				continue
+			}
+			ids = append(ids, id)
+			keys[id] = key
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil, fmt.Errorf("keyring '%s': no private keys found", path)
+	}
+	return ids, keys, nil
+}
+
+// parsePrivateKeys разбирает все PEM-блоки закрытых ключей RSA в формате PKCS#1 или PKCS#8.
+func parsePrivateKeys(data []byte) ([]*rsa.PrivateKey, error) {
+	var keys []*rsa.PrivateKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if strings.Contains(strings.ToUpper(block.Type), "PUBLIC KEY") {
+			continue
+		}
+		if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			keys = append(keys, key)
+			continue
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s block: %w", block.Type, err)
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s block is not an RSA key", block.Type)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("error reading key bytes")
+	}
+	return keys, nil
+}
@@ -0,0 +1,83 @@
+package signer
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeys(t *testing.T, name string, pkcs8 bool, keys ...*rsa.PrivateKey) {
+	t.Helper()
+	var data []byte
+	for _, key := range keys {
+		block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+		if pkcs8 {
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			require.NoError(t, err)
+			block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		}
+		data = append(data, pem.EncodeToMemory(block)...)
+	}
+	require.NoError(t, os.WriteFile(name, data, 0o600))
+}
+
+func TestKeyring(t *testing.T) {
+	keys := make([]*rsa.PrivateKey, 3)
+	for i := range keys {
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.NoError(t, err)
+		keys[i] = key
+	}
+	ids := []string{KeyID(&keys[0].PublicKey), KeyID(&keys[1].PublicKey), KeyID(&keys[2].PublicKey)}
+	assert.Len(t, ids[0], 16)
+	assert.NotEqual(t, ids[0], ids[1])
+
+	t.Run("multiple PEM blocks in one file", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "keys.pem")
+		writeKeys(t, name, false, keys[0], keys[1], keys[0])
+		ring, err := NewKeyring(name)
+		require.NoError(t, err)
+		assert.Equal(t, ids[:2], ring.IDs())
+		key, err := ring.Key(ids[1])
+		require.NoError(t, err)
+		assert.True(t, key.Equal(keys[1]))
+		_, err = ring.Key(ids[2])
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	})
+
+	t.Run("directory with reload", func(t *testing.T) {
+		dir := t.TempDir()
+		writeKeys(t, filepath.Join(dir, "a.pem"), false, keys[0])
+		writeKeys(t, filepath.Join(dir, "b.pem"), true, keys[1])
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a key"), 0o600))
+		ring, err := NewKeyring(dir)
+		require.NoError(t, err)
+		assert.Equal(t, ids[:2], ring.IDs())
+
+		// ротация: новый ключ добавлен, старый удален
+		writeKeys(t, filepath.Join(dir, "c.pem"), false, keys[2])
+		require.NoError(t, os.Remove(filepath.Join(dir, "a.pem")))
+		require.NoError(t, ring.Reload())
+		assert.Equal(t, ids[1:], ring.IDs())
+		assert.Len(t, ring.Keys(), 2)
+
+		// некорректный файл не сбрасывает загруженные ключи
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "d.pem"), []byte("garbage"), 0o600))
+		assert.Error(t, ring.Reload())
+		assert.Equal(t, ids[1:], ring.IDs())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := NewKeyring(filepath.Join(t.TempDir(), "missing.pem"))
+		assert.Error(t, err)
+		_, err = NewKeyring(t.TempDir())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,109 @@
+package signer
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+)
+
+// Mode режим проверки подписей входящих метрик.
+type Mode string
+
+const (
+	// ModeStrict при заданном ключе метрики без подписи отклоняются, если запрос не подписан целиком.
+	ModeStrict Mode = "strict"
+	// ModePermissive метрики без подписи принимаются, проверяются только переданные подписи.
+	ModePermissive Mode = "permissive"
+)
+
+// Причины отклонения подписей, ключи счетчика Rejections.
+const (
+	RejectMissing  string = "missing"
+	RejectMismatch string = "mismatch"
+	RejectInvalid  string = "invalid"
+	RejectStale    string = "stale"
+	RejectReplay   string = "replay"
+	// RejectUnknownAgent запрос подписан агентом, которого нет в реестре
+	RejectUnknownAgent string = "unknown_agent"
+)
+
+var (
+	ErrSignatureMissing  = errors.New("signature is required")
+	ErrSignatureMismatch = errors.New("signature verification failed")
+	ErrMode              = errors.New("unknown sign mode")
+)
+
+// Rejections счетчики отклоненных подписей по причинам, публикуются в /debug/vars.
+var Rejections = expvar.NewMap("signature_rejections")
+
+// ParseMode разбирает режим проверки подписей. Пустая строка означает ModeStrict.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", ModeStrict:
+		return ModeStrict, nil
+	case ModePermissive:
+		return ModePermissive, nil
+	default:
+		return "", fmt.Errorf("%w '%s'", ErrMode, s)
+	}
+}
+
+// Policy правила проверки подписей входящих метрик.
+type Policy struct {
+	Key  string
+	Mode Mode
+	// Agents сервер принимает запросы, подписанные ключами агентов (см. AgentRegistry)
+	Agents bool
+	// Requests запросы записи принимаются только с подписью целиком (см. RequireRequest)
+	Requests bool
+}
+
+// Strict сообщает, обязательны ли подписи метрик. Режим по умолчанию строгий.
+// Подписи требуются, если задан общий ключ или реестр ключей агентов.
+func (p Policy) Strict() bool {
+	return (p.Key != "" || p.Agents) && p.Mode != ModePermissive
+}
+
+// RequireRequest сообщает, отклоняются ли запросы записи без подписи целиком (RequestSigner или AgentSigner).
+// perMetric означает, что формат запроса позволяет подписать отдельные метрики. Такие подписи
+// не содержат времени и nonce, поэтому перехваченный запрос можно повторить; в строгом режиме
+// с общим ключом подпись запроса обязательна и для этих форматов.
+func (p Policy) RequireRequest(perMetric bool) bool {
+	if p.Requests {
+		return true
+	}
+	if !p.Strict() {
+		return false
+	}
+	return !perMetric || p.Key != ""
+}
+
+// Check проверяет подпись метрики. Метрика без значения (запрос чтения) подписи не требует,
+// как и метрика из запроса, подписанного целиком (requestSigned).
+// Отклонения учитываются в Rejections.
+func (p Policy) Check(m *model.Metrics, requestSigned bool) error {
+	if !SupportedHashVersion(m.HashVersion) {
+		Rejections.Add(RejectInvalid, 1)
+		return fmt.Errorf("%w: %d", ErrHashVersion, m.HashVersion)
+	}
+	if m.Hash == "" || p.Key == "" {
+		if p.Strict() && !requestSigned && (m.Delta != nil || m.Value != nil) {
+			Rejections.Add(RejectMissing, 1)
+			return ErrSignatureMissing
+		}
+		return nil
+	}
+	hashObject := NewHashObject(p.Key)
+	passed, err := hashObject.Verify(m)
+	if err != nil {
+		Rejections.Add(RejectInvalid, 1)
+		return fmt.Errorf("%w: malformed hash", ErrSignatureMismatch)
+	}
+	if !passed {
+		Rejections.Add(RejectMismatch, 1)
+		return fmt.Errorf("%w: hash mismatch", ErrSignatureMismatch)
+	}
+	return nil
+}
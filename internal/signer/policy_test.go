@@ -0,0 +1,157 @@
+package signer
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rejections(reason string) int64 {
+	v, ok := Rejections.Get(reason).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    Mode
+		wantErr bool
+	}{
+		{name: "empty is strict", in: "", want: ModeStrict},
+		{name: "strict", in: "strict", want: ModeStrict},
+		{name: "permissive", in: "permissive", want: ModePermissive},
+		{name: "unknown", in: "lax", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMode(tt.in)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrMode)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	const key = "secret"
+	signed := func() *model.Metrics {
+		m := &model.Metrics{ID: "Alloc", MType: "gauge", Value: ptr(1.5), HashVersion: HashV2}
+		hashObject := NewHashObject(key)
+		require.NoError(t, hashObject.Sign(m))
+		return m
+	}
+	tests := []struct {
+		name          string
+		policy        Policy
+		metric        func() *model.Metrics
+		requestSigned bool
+		wantErr       error
+		reason        string
+	}{
+		{
+			name:   "no key accepts unsigned",
+			policy: Policy{},
+			metric: func() *model.Metrics { return &model.Metrics{ID: "A", MType: "gauge", Value: ptr(1.0)} },
+		},
+		{
+			name:    "strict rejects unsigned",
+			policy:  Policy{Key: key},
+			metric:  func() *model.Metrics { return &model.Metrics{ID: "A", MType: "gauge", Value: ptr(1.0)} },
+			wantErr: ErrSignatureMissing,
+			reason:  RejectMissing,
+		},
+		{
+			name:          "strict accepts unsigned metric of signed request",
+			policy:        Policy{Key: key},
+			metric:        func() *model.Metrics { return &model.Metrics{ID: "A", MType: "gauge", Value: ptr(1.0)} },
+			requestSigned: true,
+		},
+		{
+			name:   "strict accepts read query without value",
+			policy: Policy{Key: key},
+			metric: func() *model.Metrics { return &model.Metrics{ID: "A", MType: "gauge"} },
+		},
+		{
+			name:   "permissive accepts unsigned",
+			policy: Policy{Key: key, Mode: ModePermissive},
+			metric: func() *model.Metrics { return &model.Metrics{ID: "A", MType: "gauge", Value: ptr(1.0)} },
+		},
+		{
+			name:   "strict accepts valid signature",
+			policy: Policy{Key: key},
+			metric: signed,
+		},
+		{
+			name:   "permissive rejects wrong signature",
+			policy: Policy{Key: key, Mode: ModePermissive},
+			metric: func() *model.Metrics {
+				m := signed()
+				m.Value = ptr(2.5)
+				return m
+			},
+			wantErr: ErrSignatureMismatch,
+			reason:  RejectMismatch,
+		},
+		{
+			name:   "unsupported hash version",
+			policy: Policy{},
+			metric: func() *model.Metrics {
+				return &model.Metrics{ID: "A", MType: "gauge", Value: ptr(1.0), HashVersion: 7}
+			},
+			wantErr: ErrHashVersion,
+			reason:  RejectInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.metric()
+			var before int64
+			if tt.reason != "" {
+				before = rejections(tt.reason)
+			}
+			err := tt.policy.Check(m, tt.requestSigned)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, before+1, rejections(tt.reason))
+		})
+	}
+}
+
+func TestPolicy_RequireRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        Policy
+		wantPerMetric bool
+		wantRequest   bool
+	}{
+		{name: "no key", policy: Policy{}},
+		{name: "strict with key", policy: Policy{Key: "secret"}, wantPerMetric: true, wantRequest: true},
+		{name: "permissive with key", policy: Policy{Key: "secret", Mode: ModePermissive}},
+		{name: "strict with agents only", policy: Policy{Agents: true}, wantRequest: true},
+		{
+			name:          "requests option in permissive mode",
+			policy:        Policy{Key: "secret", Mode: ModePermissive, Requests: true},
+			wantPerMetric: true,
+			wantRequest:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantPerMetric, tt.policy.RequireRequest(true))
+			assert.Equal(t, tt.wantRequest, tt.policy.RequireRequest(false))
+		})
+	}
+}
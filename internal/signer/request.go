@@ -0,0 +1,246 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Заголовки подписи запроса целиком.
+const (
+	RequestSignatureHeader string = "X-Request-Signature"
+	RequestTimestampHeader string = "X-Request-Timestamp"
+	RequestNonceHeader     string = "X-Request-Nonce"
+
+	// DefaultMaxSkew допустимое расхождение часов агента и сервера.
+	DefaultMaxSkew = 5 * time.Minute
+
+	requestSignatureVersion string = "v1"
+	nonceSize               int    = 16
+)
+
+var (
+	ErrRequestSignature = errors.New("request signature mismatch")
+	ErrStaleRequest     = errors.New("request timestamp is outside the allowed window")
+	ErrReplayedRequest  = errors.New("request nonce has already been used")
+)
+
+// requestMessage каноническая строка запроса:
+//
+//	v1\nMETHOD\nURI\nTIMESTAMP\nNONCE\nhex(SHA-256(тело))
+func requestMessage(method, uri, timestamp, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s",
+		requestSignatureVersion, method, uri, timestamp, nonce, hex.EncodeToString(sum[:])))
+}
+
+// requestHash HMAC-SHA256 канонической строки запроса.
+func requestHash(key []byte, method, uri, timestamp, nonce string, body []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(requestMessage(method, uri, timestamp, nonce, body))
+	return h.Sum(nil)
+}
+
+// newNonce генерирует одноразовое значение запроса.
+func newNonce() (string, error) {
+	raw := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// checkTimestamp проверяет, что время подписи запроса отличается от now не больше чем на maxSkew.
+func checkTimestamp(timestamp string, now time.Time, maxSkew time.Duration) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: malformed timestamp", ErrStaleRequest)
+	}
+	if skew := now.Sub(time.Unix(sec, 0)); skew > maxSkew || skew < -maxSkew {
+		return fmt.Errorf("%w: skew %v", ErrStaleRequest, skew.Truncate(time.Second))
+	}
+	return nil
+}
+
+// HasRequestSignature сообщает, подписан ли запрос целиком.
+func HasRequestSignature(h http.Header) bool {
+	return h.Get(RequestSignatureHeader) != ""
+}
+
+// RequestSigner подписывает HTTP-запросы: тело, метод, путь, время и одноразовое значение (nonce).
+type RequestSigner struct {
+	key []byte
+	now func() time.Time
+}
+
+// NewRequestSigner создает подписчика запросов с ключом key.
+func NewRequestSigner(key string) *RequestSigner {
+	return &RequestSigner{key: []byte(key), now: time.Now}
+}
+
+// Sign устанавливает заголовки подписи запроса с телом body. Каждый вызов использует новый nonce.
+func (s *RequestSigner) Sign(req *http.Request, body []byte) error {
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	sig := requestHash(s.key, req.Method, req.URL.RequestURI(), timestamp, nonce, body)
+	req.Header.Set(RequestTimestampHeader, timestamp)
+	req.Header.Set(RequestNonceHeader, nonce)
+	req.Header.Set(RequestSignatureHeader, hex.EncodeToString(sig))
+	return nil
+}
+
+// Transport возвращает http.RoundTripper, подписывающий каждую попытку отправки запроса.
+// Повторная попытка получает новый nonce и не отклоняется сервером как повтор.
+func (s *RequestSigner) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &signingTransport{signer: s, base: base}
+}
+
+// bodySigner подписывает запрос с телом body, устанавливая заголовки подписи.
+type bodySigner interface {
+	Sign(req *http.Request, body []byte) error
+}
+
+type signingTransport struct {
+	signer bodySigner
+	base   http.RoundTripper
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if cerr := req.Body.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read request body: %w", err)
+		}
+	}
+	signed := req.Clone(req.Context())
+	signed.Body = io.NopCloser(bytes.NewReader(body))
+	signed.ContentLength = int64(len(body))
+	if err := t.signer.Sign(signed, body); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(signed)
+}
+
+// RequestVerifier проверяет подпись запроса, окно расхождения часов и однократность nonce.
+type RequestVerifier struct {
+	key     []byte
+	maxSkew time.Duration
+	nonces  *NonceCache
+	now     func() time.Time
+}
+
+// NewRequestVerifier создает проверяющего с ключом key и допустимым расхождением часов maxSkew.
+func NewRequestVerifier(key string, maxSkew time.Duration) *RequestVerifier {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	return &RequestVerifier{
+		key:     []byte(key),
+		maxSkew: maxSkew,
+		// nonce хранится, пока запрос с ним может пройти проверку времени
+		nonces: NewNonceCache(2 * maxSkew),
+		now:    time.Now,
+	}
+}
+
+// Verify проверяет подписанный запрос. Возвращает ErrStaleRequest, ErrRequestSignature
+// или ErrReplayedRequest. Nonce запоминается только после успешной проверки подписи,
+// поэтому поддельные запросы не заполняют кэш. Отклонения учитываются в Rejections.
+func (v *RequestVerifier) Verify(method, uri string, h http.Header, body []byte) error {
+	err := v.verify(method, uri, h, body)
+	countRejection(err)
+	return err
+}
+
+// countRejection учитывает в Rejections отклонение подписи запроса с ошибкой err.
+func countRejection(err error) {
+	switch {
+	case errors.Is(err, ErrStaleRequest):
+		Rejections.Add(RejectStale, 1)
+	case errors.Is(err, ErrUnknownAgent):
+		Rejections.Add(RejectUnknownAgent, 1)
+	case errors.Is(err, ErrReplayedRequest):
+		Rejections.Add(RejectReplay, 1)
+	case err != nil:
+		Rejections.Add(RejectMismatch, 1)
+	}
+}
+
+func (v *RequestVerifier) verify(method, uri string, h http.Header, body []byte) error {
+	timestamp := h.Get(RequestTimestampHeader)
+	nonce := h.Get(RequestNonceHeader)
+	if timestamp == "" || nonce == "" {
+		return fmt.Errorf("%w: timestamp and nonce are required", ErrRequestSignature)
+	}
+	now := v.now()
+	if err := checkTimestamp(timestamp, now, v.maxSkew); err != nil {
+		return err
+	}
+	got, err := hex.DecodeString(h.Get(RequestSignatureHeader))
+	if err != nil || !hmac.Equal(got, requestHash(v.key, method, uri, timestamp, nonce, body)) {
+		return ErrRequestSignature
+	}
+	if !v.nonces.Add(nonce, now) {
+		return ErrReplayedRequest
+	}
+	return nil
+}
+
+// NonceCache множество недавно использованных nonce. Записи хранятся ttl.
+type NonceCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// NewNonceCache создает кэш со временем хранения записей ttl.
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	return &NonceCache{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// Add запоминает nonce и возвращает false, если он уже встречался в течение ttl.
+func (c *NonceCache) Add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastPrune) > c.ttl/4 {
+		for n, expires := range c.seen {
+			if now.After(expires) {
+				delete(c.seen, n)
+			}
+		}
+		c.lastPrune = now
+	}
+	if expires, ok := c.seen[nonce]; ok && !now.After(expires) {
+		return false
+	}
+	c.seen[nonce] = now.Add(c.ttl)
+	return true
+}
+
+// Len количество хранимых nonce.
+func (c *NonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.seen)
+}
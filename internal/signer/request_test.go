@@ -0,0 +1,125 @@
+package signer
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestVerifier(t *testing.T) {
+	const key = "secret"
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	now := time.Unix(1700000000, 0)
+	s := NewRequestSigner(key)
+	s.now = func() time.Time { return now }
+	signed := func(t *testing.T) *http.Request {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/updates?x=1", bytes.NewReader(body))
+		require.NoError(t, s.Sign(req, body))
+		return req
+	}
+	verifier := func(skew time.Duration) *RequestVerifier {
+		v := NewRequestVerifier(key, time.Minute)
+		v.now = func() time.Time { return now.Add(skew) }
+		return v
+	}
+	otherKey := verifier(0)
+	otherKey.key = []byte("other")
+
+	tests := []struct {
+		name    string
+		verify  *RequestVerifier
+		mutate  func(r *http.Request) (string, []byte)
+		wantErr error
+	}{
+		{"valid", verifier(30 * time.Second), func(r *http.Request) (string, []byte) { return r.URL.RequestURI(), body }, nil},
+		{"clock behind", verifier(-30 * time.Second), func(r *http.Request) (string, []byte) { return r.URL.RequestURI(), body }, nil},
+		{"wrong key", otherKey, func(r *http.Request) (string, []byte) { return r.URL.RequestURI(), body }, ErrRequestSignature},
+		{"body tampered", verifier(0), func(r *http.Request) (string, []byte) {
+			return r.URL.RequestURI(), append([]byte(nil), body[1:]...)
+		}, ErrRequestSignature},
+		{"path tampered", verifier(0), func(r *http.Request) (string, []byte) { return "/update", body }, ErrRequestSignature},
+		{"nonce tampered", verifier(0), func(r *http.Request) (string, []byte) {
+			r.Header.Set(RequestNonceHeader, "00")
+			return r.URL.RequestURI(), body
+		}, ErrRequestSignature},
+		{"timestamp moved", verifier(0), func(r *http.Request) (string, []byte) {
+			r.Header.Set(RequestTimestampHeader, strconv.FormatInt(now.Unix()+1, 10))
+			return r.URL.RequestURI(), body
+		}, ErrRequestSignature},
+		{"too old", verifier(2 * time.Minute), func(r *http.Request) (string, []byte) { return r.URL.RequestURI(), body }, ErrStaleRequest},
+		{"from future", verifier(-2 * time.Minute), func(r *http.Request) (string, []byte) { return r.URL.RequestURI(), body }, ErrStaleRequest},
+		{"bad timestamp", verifier(0), func(r *http.Request) (string, []byte) {
+			r.Header.Set(RequestTimestampHeader, "yesterday")
+			return r.URL.RequestURI(), body
+		}, ErrStaleRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signed(t)
+			uri, b := tt.mutate(req)
+			err := tt.verify.Verify(req.Method, uri, req.Header, b)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		v := verifier(0)
+		req := signed(t)
+		require.NoError(t, v.Verify(req.Method, req.URL.RequestURI(), req.Header, body))
+		assert.ErrorIs(t, v.Verify(req.Method, req.URL.RequestURI(), req.Header, body), ErrReplayedRequest)
+		// поддельный запрос с тем же nonce не должен занимать место в кэше
+		assert.Equal(t, 1, v.nonces.Len())
+	})
+}
+
+func TestNonceCache(t *testing.T) {
+	c := NewNonceCache(time.Minute)
+	now := time.Unix(1700000000, 0)
+	assert.True(t, c.Add("a", now))
+	assert.False(t, c.Add("a", now.Add(30*time.Second)))
+	assert.True(t, c.Add("b", now.Add(40*time.Second)))
+	// запись "a" истекла и удаляется при очистке
+	assert.True(t, c.Add("c", now.Add(61*time.Second)))
+	assert.Equal(t, 2, c.Len())
+	assert.True(t, c.Add("a", now.Add(62*time.Second)))
+}
+
+func TestRequestSigner_Transport(t *testing.T) {
+	const key = "secret"
+	v := NewRequestVerifier(key, time.Minute)
+	nonces := map[string]bool{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(body))
+		nonces[r.Header.Get(RequestNonceHeader)] = true
+		if err = v.Verify(r.Method, r.URL.RequestURI(), r.Header, body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: NewRequestSigner(key).Transport(nil)}
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates", bytes.NewReader([]byte("payload")))
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, req.Header.Get(RequestSignatureHeader), "original request must not be modified")
+	}
+	assert.Len(t, nonces, 2)
+}
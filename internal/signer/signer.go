@@ -9,6 +9,9 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -26,9 +29,19 @@ func NewHashObject(key string) HashObject {
 	return h
 }
 
-// Sign формирование подписи для метрики.
+// Версии формата подписи метрики, задаются полем model.Metrics.HashVersion.
+const (
+	// HashV1 исходный формат "id:type:value", значение gauge округляется форматом %f.
+	HashV1 int = 1
+	// HashV2 канонический формат без потерь, см. canonicalV2.
+	HashV2 int = 2
+)
+
+var ErrHashVersion = errors.New("unsupported hash version")
+
+// Sign формирование подписи для метрики в формате, заданном m.HashVersion.
 func (s *HashObject) Sign(m *model.Metrics) error {
-	src, err := srcString(m)
+	src, err := source(m)
 	if err != nil {
 		return err
 	}
@@ -42,7 +55,7 @@ func (s *HashObject) Sign(m *model.Metrics) error {
 
 // Verify проверка целостности пришедших данных.
 func (s *HashObject) Verify(m *model.Metrics) (bool, error) {
-	src, err := srcString(m)
+	src, err := source(m)
 	if err != nil {
 		return false, err
 	}
@@ -50,7 +63,24 @@ func (s *HashObject) Verify(m *model.Metrics) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	return m.Hash == h, nil
+	return hmac.Equal([]byte(m.Hash), []byte(h)), nil
+}
+
+// SupportedHashVersion сообщает, известен ли формат подписи v. 0 означает HashV1.
+func SupportedHashVersion(v int) bool {
+	return v >= 0 && v <= HashV2
+}
+
+// source строка для подписи метрики в формате m.HashVersion.
+func source(m *model.Metrics) (string, error) {
+	switch m.HashVersion {
+	case 0, HashV1:
+		return srcString(m)
+	case HashV2:
+		return canonicalV2(m)
+	default:
+		return "", fmt.Errorf("%w: %d", ErrHashVersion, m.HashVersion)
+	}
 }
 
 // hash формирование  hash shá56 от указанной строки с ключом key.
@@ -85,6 +115,42 @@ func srcString(model *model.Metrics) (string, error) {
 	}
 }
 
+// canonicalV2 каноническое представление метрики для подписи версии 2: последовательность
+// netstring ("<длина>:<байты>,") из полей
+//
+//	"v2", id, type, delta, value
+//
+// delta — десятичное число, value — 16 шестнадцатеричных цифр битов IEEE 754 (big endian),
+// отсутствующее значение — пустая строка. Кодирование однозначно и не зависит от форматирования
+// чисел с плавающей точкой, поэтому его просто повторить в клиенте на любом языке.
+func canonicalV2(m *model.Metrics) (string, error) {
+	delta, value := "", ""
+	switch m.MType {
+	case "gauge":
+		if m.Value == nil {
+			return "", fmt.Errorf("gauge '%v' error trying to Sign metric, model.Value == nil", m.ID)
+		}
+	case "counter":
+		if m.Delta == nil {
+			return "", fmt.Errorf("counter '%v' error trying to Sign metric, model.Delta == nil", m.ID)
+		}
+	default:
+		return "", fmt.Errorf("unknown metric type exception '%v' trying to Sign metric", m.MType)
+	}
+	if m.Delta != nil {
+		delta = strconv.FormatInt(*m.Delta, 10)
+	}
+	if m.Value != nil {
+		value = fmt.Sprintf("%016x", math.Float64bits(*m.Value))
+	}
+	var b strings.Builder
+	for _, field := range []string{"v2", m.ID, m.MType, delta, value} {
+		fmt.Fprintf(&b, "%d:%s,", len(field), field)
+	}
+	return b.String(), nil
+}
+
+// DecryptMessage расшифровывает сообщение, зашифрованное поблочно RSA-OAEP (устаревший формат агента).
 func DecryptMessage(key *rsa.PrivateKey, msg []byte) ([]byte, error) {
 	size := key.PublicKey.Size()
 	if len(msg)%size != 0 {
@@ -195,3 +195,75 @@ func TestDecryptMessage(t *testing.T) {
 	assert.NoError(t, err, "DecryptMessage should not return an error")
 	assert.Equal(t, message, decrypted, "Decrypted message should match the original message")
 }
+
+func Test_canonicalV2(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       model.Metrics
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "gauge",
+			m:    model.Metrics{ID: "Alloc", MType: "gauge", Value: ptr(1.5)},
+			want: "2:v2,5:Alloc,5:gauge,0:,16:3ff8000000000000,",
+		},
+		{
+			name: "counter",
+			m:    model.Metrics{ID: "PollCount", MType: "counter", Delta: ptr(int64(-7))},
+			want: "2:v2,9:PollCount,7:counter,2:-7,0:,",
+		},
+		{
+			name: "separators in id",
+			m:    model.Metrics{ID: `cpu{core="1,2:"}`, MType: "counter", Delta: ptr(int64(1))},
+			want: `2:v2,16:cpu{core="1,2:"},7:counter,1:1,0:,`,
+		},
+		{name: "gauge without value", m: model.Metrics{ID: "a", MType: "gauge"}, wantErr: true},
+		{name: "unknown type", m: model.Metrics{ID: "a", MType: "histogram", Value: ptr(1.0)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := canonicalV2(&tt.m)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHashObject_versions(t *testing.T) {
+	h := NewHashObject("secret")
+	small := model.Metrics{ID: "tiny", MType: "gauge", Value: ptr(1e-7)}
+	smaller := model.Metrics{ID: "tiny", MType: "gauge", Value: ptr(2e-7)}
+
+	// v1 округляет значения до 6 знаков: подпись одного значения подходит к другому
+	assert.NoError(t, h.Sign(&small))
+	smaller.Hash = small.Hash
+	passed, err := h.Verify(&smaller)
+	assert.NoError(t, err)
+	assert.True(t, passed)
+
+	small.HashVersion, smaller.HashVersion = HashV2, HashV2
+	assert.NoError(t, h.Sign(&small))
+	smaller.Hash = small.Hash
+	passed, err = h.Verify(&smaller)
+	assert.NoError(t, err)
+	assert.False(t, passed)
+	passed, err = h.Verify(&small)
+	assert.NoError(t, err)
+	assert.True(t, passed)
+
+	// подпись v1 не подходит для v2 и наоборот
+	small.HashVersion = HashV1
+	passed, err = h.Verify(&small)
+	assert.NoError(t, err)
+	assert.False(t, passed)
+
+	small.HashVersion = 3
+	assert.ErrorIs(t, h.Sign(&small), ErrHashVersion)
+	_, err = h.Verify(&small)
+	assert.ErrorIs(t, err, ErrHashVersion)
+}
@@ -0,0 +1,329 @@
+// Package statsd реализует UDP-приемник метрик в формате StatsD.
+//
+// Поддерживаются строки вида
+//
+//	name:value|c[|@rate]
+//	name:value|g        (значение со знаком +/- изменяет текущее значение)
+//	name:value|ms[|@rate]
+//
+// Пакет может содержать несколько строк, разделенных переводом строки.
+// Значения агрегируются в течение окна FlushInterval и затем одним вызовом
+// Repository.AddMetrics передаются в хранилище.
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+)
+
+const (
+	counter string = "counter"
+	gauge   string = "gauge"
+
+	maxPacketSize int = 64 * 1024
+	queueSize     int = 1024
+
+	minRetryDelay = 5 * time.Millisecond
+	maxRetryDelay = time.Second
+
+	// Имена собственных метрик приемника.
+	PacketsReceived string = "statsd.packets_received"
+	PacketsDropped  string = "statsd.packets_dropped"
+	LinesMalformed  string = "statsd.lines_malformed"
+)
+
+var ErrMalformed = errors.New("malformed statsd line")
+
+// Listener принимает пакеты StatsD и периодически сбрасывает агрегаты в хранилище.
+type Listener struct {
+	repo          storage.Repository
+	conn          net.PacketConn
+	packets       chan []byte
+	agg           *aggregator
+	mux           sync.Mutex
+	flushInterval time.Duration
+}
+
+// NewListener открывает UDP-сокет на адресе addr.
+func NewListener(addr string, flushInterval time.Duration, repo storage.Repository) (*Listener, error) {
+	if flushInterval <= 0 {
+		return nil, fmt.Errorf("statsd flush interval must be positive, got %v", flushInterval)
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd listen on '%s' error: %w", addr, err)
+	}
+	return &Listener{
+		repo:          repo,
+		conn:          conn,
+		packets:       make(chan []byte, queueSize),
+		agg:           newAggregator(),
+		flushInterval: flushInterval,
+	}, nil
+}
+
+// Addr адрес, на котором приемник ожидает пакеты.
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Run принимает пакеты до отмены ctx. Перед возвратом накопленные значения сбрасываются в хранилище.
+func (l *Listener) Run(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.process(ctx)
+	}()
+	go func() {
+		<-ctx.Done()
+		if err := l.conn.Close(); err != nil {
+			log.Printf("statsd listener close error: %v", err)
+		}
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	var retryDelay time.Duration
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				break
+			}
+			// повторяющаяся ошибка чтения не должна загружать процессор пустым циклом
+			retryDelay = nextRetryDelay(retryDelay)
+			log.Printf("statsd read error: %v; retrying in %v", err, retryDelay)
+			select {
+			case <-time.After(retryDelay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		retryDelay = 0
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		select {
+		case l.packets <- packet:
+		default:
+			// очередь переполнена: агрегатор не успевает разбирать пакеты
+			l.mux.Lock()
+			l.agg.inc(PacketsDropped, 1)
+			l.mux.Unlock()
+		}
+	}
+	close(l.packets)
+	<-done
+}
+
+// nextRetryDelay удваивает паузу перед повторным чтением от minRetryDelay до maxRetryDelay.
+func nextRetryDelay(d time.Duration) time.Duration {
+	if d == 0 {
+		return minRetryDelay
+	}
+	if d *= 2; d > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return d
+}
+
+func (l *Listener) process(ctx context.Context) {
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case packet, ok := <-l.packets:
+			if !ok {
+				l.Flush()
+				return
+			}
+			l.mux.Lock()
+			l.agg.packet(packet)
+			l.mux.Unlock()
+		case <-ticker.C:
+			l.Flush()
+		case <-ctx.Done():
+			// дочитываем очередь до ее закрытия в Run
+			for packet := range l.packets {
+				l.mux.Lock()
+				l.agg.packet(packet)
+				l.mux.Unlock()
+			}
+			l.Flush()
+			return
+		}
+	}
+}
+
+// Flush передает накопленные за окно значения в хранилище.
+func (l *Listener) Flush() {
+	l.mux.Lock()
+	metrics := l.agg.flush(l.repo)
+	l.mux.Unlock()
+	if len(metrics) == 0 {
+		return
+	}
+	if err := l.repo.AddMetrics(metrics); err != nil {
+		log.Printf("statsd flush error: %v", err)
+	}
+}
+
+// timer агрегат значений таймера за окно.
+type timer struct {
+	count float64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+type aggregator struct {
+	counters map[string]float64
+	gauges   map[string]float64
+	deltas   map[string]float64 // относительные изменения gauge без абсолютного значения в окне
+	timers   map[string]*timer
+	// дробные остатки округления счетчиков, переносятся в следующее окно
+	remainders map[string]float64
+}
+
+func newAggregator() *aggregator {
+	a := &aggregator{remainders: map[string]float64{}}
+	a.reset()
+	return a
+}
+
+func (a *aggregator) reset() {
+	a.counters = map[string]float64{}
+	a.gauges = map[string]float64{}
+	a.deltas = map[string]float64{}
+	a.timers = map[string]*timer{}
+}
+
+func (a *aggregator) inc(name string, v float64) {
+	a.counters[name] += v
+}
+
+func (a *aggregator) packet(packet []byte) {
+	a.inc(PacketsReceived, 1)
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := a.line(line); err != nil {
+			a.inc(LinesMalformed, 1)
+			log.Debugf("statsd: %v", err)
+		}
+	}
+}
+
+func (a *aggregator) line(line string) error {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return fmt.Errorf("%w: '%s'", ErrMalformed, line)
+	}
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("%w: '%s'", ErrMalformed, line)
+	}
+	valStr, mtype := parts[0], parts[1]
+	value, err := strconv.ParseFloat(valStr, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("%w: bad value in '%s'", ErrMalformed, line)
+	}
+	rate := 1.0
+	if len(parts) == 3 {
+		rateStr, ok := strings.CutPrefix(parts[2], "@")
+		if !ok {
+			return fmt.Errorf("%w: bad sample rate in '%s'", ErrMalformed, line)
+		}
+		rate, err = strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return fmt.Errorf("%w: bad sample rate in '%s'", ErrMalformed, line)
+		}
+	}
+
+	switch mtype {
+	case "c":
+		a.counters[name] += value / rate
+	case "g":
+		if len(parts) == 3 {
+			return fmt.Errorf("%w: sample rate is not allowed for gauge '%s'", ErrMalformed, line)
+		}
+		if valStr[0] != '+' && valStr[0] != '-' {
+			a.gauges[name] = value
+			delete(a.deltas, name)
+			return nil
+		}
+		if _, ok := a.gauges[name]; ok {
+			a.gauges[name] += value
+		} else {
+			a.deltas[name] += value
+		}
+	case "ms":
+		t, ok := a.timers[name]
+		if !ok {
+			t = &timer{min: value, max: value}
+			a.timers[name] = t
+		}
+		t.count += 1 / rate
+		t.sum += value / rate
+		t.min = math.Min(t.min, value)
+		t.max = math.Max(t.max, value)
+	default:
+		return fmt.Errorf("%w: unknown type '%s'", ErrMalformed, mtype)
+	}
+	return nil
+}
+
+// flush формирует метрики из агрегатов и очищает окно. Значения таймера передаются как
+// gauge name.mean, name.min, name.max и counter name.count. Счетчики округляются до целого,
+// остаток округления добавляется к значению счетчика в следующем окне.
+func (a *aggregator) flush(repo storage.Repository) []*model.Metrics {
+	metrics := make([]*model.Metrics, 0, len(a.counters)+len(a.gauges)+len(a.deltas)+4*len(a.timers))
+	addCounter := func(name string, v float64) {
+		v += a.remainders[name]
+		rounded := math.Round(v)
+		if rest := v - rounded; rest != 0 {
+			a.remainders[name] = rest
+		} else {
+			delete(a.remainders, name)
+		}
+		d := int64(rounded)
+		metrics = append(metrics, &model.Metrics{ID: name, MType: counter, Delta: &d})
+	}
+	addGauge := func(name string, v float64) {
+		metrics = append(metrics, &model.Metrics{ID: name, MType: gauge, Value: &v})
+	}
+	for name, v := range a.counters {
+		addCounter(name, v)
+	}
+	for name, v := range a.gauges {
+		addGauge(name, v)
+	}
+	for name, v := range a.deltas {
+		// текущее значение неизвестно в окне: изменяем сохраненное, отсутствующее считаем нулем
+		current, err := repo.GetGauge(name)
+		if err != nil {
+			current = 0
+		}
+		addGauge(name, current+v)
+	}
+	for name, t := range a.timers {
+		addGauge(name+".mean", t.sum/t.count)
+		addGauge(name+".min", t.min)
+		addGauge(name+".max", t.max)
+		addCounter(name+".count", t.count)
+	}
+	a.reset()
+	return metrics
+}
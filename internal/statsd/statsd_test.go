@@ -0,0 +1,158 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+func TestAggregator(t *testing.T) {
+	tests := []struct {
+		name         string
+		packets      []string
+		stored       map[string]float64
+		wantCounters map[string]int64
+		wantGauges   map[string]float64
+	}{
+		{
+			name:    "counters with sample rate",
+			packets: []string{"hits:1|c\nhits:2|c|@0.5", "hits:1|c"},
+			wantCounters: map[string]int64{
+				"hits": 6, PacketsReceived: 2,
+			},
+		},
+		{
+			name:       "gauges absolute and relative",
+			packets:    []string{"temp:10|g\ntemp:+5|g\ntemp:-2|g", "load:+1.5|g"},
+			stored:     map[string]float64{"load": 2},
+			wantGauges: map[string]float64{"temp": 13, "load": 3.5},
+			wantCounters: map[string]int64{
+				PacketsReceived: 2,
+			},
+		},
+		{
+			name:    "timers",
+			packets: []string{"req:10|ms\nreq:30|ms\nreq:20|ms|@0.5"},
+			wantGauges: map[string]float64{
+				"req.mean": 20, "req.min": 10, "req.max": 30,
+			},
+			wantCounters: map[string]int64{
+				"req.count": 4, PacketsReceived: 1,
+			},
+		},
+		{
+			name: "malformed lines",
+			packets: []string{
+				"novalue|c\nbad:x|c\nbad:1|h\nbad:1|c|0.5\nbad:1|c|@2\nbad:1|g|@0.5\nok:1|c",
+			},
+			wantCounters: map[string]int64{
+				"ok": 1, PacketsReceived: 1, LinesMalformed: 6,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+			for name, v := range tt.stored {
+				_, err := repo.AddGauge(name, &v)
+				require.NoError(t, err)
+			}
+			a := newAggregator()
+			for _, p := range tt.packets {
+				a.packet([]byte(p))
+			}
+			require.NoError(t, repo.AddMetrics(a.flush(repo)))
+
+			for name, want := range tt.wantCounters {
+				got, err := repo.GetCounter(name)
+				assert.NoError(t, err)
+				assert.Equal(t, want, got, name)
+			}
+			for name, want := range tt.wantGauges {
+				got, err := repo.GetGauge(name)
+				assert.NoError(t, err)
+				assert.InDelta(t, want, got, 1e-9, name)
+			}
+			assert.Empty(t, a.flush(repo), "window must be reset after flush")
+		})
+	}
+}
+
+func TestAggregator_sampledCounterRemainder(t *testing.T) {
+	repo := storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	a := newAggregator()
+	// каждое окно дает 1/0.3 = 3.33..., без переноса остатка сумма была бы 9
+	for i := 0; i < 3; i++ {
+		a.packet([]byte("hits:1|c|@0.3"))
+		require.NoError(t, repo.AddMetrics(a.flush(repo)))
+	}
+	got, err := repo.GetCounter("hits")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), got)
+}
+
+func TestListener(t *testing.T) {
+	repo := storage.NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	l, err := NewListener("127.0.0.1:0", time.Hour, repo)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Run(ctx)
+	}()
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	for _, p := range []string{"jobs:3|c", "queue:7|g", "garbage"} {
+		_, err = conn.Write([]byte(p))
+		require.NoError(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		l.mux.Lock()
+		defer l.mux.Unlock()
+		return l.agg.counters[PacketsReceived] == 3
+	}, time.Second, 10*time.Millisecond)
+
+	// остановка приемника сбрасывает накопленное окно в хранилище
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("listener did not stop")
+	}
+	jobs, err := repo.GetCounter("jobs")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), jobs)
+	queue, err := repo.GetGauge("queue")
+	assert.NoError(t, err)
+	assert.Equal(t, 7.0, queue)
+	malformed, err := repo.GetCounter(LinesMalformed)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), malformed)
+}
+
+func TestNewListener_badInterval(t *testing.T) {
+	_, err := NewListener("127.0.0.1:0", 0, nil)
+	assert.Error(t, err)
+}
+
+func TestNextRetryDelay(t *testing.T) {
+	d := nextRetryDelay(0)
+	assert.Equal(t, minRetryDelay, d)
+	assert.Equal(t, 2*minRetryDelay, nextRetryDelay(d))
+	for i := 0; i < 20; i++ {
+		d = nextRetryDelay(d)
+	}
+	assert.Equal(t, maxRetryDelay, d)
+}
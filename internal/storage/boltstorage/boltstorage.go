@@ -0,0 +1,253 @@
+// Package boltstorage реализует хранение метрик во встроенной транзакционной key/value базе bbolt.
+// Каждая метрика хранится отдельной записью, поэтому при сохранении перезаписываются только изменившиеся значения.
+package boltstorage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+const valueSize = 8
+
+var (
+	gaugesBucket   = []byte("gauges")
+	countersBucket = []byte("counters")
+	openTimeout    = time.Second * 5
+)
+
+// BoltStorage хранилище метрик в файле bbolt.
+type BoltStorage struct {
+	db       *bolt.DB
+	gauges   map[string]float64 // значения, уже записанные в файл
+	counters map[string]int64
+	mux      sync.Mutex
+	Sync     bool
+}
+
+var _ storage.SecondaryStorage = new(BoltStorage)
+
+// NewStorage открывает (или создает) файл базы и подготавливает бакеты для метрик.
+func NewStorage(path string, sync bool) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		log.Printf("Unable to open bolt database '%s' error: %v", path, err)
+		return nil, fmt.Errorf("unable to open bolt database '%s': %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{gaugesBucket, countersBucket} {
+			if _, errb := tx.CreateBucketIfNotExists(name); errb != nil {
+				return fmt.Errorf("create bucket '%s' error: %w", name, errb)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if errc := db.Close(); errc != nil {
+			log.Printf("Error closing bolt database '%s': %v", path, errc)
+		}
+		return nil, fmt.Errorf("failed to init bolt database '%s': %w", path, err)
+	}
+	return &BoltStorage{
+		db:       db,
+		gauges:   map[string]float64{},
+		counters: map[string]int64{},
+		Sync:     sync,
+	}, nil
+}
+
+// NewReadOnlyStorage открывает существующий файл базы только для чтения, не создавая бакетов.
+// Сохранение в такое хранилище завершается ошибкой.
+func NewReadOnlyStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err != nil {
+		log.Printf("Unable to open bolt database '%s' error: %v", path, err)
+		return nil, fmt.Errorf("unable to open bolt database '%s': %w", path, err)
+	}
+	return &BoltStorage{
+		db:       db,
+		gauges:   map[string]float64{},
+		counters: map[string]int64{},
+	}, nil
+}
+
+func (b *BoltStorage) SyncMode() bool {
+	return b.Sync
+}
+
+// Ping проверяет, что файл базы открыт.
+func (b *BoltStorage) Ping(ctx context.Context) error {
+	if b == nil || b.db == nil {
+		return errors.New("cannot ping bolt database because it is not opened")
+	}
+	if err := b.db.View(func(tx *bolt.Tx) error { return nil }); err != nil {
+		return fmt.Errorf("bolt ping failed: %w", err)
+	}
+	return nil
+}
+
+func (b *BoltStorage) Close() {
+	if err := b.db.Close(); err != nil {
+		log.Printf("Error closing bolt database: %v", err)
+	}
+}
+
+// Save записывает в базу метрики, значения которых изменились с момента последнего сохранения.
+func (b *BoltStorage) Save(ctx context.Context, ms *memstorage.MemStorage) error {
+	_, err := b.save(ctx, ms)
+	return err
+}
+
+func (b *BoltStorage) save(ctx context.Context, ms *memstorage.MemStorage) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	gauges := map[string]float64{}
+	counters := map[string]int64{}
+	ms.Mux.RLock()
+	for name, val := range ms.Gauges {
+		if old, ok := b.gauges[name]; !ok || math.Float64bits(old) != math.Float64bits(val) {
+			gauges[name] = val
+		}
+	}
+	for name, val := range ms.Counters {
+		if old, ok := b.counters[name]; !ok || old != val {
+			counters[name] = val
+		}
+	}
+	ms.Mux.RUnlock()
+
+	if len(gauges) == 0 && len(counters) == 0 {
+		return 0, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("bolt save cancelled: %w", err)
+	}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		gb := tx.Bucket(gaugesBucket)
+		for name, val := range gauges {
+			if err := gb.Put([]byte(name), encodeGauge(val)); err != nil {
+				return fmt.Errorf("error update gauge:[%v:%v] error: %w", name, val, err)
+			}
+		}
+		cb := tx.Bucket(countersBucket)
+		for name, val := range counters {
+			if err := cb.Put([]byte(name), encodeCounter(val)); err != nil {
+				return fmt.Errorf("error update counter:[%v:%v] error: %w", name, val, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error: [BoltStorage] save transaction failed: %v", err)
+		return 0, fmt.Errorf("[BoltStorage] save transaction failed: %w", err)
+	}
+
+	for name, val := range gauges {
+		b.gauges[name] = val
+	}
+	for name, val := range counters {
+		b.counters[name] = val
+	}
+	return len(gauges) + len(counters), nil
+}
+
+// Restore считывает все сохраненные метрики из базы.
+func (b *BoltStorage) Restore(ctx context.Context) (*memstorage.MemStorage, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	gauges := map[string]float64{}
+	counters := map[string]int64{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// в базе, открытой только для чтения, бакетов может не быть
+		gb, cb := tx.Bucket(gaugesBucket), tx.Bucket(countersBucket)
+		if gb == nil || cb == nil {
+			return nil
+		}
+		err := gb.ForEach(func(k, v []byte) error {
+			val, err := decodeGauge(v)
+			if err != nil {
+				return fmt.Errorf("gauge '%s': %w", k, err)
+			}
+			gauges[string(k)] = val
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return cb.ForEach(func(k, v []byte) error {
+			val, err := decodeCounter(v)
+			if err != nil {
+				return fmt.Errorf("counter '%s': %w", k, err)
+			}
+			counters[string(k)] = val
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Error trying to restore metrics from bolt database: %v", err)
+		return nil, fmt.Errorf("error trying to restore metrics from bolt database: %w", err)
+	}
+
+	b.gauges = make(map[string]float64, len(gauges))
+	for name, val := range gauges {
+		b.gauges[name] = val
+	}
+	b.counters = make(map[string]int64, len(counters))
+	for name, val := range counters {
+		b.counters[name] = val
+	}
+	return &memstorage.MemStorage{
+		Gauges:   gauges,
+		Counters: counters,
+		Mux:      &sync.RWMutex{},
+	}, nil
+}
+
+func (b *BoltStorage) SaveTicker(storeint time.Duration, ms *memstorage.MemStorage) {
+	ticker := time.NewTicker(storeint)
+	for range ticker.C {
+		errs := b.Save(context.Background(), ms)
+		if errs != nil {
+			log.Printf("BoltStorage SaveTicker error: %v", errs)
+		}
+	}
+}
+
+func encodeGauge(v float64) []byte {
+	buf := make([]byte, valueSize)
+	binary.BigEndian.PutUint64(buf, math.Float64bits(v))
+	return buf
+}
+
+func decodeGauge(buf []byte) (float64, error) {
+	if len(buf) != valueSize {
+		return 0, fmt.Errorf("unexpected value length %d", len(buf))
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(buf)), nil
+}
+
+func encodeCounter(v int64) []byte {
+	buf := make([]byte, valueSize)
+	binary.BigEndian.PutUint64(buf, uint64(v))
+	return buf
+}
+
+func decodeCounter(buf []byte) (int64, error) {
+	if len(buf) != valueSize {
+		return 0, fmt.Errorf("unexpected value length %d", len(buf))
+	}
+	return int64(binary.BigEndian.Uint64(buf)), nil
+}
@@ -0,0 +1,200 @@
+package boltstorage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/storagetest"
+)
+
+func newTestStorage(t *testing.T, sync bool) (*BoltStorage, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	bs, err := NewStorage(path, sync)
+	if err != nil {
+		t.Fatalf("NewStorage() error = %v", err)
+	}
+	return bs, path
+}
+
+func TestNewStorage(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "new file",
+			wantErr: false,
+		},
+		{
+			name:    "bad format file",
+			content: "Some uunstructured text",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics.db")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			bs, err := NewStorage(path, false)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewStorage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if bs != nil {
+				bs.Close()
+			}
+		})
+	}
+}
+
+func TestBoltStorage_Restore(t *testing.T) {
+	t.Run("empty database", func(t *testing.T) {
+		bs, _ := newTestStorage(t, false)
+		defer bs.Close()
+
+		got, err := bs.Restore(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, reflect.DeepEqual(got, &memstorage.MemStorage{
+			Gauges:   map[string]float64{},
+			Counters: map[string]int64{},
+			Mux:      &sync.RWMutex{},
+		}))
+	})
+
+	t.Run("corrupted value", func(t *testing.T) {
+		bs, _ := newTestStorage(t, false)
+		defer bs.Close()
+
+		err := bs.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(gaugesBucket).Put([]byte("metric1"), []byte("bad"))
+		})
+		assert.NoError(t, err)
+
+		got, err := bs.Restore(context.Background())
+		assert.Error(t, err)
+		assert.Nil(t, got)
+	})
+}
+
+func TestBoltStorage_SaveRestore(t *testing.T) {
+	bs, path := newTestStorage(t, false)
+
+	ms := memstorage.NewStorage()
+	ms.Gauges["metric1"] = 231.12
+	ms.Gauges["tiny"] = 1e-9
+	ms.Counters["metric2"] = 101
+
+	assert.NoError(t, bs.Save(context.Background(), ms))
+	bs.Close()
+
+	reopened, err := NewStorage(path, false)
+	if err != nil {
+		t.Fatalf("NewStorage() error = %v", err)
+	}
+	defer reopened.Close()
+
+	got, err := reopened.Restore(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ms.Gauges, got.Gauges)
+	assert.Equal(t, ms.Counters, got.Counters)
+}
+
+func TestBoltStorage_IncrementalSave(t *testing.T) {
+	bs, _ := newTestStorage(t, true)
+	defer bs.Close()
+
+	ms := memstorage.NewStorage()
+	ms.Gauges["metric1"] = 1
+	ms.Gauges["metric2"] = 2
+	ms.Counters["metric3"] = 3
+
+	written, err := bs.save(context.Background(), ms)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, written)
+
+	written, err = bs.save(context.Background(), ms)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, written)
+
+	ms.Gauges["metric2"] = 22
+	ms.Counters["metric4"] = 4
+	written, err = bs.save(context.Background(), ms)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, written)
+
+	got, err := bs.Restore(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ms.Gauges, got.Gauges)
+	assert.Equal(t, ms.Counters, got.Counters)
+}
+
+func TestBoltStorage_SyncMode(t *testing.T) {
+	bs, _ := newTestStorage(t, true)
+	defer bs.Close()
+
+	assert.Equal(t, bs.Sync, bs.SyncMode())
+	assert.NoError(t, bs.Ping(context.Background()))
+}
+
+func TestBoltStorage_SaveTicker(t *testing.T) {
+	bs, _ := newTestStorage(t, false)
+	defer bs.Close()
+
+	ms := memstorage.NewStorage()
+	ms.Gauges["metric1"] = 10.5
+	ms.Counters["metric2"] = 30
+
+	go bs.SaveTicker(100*time.Millisecond, ms)
+
+	// Wait for some time to allow the ticker to trigger
+	time.Sleep(500 * time.Millisecond)
+
+	got, err := bs.Restore(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ms.Gauges, got.Gauges)
+	assert.Equal(t, ms.Counters, got.Counters)
+}
+
+func TestBoltStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, sync bool) storagetest.Instance {
+		bs, path := newTestStorage(t, sync)
+		current := bs
+		t.Cleanup(func() { current.Close() })
+		return storagetest.Instance{
+			Storage: bs,
+			Reopen: func() (storage.SecondaryStorage, error) {
+				current.Close()
+				reopened, err := NewStorage(path, sync)
+				if err != nil {
+					return nil, err
+				}
+				current = reopened
+				return reopened, nil
+			},
+			Corrupt: func() {
+				err := bs.db.Update(func(tx *bolt.Tx) error {
+					return tx.Bucket(countersBucket).Put([]byte("c1"), []byte{1, 2, 3})
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		}
+	})
+}
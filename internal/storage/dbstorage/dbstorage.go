@@ -1,7 +1,13 @@
-// Package dbstorage реализует механизи взаимодействия с БД Postgresql.
+// Package dbstorage реализует механизи взаимодействия с БД Postgresql и SQLite.
 package dbstorage
 
-import "database/sql"
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrNoMetricsTable в базе, открытой только для чтения, еще нет таблицы метрик.
+var ErrNoMetricsTable = errors.New("metrics table does not exist")
 
 const (
 	restoreDBscript string = `CREATE TABLE IF NOT EXISTS metrics ( 
@@ -31,6 +37,23 @@ CREATE OR REPLACE FUNCTION get()
 $$ LANGUAGE SQL STABLE;`
 	SetMetricQuery  string = "SELECT save(@name,@type,@value,@delta)"
 	GetMetricsQuery string = "SELECT * FROM get()"
+
+	// SQLite не поддерживает хранимые функции, поэтому upsert выполняется напрямую.
+	restoreSQLiteScript string = `CREATE TABLE IF NOT EXISTS metrics ( 
+            name text, 
+            type varchar(10), 
+            value double precision,
+            delta bigint,
+ UNIQUE (name,type)
+        );`
+	SetSQLiteMetricQuery string = `INSERT INTO metrics (name,type,value,delta)
+ VALUES (?,?,?,?)
+ ON CONFLICT(name,type) DO UPDATE
+ SET value = excluded.value, delta = excluded.delta`
+	GetSQLiteMetricsQuery string = "SELECT name,type,value,delta FROM metrics"
+
+	pgTableExistsQuery     string = "SELECT to_regclass('metrics') IS NOT NULL"
+	sqliteTableExistsQuery string = "SELECT count(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'metrics'"
 )
 
 type dbMetric struct {
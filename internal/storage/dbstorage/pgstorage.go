@@ -59,6 +59,32 @@ func NewStorage(ctx context.Context, connectionString string, sync bool) (*Postg
 	return pgs, nil
 }
 
+// NewReadOnlyStorage открывает базу только для чтения: схема не создается, а все транзакции
+// выполняются в режиме read only. Если таблицы метрик нет, возвращает ErrNoMetricsTable.
+func NewReadOnlyStorage(ctx context.Context, connectionString string) (*PostgreSQLStorage, error) {
+	cfg, err := pgxpool.ParseConfig(connectionString)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse connection string because %w", err)
+	}
+	cfg.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	db, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		log.Printf("Unable to open connection to database connection:'%v'  error %s", connectionString, err)
+		return nil, fmt.Errorf("unable to connect to database because %w", err)
+	}
+	pgs := &PostgreSQLStorage{connection: db}
+	var exists bool
+	if err = db.QueryRow(ctx, pgTableExistsQuery).Scan(&exists); err != nil {
+		pgs.Close()
+		return nil, fmt.Errorf("check metrics table error: %w", err)
+	}
+	if !exists {
+		pgs.Close()
+		return nil, ErrNoMetricsTable
+	}
+	return pgs, nil
+}
+
 func (pgs *PostgreSQLStorage) Close() {
 	pgs.connection.Close()
 }
@@ -90,6 +116,8 @@ func (pgs *PostgreSQLStorage) Save(ctx context.Context, ms *memstorage.MemStorag
 		}
 	}()
 
+	ms.Mux.RLock()
+	defer ms.Mux.RUnlock()
 	for metric, val := range ms.Gauges {
 		args := pgx.NamedArgs{
 			"name":  metric,
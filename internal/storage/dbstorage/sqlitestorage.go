@@ -0,0 +1,206 @@
+package dbstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite" // init db driver for SQLite (без CGO)
+
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+var (
+	_ storage.SecondaryStorage = new(SQLiteStorage)
+	_ SQLStorage               = new(SQLiteStorage)
+)
+
+// SQLiteStorage реализация хранилища метрик в локальном файле БД SQLite.
+type SQLiteStorage struct {
+	connection *sql.DB
+	Sync       bool
+}
+
+func (s *SQLiteStorage) SyncMode() bool {
+	return s.Sync
+}
+
+// NewSQLiteStorage открывает файл БД SQLite по пути path и создает таблицу метрик.
+func NewSQLiteStorage(ctx context.Context, path string, sync bool) (*SQLiteStorage, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		log.Printf("Unable to open SQLite database '%v' error %s", path, err)
+		return nil, fmt.Errorf("unable to open SQLite database because %w", err)
+	}
+	// SQLite допускает только одного писателя, поэтому все запросы идут через одно соединение.
+	db.SetMaxOpenConns(1)
+	s := &SQLiteStorage{connection: db, Sync: sync}
+	if err = s.restoreDB(ctx); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSQLiteReadOnlyStorage открывает существующий файл БД только для чтения, не создавая таблиц.
+// Если таблицы метрик нет, возвращает ErrNoMetricsTable.
+func NewSQLiteReadOnlyStorage(ctx context.Context, path string) (*SQLiteStorage, error) {
+	dsn := fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(5000)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		log.Printf("Unable to open SQLite database '%v' error %s", path, err)
+		return nil, fmt.Errorf("unable to open SQLite database because %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	s := &SQLiteStorage{connection: db}
+	var exists bool
+	if err = db.QueryRowContext(ctx, sqliteTableExistsQuery).Scan(&exists); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("check metrics table error: %w", err)
+	}
+	if !exists {
+		s.Close()
+		return nil, ErrNoMetricsTable
+	}
+	return s, nil
+}
+
+func (s *SQLiteStorage) Close() {
+	if err := s.connection.Close(); err != nil {
+		log.Printf("Error closing SQLite database: %v", err)
+	}
+}
+
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	if s == nil || s.connection == nil {
+		return fmt.Errorf("cannot ping database because connection is nil")
+	}
+	if err := s.connection.PingContext(ctx); err != nil {
+		log.Printf("failed to ping database because %s", err)
+		return fmt.Errorf("ping failed: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) Save(ctx context.Context, ms *memstorage.MemStorage) (err error) {
+	tx, err := s.connection.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error: [SQLiteStorage] failed connection transaction err: %v", err)
+		return fmt.Errorf("[SQLiteStorage] save func failed to BeginTx, error: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			errtx := tx.Rollback()
+			if errtx != nil {
+				log.Printf("Error: [SQLiteStorage] failed to Rollback transaction err: %v", errtx)
+			}
+		}
+	}()
+
+	stmt, err := tx.PrepareContext(ctx, SetSQLiteMetricQuery)
+	if err != nil {
+		log.Printf("Error prepare query '%s' error: %v", SetSQLiteMetricQuery, err)
+		return fmt.Errorf("error prepare query '%s' error: %w", SetSQLiteMetricQuery, err)
+	}
+	defer func() {
+		if errc := stmt.Close(); errc != nil {
+			log.Printf("Error: [SQLiteStorage] failed to close statement err: %v", errc)
+		}
+	}()
+
+	ms.Mux.RLock()
+	defer ms.Mux.RUnlock()
+	for metric, val := range ms.Gauges {
+		if _, errg := stmt.ExecContext(ctx, metric, "gauge", val, sql.NullInt64{}); errg != nil {
+			log.Printf("Error update gauge:[%v:%v] query '%s' error: %v", metric, val, SetSQLiteMetricQuery, errg)
+			return fmt.Errorf("error update gauge:[%v:%v] query '%s' error: %w", metric, val, SetSQLiteMetricQuery, errg)
+		}
+	}
+
+	for metric, val := range ms.Counters {
+		if _, errc := stmt.ExecContext(ctx, metric, "counter", sql.NullFloat64{}, val); errc != nil {
+			log.Printf("Error update counter:[%v:%v] query '%s' error: %v", metric, val, SetSQLiteMetricQuery, errc)
+			return fmt.Errorf("error update counter:[%v:%v] query '%s' error: %w", metric, val, SetSQLiteMetricQuery, errc)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Error failed to Commit transaction %v", err)
+		return fmt.Errorf("failed to Commit transaction %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) Restore(ctx context.Context) (*memstorage.MemStorage, error) {
+	ctx, cancel := context.WithTimeout(ctx, restoreTimeout)
+	defer cancel()
+	counters := make(map[string]int64)
+	gauges := make(map[string]float64)
+	rows, err := s.connection.QueryContext(ctx, GetSQLiteMetricsQuery)
+	if err != nil {
+		log.Printf("failed to get all metircs, query: '%s' error: %v", GetSQLiteMetricsQuery, err)
+		return nil, fmt.Errorf("error trying to get all metircs, query: '%s' error: %w", GetSQLiteMetricsQuery, err)
+	}
+	defer func() {
+		if errc := rows.Close(); errc != nil {
+			log.Printf("Error closing rows: %v", errc)
+		}
+	}()
+	for rows.Next() {
+		var m dbMetric
+		err = rows.Scan(&m.Name, &m.MType, &m.Value, &m.Delta)
+		if err != nil {
+			log.Printf("Error trying to Scan Rows error: %v", err)
+			return nil, fmt.Errorf("error trying to Scan Rows error: %w", err)
+		}
+		switch m.MType.String {
+		case "gauge":
+			gauges[m.Name.String] = m.Value.Float64
+		case "counter":
+			counters[m.Name.String] = m.Delta.Int64
+		default:
+			return nil, fmt.Errorf("error parsing metric type '%v'", m)
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		log.Printf("Error trying to get all metircs, query: '%s' error: %v", GetSQLiteMetricsQuery, err)
+		return nil, fmt.Errorf("failed to get all metircs, query: '%s' error: %w", GetSQLiteMetricsQuery, err)
+	}
+	return &memstorage.MemStorage{
+			Counters: counters,
+			Gauges:   gauges,
+			Mux:      &sync.RWMutex{},
+		},
+		nil
+}
+
+func (s *SQLiteStorage) SaveTicker(storeint time.Duration, ms *memstorage.MemStorage) {
+	ticker := time.NewTicker(storeint)
+	for range ticker.C {
+		errs := s.Save(context.Background(), ms)
+		if errs != nil {
+			log.Printf("SQLiteStorage SaveTicker error: %v", errs)
+		}
+	}
+}
+
+func (s *SQLiteStorage) restoreDB(ctx context.Context) error {
+	if err := s.Ping(ctx); err != nil {
+		log.Printf("Cannot ping database because %s", err)
+		return fmt.Errorf("cannot ping database because %w", err)
+	}
+
+	if _, err := s.connection.ExecContext(ctx, restoreSQLiteScript); err != nil {
+		log.Printf("Fail to invoke %s: %v", restoreSQLiteScript, err)
+		return fmt.Errorf("fail to invoke %s: %w", restoreSQLiteScript, err)
+	}
+	return nil
+}
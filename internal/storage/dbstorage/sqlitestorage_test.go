@@ -0,0 +1,136 @@
+package dbstorage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/storagetest"
+)
+
+func newTestSQLiteStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+	s, err := NewSQLiteStorage(context.Background(), filepath.Join(t.TempDir(), "metrics.sqlite"), false)
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage() error = %v", err)
+	}
+	return s
+}
+
+func TestNewSQLiteStorage(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{
+			name:    "positive test",
+			path:    filepath.Join(t.TempDir(), "metrics.sqlite"),
+			wantErr: false,
+		},
+		{
+			name:    "negative test: directory does not exist",
+			path:    filepath.Join(t.TempDir(), "not", "exist", "metrics.sqlite"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSQLiteStorage(context.Background(), tt.path, false)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewSQLiteStorage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if s != nil {
+				s.Close()
+			}
+		})
+	}
+}
+
+func TestSQLiteStorage_Ping(t *testing.T) {
+	s := newTestSQLiteStorage(t)
+	assert.NoError(t, s.Ping(context.Background()))
+
+	s.Close()
+	assert.Error(t, s.Ping(context.Background()))
+}
+
+func TestSQLiteStorage_SaveRestore(t *testing.T) {
+	s := newTestSQLiteStorage(t)
+	defer s.Close()
+
+	ms := memstorage.NewStorage()
+	ms.Gauges["metric1"] = 231.12
+	ms.Counters["metric2"] = 101
+	assert.NoError(t, s.Save(context.Background(), ms))
+
+	// повторное сохранение обновляет существующие строки
+	ms.Gauges["metric1"] = 0.5
+	ms.Counters["metric2"] = 202
+	assert.NoError(t, s.Save(context.Background(), ms))
+
+	got, err := s.Restore(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ms.Gauges, got.Gauges)
+	assert.Equal(t, ms.Counters, got.Counters)
+}
+
+func TestSQLiteStorage_SyncMode(t *testing.T) {
+	s := newTestSQLiteStorage(t)
+	defer s.Close()
+
+	assert.Equal(t, s.Sync, s.SyncMode())
+}
+
+func TestSQLiteStorage_SaveTicker(t *testing.T) {
+	s := newTestSQLiteStorage(t)
+	defer s.Close()
+
+	ms := memstorage.NewStorage()
+	ms.Gauges["metric1"] = 10.5
+
+	go s.SaveTicker(100*time.Millisecond, ms)
+
+	// Wait for some time to allow the ticker to trigger
+	time.Sleep(500 * time.Millisecond)
+
+	got, err := s.Restore(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ms.Gauges, got.Gauges)
+}
+
+func TestSQLiteStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, sync bool) storagetest.Instance {
+		path := filepath.Join(t.TempDir(), "metrics.sqlite")
+		s, err := NewSQLiteStorage(context.Background(), path, sync)
+		if err != nil {
+			t.Fatalf("NewSQLiteStorage() error = %v", err)
+		}
+		current := s
+		t.Cleanup(func() { current.Close() })
+		return storagetest.Instance{
+			Storage: s,
+			Reopen: func() (storage.SecondaryStorage, error) {
+				current.Close()
+				reopened, err := NewSQLiteStorage(context.Background(), path, sync)
+				if err != nil {
+					return nil, err
+				}
+				current = reopened
+				return reopened, nil
+			},
+			Corrupt: func() {
+				_, err := s.connection.Exec(SetSQLiteMetricQuery, "m1", "unknown", 1, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		}
+	})
+}
@@ -13,6 +13,10 @@ Package storage состоит из некольких пакетов, кото
 
 	PostgreSQLStorage реализация методов харнилища мтерик в БД PostgreSQl
 
+Файл sqlitestorage.go
+
+	SQLiteStorage реализация методов хранилища метрик в локальном файле SQLite (драйвер без CGO).
+
 # memstorage
 
 	GMetric - описывает метрику типа gauge.
@@ -31,8 +35,14 @@ Package storage состоит из некольких пакетов, кото
 
 	GetView - получение html-представления таблицы всех метрик.
 
+	GetMetrics - получение копии всех метрик в виде model.Metrics, отсортированных по типу и имени.
+
+	QueryMetrics - выборка метрик с фильтрами по типу, имени и времени обновления, сортировкой и курсором.
+
 	AddMetrics - добавление/обновление списка метрик в хранилище
 
+	Stream - брокер изменений метрик: каждое примененное изменение публикуется с порядковым номером.
+
 # filestorage
 
 Для восстановления данных о ранее собранных мтериках используется сохранение метрик в файл.
@@ -44,5 +54,15 @@ Package storage состоит из некольких пакетов, кото
 	Restore фосстановление занчений и перечня метрик последней сессии из файла.
 
 	SaveTicker синхронизация метрик в файл по таймеру
+
+# boltstorage
+
+Хранение метрик во встроенной транзакционной базе bbolt, не требующей отдельного сервера.
+
+	BoltStorage хранит каждую метрику отдельной записью в бакетах gauges и counters.
+
+	Save сохраняет только метрики, изменившиеся с момента последнего сохранения.
+
+	Restore восстановление всех метрик из базы.
 */
 package storage
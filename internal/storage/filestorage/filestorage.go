@@ -2,11 +2,13 @@
 package filestorage
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -20,14 +22,15 @@ import (
 type FileStorage struct {
 	StoreFile string
 	Sync      bool
+	mux       sync.Mutex // упорядочивает одновременные сохранения
 }
 
 var _ storage.SecondaryStorage = new(FileStorage)
 
 func NewStorage(ctx context.Context, c *config.Config) *FileStorage {
 	return &FileStorage{
-		c.StoreFile,
-		c.StoreInterval == 0,
+		StoreFile: c.StoreFile,
+		Sync:      c.StoreInterval == 0,
 	}
 }
 
@@ -35,18 +38,43 @@ func (f *FileStorage) SyncMode() bool {
 	return f.Sync
 }
 
+// Save записывает снимок хранилища во временный файл и атомарно заменяет им StoreFile,
+// поэтому прерванная запись не повреждает ранее сохраненные данные.
 func (f *FileStorage) Save(ctx context.Context, ms *memstorage.MemStorage) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
 	writer, err := newWriter(f.StoreFile)
 	if err != nil {
 		log.Printf("error FileStorage save metrics to file '%s' error: %v", f.StoreFile, err)
 		return fmt.Errorf("error FileStorage save metrics to file '%s' error:%w", f.StoreFile, err)
 	}
+	defer func() {
+		if errr := os.Remove(writer.file.Name()); errr != nil && !errors.Is(errr, os.ErrNotExist) {
+			log.Printf("error removing temporary file '%s': %v", writer.file.Name(), errr)
+		}
+	}()
 
+	ms.Mux.RLock()
 	err = writer.encoder.Encode(ms)
+	ms.Mux.RUnlock()
 	if err != nil {
 		log.Printf("writer.encoder.Encode from file: %v, error: %v", f.StoreFile, err)
+		if errc := writer.Close(); errc != nil {
+			log.Printf("error closing file '%s': %v", writer.file.Name(), errc)
+		}
 		return fmt.Errorf("error encode from file '%s' error: %w", f.StoreFile, err)
 	}
+	if err = writer.file.Sync(); err != nil {
+		log.Printf("error sync file '%s': %v", writer.file.Name(), err)
+	}
+	if err = writer.Close(); err != nil {
+		return fmt.Errorf("error FileStorage save metrics to file '%s' error:%w", f.StoreFile, err)
+	}
+	if err = os.Rename(writer.file.Name(), f.StoreFile); err != nil {
+		log.Printf("error FileStorage rename '%s' error: %v", writer.file.Name(), err)
+		return fmt.Errorf("error FileStorage save metrics to file '%s' error:%w", f.StoreFile, err)
+	}
 	return nil
 }
 
@@ -56,6 +84,11 @@ func (f *FileStorage) Restore(ctx context.Context) (*memstorage.MemStorage, erro
 		log.Printf("Restore metrics from file '%s' reader error: %v", f.StoreFile, err)
 		return nil, fmt.Errorf("error restore metrics from file '%s' reader error: %w", f.StoreFile, err)
 	}
+	defer func() {
+		if errc := reader.file.Close(); errc != nil {
+			log.Printf("error closing file '%s': %v", f.StoreFile, errc)
+		}
+	}()
 
 	checkFile, err := os.Stat(f.StoreFile)
 	if err != nil {
@@ -92,7 +125,7 @@ type producer struct {
 }
 
 func newWriter(filename string) (*producer, error) {
-	file, err := os.Create(filename)
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
 	if err != nil {
 		return nil, fmt.Errorf("newWriter error:%w", err)
 	}
@@ -113,7 +146,7 @@ func (p *producer) Close() error {
 
 type consumer struct {
 	file    *os.File
-	scanner *bufio.Scanner
+	decoder *json.Decoder
 }
 
 func newReader(filename string) (*consumer, error) {
@@ -124,21 +157,24 @@ func newReader(filename string) (*consumer, error) {
 
 	return &consumer{
 		file:    file,
-		scanner: bufio.NewScanner(file),
+		decoder: json.NewDecoder(file),
 	}, nil
 }
 
 func (r *consumer) readStorage() (*memstorage.MemStorage, error) {
-	if !r.scanner.Scan() {
-		return nil, fmt.Errorf("error r.scanner.Scan() :%w", r.scanner.Err())
-	}
-
-	data := r.scanner.Bytes()
-
 	ms := memstorage.MemStorage{}
-	err := json.Unmarshal(data, &ms)
+	err := r.decoder.Decode(&ms)
 	if err != nil {
-		return nil, fmt.Errorf("json.Unmarshal error:%w", err)
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("json decode error:%w", err)
+	}
+	if ms.Gauges == nil {
+		ms.Gauges = map[string]float64{}
+	}
+	if ms.Counters == nil {
+		ms.Counters = map[string]int64{}
 	}
 	ms.Mux = &sync.RWMutex{}
 	return &ms, nil
@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/rebus2015/praktikum-devops/internal/storage"
 	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/storagetest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -193,6 +196,7 @@ func TestSaveTicker(t *testing.T) {
 			"metric3": 30,
 			"metric4": 40,
 		},
+		Mux: &sync.RWMutex{},
 	}
 
 	// Use a shorter ticker duration for testing
@@ -219,3 +223,20 @@ func TestSaveTicker(t *testing.T) {
 	}()
 	assert.FileExists(t, storeFile)
 }
+
+func TestFileStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, sync bool) storagetest.Instance {
+		path := filepath.Join(t.TempDir(), "metrics.json")
+		return storagetest.Instance{
+			Storage: &FileStorage{StoreFile: path, Sync: sync},
+			Reopen: func() (storage.SecondaryStorage, error) {
+				return &FileStorage{StoreFile: path, Sync: sync}, nil
+			},
+			Corrupt: func() {
+				if err := os.WriteFile(path, []byte(`{"Gauges":{"g1":`), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+		}
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -71,13 +72,34 @@ type MemStorage struct {
 	Gauges   map[string]float64
 	Counters map[string]int64
 	Mux      *sync.RWMutex
+	// время последнего обновления метрик; не сохраняется во вторичное хранилище,
+	// у восстановленных метрик отсутствует до первого обновления
+	gaugesUpdated   map[string]time.Time
+	countersUpdated map[string]time.Time
 }
 
 func NewStorage() *MemStorage {
 	return &MemStorage{
-		map[string]float64{},
-		map[string]int64{},
-		&sync.RWMutex{},
+		Gauges:   map[string]float64{},
+		Counters: map[string]int64{},
+		Mux:      &sync.RWMutex{},
+	}
+}
+
+// touch запоминает время обновления метрики. Вызывается под блокировкой на запись.
+func (m *MemStorage) touch(mtype, name string) {
+	now := time.Now()
+	switch mtype {
+	case counter:
+		if m.countersUpdated == nil {
+			m.countersUpdated = map[string]time.Time{}
+		}
+		m.countersUpdated[name] = now
+	case gauge:
+		if m.gaugesUpdated == nil {
+			m.gaugesUpdated = map[string]time.Time{}
+		}
+		m.gaugesUpdated[name] = now
 	}
 }
 
@@ -103,6 +125,7 @@ func (m *MemStorage) SetGauge(name string, val interface{}) (float64, error) {
 	}
 
 	m.Gauges[g.Name] = g.Val
+	m.touch(gauge, g.Name)
 	return m.Gauges[g.Name], nil
 }
 
@@ -132,6 +155,7 @@ func (m *MemStorage) IncCounter(name string, val interface{}) (int64, error) {
 		m.Counters[c.Name] += c.Val
 		c.Val = m.Counters[c.Name]
 	}
+	m.touch(counter, c.Name)
 	return m.Counters[c.Name], nil
 }
 
@@ -160,59 +184,113 @@ func (m *MemStorage) GetGauge(name string) (float64, error) {
 	return m.Gauges[name], nil
 }
 
+// GetView возвращает метрики в строковом виде: сначала счетчики, затем gauge, в каждой группе по имени.
 func (m *MemStorage) GetView() ([]MetricStr, error) {
-	view := []MetricStr{}
-	keys := []string{}
-	for k := range m.Gauges {
+	m.Mux.RLock()
+	defer m.Mux.RUnlock()
+	view := make([]MetricStr, 0, len(m.Counters)+len(m.Gauges))
+	for _, key := range sortedKeys(m.Counters) {
+		view = append(view, MetricStr{key, fmt.Sprintf("%v", m.Counters[key])})
+	}
+	for _, key := range sortedKeys(m.Gauges) {
+		view = append(view, MetricStr{key, fmt.Sprintf("%f", m.Gauges[key])})
+	}
+
+	return view, nil
+}
+
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
+	return keys
+}
 
-	for key, val := range m.Counters {
-		view = append(view, MetricStr{key, fmt.Sprintf("%v", val)})
+// GetMetrics возвращает копию всех метрик, отсортированную по типу и имени.
+func (m *MemStorage) GetMetrics() []model.Metrics {
+	m.Mux.RLock()
+	defer m.Mux.RUnlock()
+	metrics := make([]model.Metrics, 0, len(m.Counters)+len(m.Gauges))
+	for name, val := range m.Counters {
+		metrics = append(metrics, model.Metrics{ID: name, MType: counter, Delta: ptr(val)})
 	}
-	for _, key := range keys {
-		view = append(view, MetricStr{key, fmt.Sprintf("%f", m.Gauges[key])})
+	for name, val := range m.Gauges {
+		metrics = append(metrics, model.Metrics{ID: name, MType: gauge, Value: ptr(val)})
 	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].MType != metrics[j].MType {
+			return metrics[i].MType < metrics[j].MType
+		}
+		return metrics[i].ID < metrics[j].ID
+	})
+	return metrics
+}
 
-	return view, nil
+// Values возвращает текущие значения перечисленных метрик. Отсутствующие метрики пропускаются.
+func (m *MemStorage) Values(metrics []*model.Metrics) []model.Metrics {
+	m.Mux.RLock()
+	defer m.Mux.RUnlock()
+	values := make([]model.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		switch metric.MType {
+		case counter:
+			if v, ok := m.Counters[metric.ID]; ok {
+				values = append(values, model.Metrics{ID: metric.ID, MType: counter, Delta: ptr(v)})
+			}
+		case gauge:
+			if v, ok := m.Gauges[metric.ID]; ok {
+				values = append(values, model.Metrics{ID: metric.ID, MType: gauge, Value: ptr(v)})
+			}
+		}
+	}
+	return values
 }
 
 func (m *MemStorage) AddMetrics(metrics []*model.Metrics) error {
+	_, err := m.ApplyMetrics(metrics)
+	return err
+}
+
+// ApplyMetrics применяет метрики по порядку до первой ошибки и возвращает число примененных:
+// при ошибке метрики metrics[:applied] уже изменены.
+func (m *MemStorage) ApplyMetrics(metrics []*model.Metrics) (applied int, err error) {
 	for _, metric := range metrics {
 		switch metric.MType {
 		case counter:
 			{
 				if metric.Delta == nil {
 					log.Printf("Error: [updateJSONMetricHandlerFunc] Counter not found status- 400")
-					return fmt.Errorf("%w", errors.New("error: [updateJSONMetricHandlerFunc] counter not found status- 400"))
+					return applied, fmt.Errorf("%w", errors.New("error: [updateJSONMetricHandlerFunc] counter not found status- 400"))
 				}
 
 				_, err := m.IncCounter(metric.ID, metric.Delta)
 				if err != nil {
 					log.Printf("Error: [updateJSONMetricHandlerFunc] Update counter error: %v", err)
-					return fmt.Errorf("%w", err)
+					return applied, fmt.Errorf("%w", err)
 				}
 			}
 		case gauge:
 			{
 				if metric.Value == nil {
 					log.Printf("Error: [updateJSONMetricHandlerFunc] gauge not found status- 400")
-					return fmt.Errorf("%w", errors.New("error: [updateJSONMetricHandlerFunc] gauge not found status- 400"))
+					return applied, fmt.Errorf("%w", errors.New("error: [updateJSONMetricHandlerFunc] gauge not found status- 400"))
 				}
 
 				_, err := m.SetGauge(metric.ID, metric.Value)
 				if err != nil {
 					log.Printf("Error: [updateJSONMetricHandlerFunc] Update gauge error: %v", err)
-					return fmt.Errorf("%w", err)
+					return applied, fmt.Errorf("%w", err)
 				}
 			}
 		default:
 			{
 				log.Printf("Error: [updateJSONMetricHandlerFunc] Unknown metric type status - 500")
-				return fmt.Errorf("%w", errors.New("error: [updateJSONMetricHandlerFunc] Unknown metric type status - 500"))
+				return applied, fmt.Errorf("%w", errors.New("error: [updateJSONMetricHandlerFunc] Unknown metric type status - 500"))
 			}
 		}
+		applied++
 	}
-	return nil
+	return applied, nil
 }
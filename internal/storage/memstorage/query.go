@@ -0,0 +1,338 @@
+package memstorage
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+)
+
+// Поля сортировки результатов Query.
+const (
+	SortID      string = "id"
+	SortType    string = "type"
+	SortValue   string = "value"
+	SortUpdated string = "updated"
+)
+
+var (
+	ErrBadSort   = errors.New("unknown sort field")
+	ErrBadCursor = errors.New("malformed cursor")
+)
+
+// Query параметры выборки метрик. Нулевые значения полей не ограничивают выборку.
+type Query struct {
+	// Match отбирает метрики по идентификатору.
+	Match func(id string) bool
+	// After курсор последнего элемента предыдущей страницы.
+	After *Cursor
+	// UpdatedSince оставляет метрики, обновленные не раньше указанного времени.
+	UpdatedSince time.Time
+	// StaleSince оставляет метрики, не обновлявшиеся с указанного времени,
+	// в том числе метрики с неизвестным временем обновления.
+	StaleSince time.Time
+	Type       string
+	Sort       string // SortID по умолчанию
+	Desc       bool
+	Limit      int // 0 - без ограничения
+	// WithUpdated заполняет Page.Updated.
+	WithUpdated bool
+}
+
+// Cursor ключ сортировки последнего возвращенного элемента.
+// Значение счетчика хранится в Delta целиком: float64 теряет точность после 2^53.
+// Значение gauge хранится в ValueBits (math.Float64bits): JSON не допускает NaN и ±Inf.
+type Cursor struct {
+	Sort      string `json:"s"`
+	Desc      bool   `json:"d,omitempty"`
+	Type      string `json:"t"`
+	ID        string `json:"i"`
+	ValueBits uint64 `json:"v,omitempty"`
+	Delta     int64  `json:"n,omitempty"`
+	Updated   int64  `json:"u,omitempty"`
+}
+
+// Encode возвращает курсор в виде непрозрачной строки для передачи клиенту.
+func (c *Cursor) Encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("cursor marshal error: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ParseCursor разбирает строку, полученную от Cursor.Encode.
+func ParseCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	c := &Cursor{}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, ErrBadCursor
+	}
+	return c, nil
+}
+
+// Page результат выборки.
+type Page struct {
+	Next    *Cursor // nil, если страница последняя
+	Metrics []model.Metrics
+	// Updated время обновления Metrics[i], нулевое, если неизвестно. Заполняется при Query.WithUpdated.
+	Updated []time.Time
+	Total   int // число метрик, удовлетворяющих фильтрам, без учета курсора и лимита
+}
+
+// entry облегченное представление метрики для фильтрации и сортировки без выделения памяти под указатели.
+type entry struct {
+	mtype   string
+	id      string
+	delta   int64
+	value   float64
+	updated int64
+}
+
+// compareValues сравнивает значения метрик без потери точности счетчиков.
+func compareValues(a, b *entry) int {
+	switch {
+	case a.mtype == counter && b.mtype == counter:
+		return compare(a.delta, b.delta)
+	case a.mtype == counter:
+		return compareIntFloat(a.delta, b.value)
+	case b.mtype == counter:
+		return -compareIntFloat(b.delta, a.value)
+	}
+	return compareFloat(a.value, b.value)
+}
+
+// compareFloat сравнивает значения gauge. NaN меньше любого числа и равен себе,
+// иначе порядок не был бы полным и постраничный обход мог бы пропускать метрики.
+func compareFloat(a, b float64) int {
+	aNaN, bNaN := math.IsNaN(a), math.IsNaN(b)
+	switch {
+	case aNaN && bNaN:
+		return 0
+	case aNaN:
+		return -1
+	case bNaN:
+		return 1
+	}
+	return compare(a, b)
+}
+
+// compareIntFloat точно сравнивает целое с float64; NaN меньше любого числа, как в compareFloat.
+func compareIntFloat(i int64, f float64) int {
+	switch {
+	case math.IsNaN(f):
+		return 1
+	case f >= math.MaxInt64: // 2^63 уже больше любого int64
+		return -1
+	case f < math.MinInt64:
+		return 1
+	}
+	t := math.Trunc(f)
+	if c := compare(i, int64(t)); c != 0 {
+		return c
+	}
+	return compare(0, f-t)
+}
+
+func (e *entry) cursor(q *Query) *Cursor {
+	c := &Cursor{Sort: q.Sort, Desc: q.Desc, Type: e.mtype, ID: e.id, Updated: e.updated}
+	if e.mtype == counter {
+		c.Delta = e.delta
+	} else {
+		c.ValueBits = math.Float64bits(e.value)
+	}
+	return c
+}
+
+func (c *Cursor) entry() entry {
+	return entry{mtype: c.Type, id: c.ID, value: math.Float64frombits(c.ValueBits), delta: c.Delta, updated: c.Updated}
+}
+
+// lessFunc возвращает строгий порядок элементов для поля сортировки. При равенстве ключей
+// элементы упорядочиваются по типу и идентификатору, поэтому порядок полный и курсор однозначен.
+func lessFunc(sortField string, desc bool) (func(a, b *entry) bool, error) {
+	byID := func(a, b *entry) bool {
+		if a.mtype != b.mtype {
+			return a.mtype < b.mtype
+		}
+		return a.id < b.id
+	}
+	var cmp func(a, b *entry) int
+	switch sortField {
+	case SortID, "":
+		cmp = func(a, b *entry) int { return compare(a.id, b.id) }
+	case SortType:
+		cmp = func(a, b *entry) int { return compare(a.mtype, b.mtype) }
+	case SortValue:
+		cmp = compareValues
+	case SortUpdated:
+		cmp = func(a, b *entry) int { return compare(a.updated, b.updated) }
+	default:
+		return nil, ErrBadSort
+	}
+	return func(a, b *entry) bool {
+		c := cmp(a, b)
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return byID(a, b)
+	}, nil
+}
+
+func compare[T string | int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Query выполняет выборку метрик. Из подходящих под фильтры метрик, следующих за курсором,
+// выбираются первые Limit без полной сортировки: O(n log Limit).
+func (m *MemStorage) Query(q Query) (Page, error) {
+	if q.Sort == "" {
+		q.Sort = SortID
+	}
+	less, err := lessFunc(q.Sort, q.Desc)
+	if err != nil {
+		return Page{}, err
+	}
+	var after *entry
+	if q.After != nil {
+		e := q.After.entry()
+		after = &e
+	}
+	sinceNano, staleNano := int64(0), int64(0)
+	if !q.UpdatedSince.IsZero() {
+		sinceNano = q.UpdatedSince.UnixNano()
+	}
+	if !q.StaleSince.IsZero() {
+		staleNano = q.StaleSince.UnixNano()
+	}
+
+	// время обновления хранится в отдельных картах: обращаемся к ним, только если оно нужно
+	needUpdated := q.WithUpdated || q.Sort == SortUpdated || sinceNano != 0 || staleNano != 0
+
+	sel := &selection{less: less, limit: q.Limit}
+	total, candidates := 0, 0
+	consider := func(e *entry) {
+		if q.Match != nil && !q.Match(e.id) {
+			return
+		}
+		if sinceNano != 0 && e.updated < sinceNano {
+			return
+		}
+		if staleNano != 0 && e.updated != 0 && e.updated >= staleNano {
+			return
+		}
+		total++
+		if after != nil && !less(after, e) {
+			return
+		}
+		candidates++
+		sel.push(e)
+	}
+
+	var e entry
+	m.Mux.RLock()
+	if q.Type == "" || q.Type == counter {
+		for id, v := range m.Counters {
+			e = entry{mtype: counter, id: id, delta: v}
+			if needUpdated {
+				e.updated = unixNano(m.countersUpdated[id])
+			}
+			consider(&e)
+		}
+	}
+	if q.Type == "" || q.Type == gauge {
+		for id, v := range m.Gauges {
+			e = entry{mtype: gauge, id: id, value: v}
+			if needUpdated {
+				e.updated = unixNano(m.gaugesUpdated[id])
+			}
+			consider(&e)
+		}
+	}
+	m.Mux.RUnlock()
+
+	items := sel.sorted()
+	page := Page{Metrics: make([]model.Metrics, len(items)), Total: total}
+	if q.WithUpdated {
+		page.Updated = make([]time.Time, len(items))
+	}
+	for i := range items {
+		e := &items[i]
+		if q.WithUpdated && e.updated != 0 {
+			page.Updated[i] = time.Unix(0, e.updated)
+		}
+		page.Metrics[i] = model.Metrics{ID: e.id, MType: e.mtype}
+		if e.mtype == counter {
+			page.Metrics[i].Delta = ptr(e.delta)
+		} else {
+			page.Metrics[i].Value = ptr(e.value)
+		}
+	}
+	if q.Limit > 0 && candidates > q.Limit {
+		page.Next = items[len(items)-1].cursor(&q)
+	}
+	return page, nil
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// selection хранит не более limit наименьших элементов в max-куче.
+type selection struct {
+	less  func(a, b *entry) bool
+	items []entry
+	limit int
+}
+
+func (s *selection) Len() int           { return len(s.items) }
+func (s *selection) Less(i, j int) bool { return s.less(&s.items[j], &s.items[i]) }
+func (s *selection) Swap(i, j int)      { s.items[i], s.items[j] = s.items[j], s.items[i] }
+func (s *selection) Push(x any)         { s.items = append(s.items, x.(entry)) }
+func (s *selection) Pop() any {
+	last := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return last
+}
+
+func (s *selection) push(e *entry) {
+	if s.limit <= 0 {
+		s.items = append(s.items, *e)
+		return
+	}
+	if len(s.items) < s.limit {
+		heap.Push(s, *e)
+		return
+	}
+	// вершина кучи — наибольший из отобранных элементов
+	if s.less(e, &s.items[0]) {
+		s.items[0] = *e
+		heap.Fix(s, 0)
+	}
+}
+
+func (s *selection) sorted() []entry {
+	sort.Slice(s.items, func(i, j int) bool { return s.less(&s.items[i], &s.items[j]) })
+	return s.items
+}
@@ -0,0 +1,233 @@
+package memstorage
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queryFixture(t testing.TB) *MemStorage {
+	t.Helper()
+	m := NewStorage()
+	for _, c := range []struct {
+		name string
+		val  int64
+	}{{"PollCount", 5}, {"requests", 20}, {"errors", 1}} {
+		_, err := m.IncCounter(c.name, ptr(c.val))
+		require.NoError(t, err)
+	}
+	for _, g := range []struct {
+		name string
+		val  float64
+	}{{"Alloc", 10.5}, {"cpu_user", 3}, {"cpu_system", 7}, {"Frees", 5}} {
+		_, err := m.SetGauge(g.name, ptr(g.val))
+		require.NoError(t, err)
+	}
+	return m
+}
+
+func ids(page Page) []string {
+	res := make([]string, len(page.Metrics))
+	for i, m := range page.Metrics {
+		res[i] = m.MType + ":" + m.ID
+	}
+	return res
+}
+
+func TestMemStorage_Query(t *testing.T) {
+	m := queryFixture(t)
+	// errors восстановлена из файла: время обновления неизвестно
+	delete(m.countersUpdated, "errors")
+	m.gaugesUpdated["Frees"] = time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		q         Query
+		want      []string
+		wantTotal int
+	}{
+		{
+			name:      "default order by id",
+			q:         Query{},
+			want:      []string{"gauge:Alloc", "gauge:Frees", "counter:PollCount", "gauge:cpu_system", "gauge:cpu_user", "counter:errors", "counter:requests"},
+			wantTotal: 7,
+		},
+		{
+			name:      "type filter and match",
+			q:         Query{Type: gauge, Match: func(id string) bool { return strings.HasPrefix(id, "cpu_") }},
+			want:      []string{"gauge:cpu_system", "gauge:cpu_user"},
+			wantTotal: 2,
+		},
+		{
+			name:      "sort by value desc",
+			q:         Query{Sort: SortValue, Desc: true, Limit: 3},
+			want:      []string{"counter:requests", "gauge:Alloc", "gauge:cpu_system"},
+			wantTotal: 7,
+		},
+		{
+			name:      "equal values ordered by type and id",
+			q:         Query{Sort: SortValue, Match: func(id string) bool { return id == "PollCount" || id == "Frees" }},
+			want:      []string{"counter:PollCount", "gauge:Frees"},
+			wantTotal: 2,
+		},
+		{
+			name:      "updated since",
+			q:         Query{UpdatedSince: time.Now().Add(-time.Minute), Type: counter},
+			want:      []string{"counter:PollCount", "counter:requests"},
+			wantTotal: 2,
+		},
+		{
+			name:      "stale includes unknown update time",
+			q:         Query{StaleSince: time.Now().Add(-time.Minute)},
+			want:      []string{"gauge:Frees", "counter:errors"},
+			wantTotal: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := m.Query(tt.q)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ids(page))
+			assert.Equal(t, tt.wantTotal, page.Total)
+		})
+	}
+
+	_, err := m.Query(Query{Sort: "color"})
+	assert.ErrorIs(t, err, ErrBadSort)
+}
+
+func TestMemStorage_QueryPagination(t *testing.T) {
+	m := NewStorage()
+	for i := 0; i < 250; i++ {
+		_, err := m.SetGauge(fmt.Sprintf("g%03d", i), ptr(float64(i%7)))
+		require.NoError(t, err)
+		_, err = m.IncCounter(fmt.Sprintf("c%03d", i), ptr(int64(i%5)))
+		require.NoError(t, err)
+	}
+	for _, sortField := range []string{SortID, SortType, SortValue, SortUpdated} {
+		for _, desc := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s desc=%v", sortField, desc), func(t *testing.T) {
+				full, err := m.Query(Query{Sort: sortField, Desc: desc})
+				require.NoError(t, err)
+				require.Len(t, full.Metrics, 500)
+
+				var walked []string
+				q := Query{Sort: sortField, Desc: desc, Limit: 64}
+				for {
+					page, err := m.Query(q)
+					require.NoError(t, err)
+					assert.Equal(t, 500, page.Total)
+					walked = append(walked, ids(page)...)
+					if page.Next == nil {
+						break
+					}
+					q.After = page.Next
+				}
+				assert.Equal(t, ids(full), walked)
+			})
+		}
+	}
+}
+
+// walkEncoded обходит выборку постранично, передавая курсор в закодированном виде, как клиент API.
+func walkEncoded(t *testing.T, m *MemStorage, q Query) []string {
+	t.Helper()
+	var walked []string
+	for {
+		page, err := m.Query(q)
+		require.NoError(t, err)
+		walked = append(walked, ids(page)...)
+		if page.Next == nil {
+			return walked
+		}
+		s, err := page.Next.Encode()
+		require.NoError(t, err)
+		q.After, err = ParseCursor(s)
+		require.NoError(t, err)
+	}
+}
+
+func TestMemStorage_QueryNonFinite(t *testing.T) {
+	m := NewStorage()
+	for id, v := range map[string]float64{
+		"nan": math.NaN(), "nan2": math.NaN(), "inf": math.Inf(1), "-inf": math.Inf(-1), "zero": 0, "one": 1,
+	} {
+		_, err := m.SetGauge(id, ptr(v))
+		require.NoError(t, err)
+	}
+	_, err := m.IncCounter("cnt", ptr(int64(1)))
+	require.NoError(t, err)
+
+	page, err := m.Query(Query{Sort: SortValue})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"gauge:nan", "gauge:nan2", "gauge:-inf", "gauge:zero", "counter:cnt", "gauge:one", "gauge:inf",
+	}, ids(page))
+
+	for _, sortField := range []string{SortValue, SortID} {
+		for _, desc := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s desc=%v", sortField, desc), func(t *testing.T) {
+				full, err := m.Query(Query{Sort: sortField, Desc: desc})
+				require.NoError(t, err)
+				assert.Equal(t, ids(full), walkEncoded(t, m, Query{Sort: sortField, Desc: desc, Limit: 1}))
+			})
+		}
+	}
+}
+
+func TestMemStorage_QueryLargeCounters(t *testing.T) {
+	// после 2^53 соседние int64 неразличимы в float64
+	const base = int64(1) << 53
+	m := NewStorage()
+	for i := int64(0); i < 6; i++ {
+		_, err := m.IncCounter(fmt.Sprintf("c%d", 5-i), ptr(base+i))
+		require.NoError(t, err)
+	}
+	_, err := m.SetGauge("g", ptr(float64(base)+2))
+	require.NoError(t, err)
+
+	for _, desc := range []bool{false, true} {
+		t.Run(fmt.Sprintf("desc=%v", desc), func(t *testing.T) {
+			full, err := m.Query(Query{Sort: SortValue, Desc: desc})
+			require.NoError(t, err)
+
+			assert.Equal(t, ids(full), walkEncoded(t, m, Query{Sort: SortValue, Desc: desc, Limit: 2}))
+		})
+	}
+
+	page, err := m.Query(Query{Sort: SortValue})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"counter:c5", "counter:c4", "counter:c3", "gauge:g", "counter:c2", "counter:c1", "counter:c0",
+	}, ids(page))
+}
+
+func TestMemStorage_GetViewSorted(t *testing.T) {
+	m := queryFixture(t)
+	view, err := m.GetView()
+	require.NoError(t, err)
+	names := make([]string, len(view))
+	for i, v := range view {
+		names[i] = v.Name
+	}
+	assert.Equal(t, []string{"PollCount", "errors", "requests", "Alloc", "Frees", "cpu_system", "cpu_user"}, names)
+}
+
+func BenchmarkMemStorage_Query(b *testing.B) {
+	m := NewStorage()
+	for i := 0; i < 100000; i++ {
+		_, _ = m.SetGauge(fmt.Sprintf("gauge_%06d", i), ptr(float64(i)))
+	}
+	q := Query{Sort: SortValue, Desc: true, Limit: 100}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Query(q); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
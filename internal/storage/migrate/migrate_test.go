@@ -0,0 +1,140 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr error
+	}{
+		{name: "file", spec: "file:" + filepath.Join(dir, "metrics.json")},
+		{name: "bolt", spec: "bolt:" + filepath.Join(dir, "metrics.db")},
+		{name: "sqlite", spec: "sqlite:" + filepath.Join(dir, "metrics.sqlite")},
+		{name: "unknown scheme", spec: "redis://localhost", wantErr: ErrUnknownScheme},
+		{name: "no scheme", spec: "metrics.json", wantErr: ErrUnknownScheme},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Open(context.Background(), tt.spec)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr))
+				return
+			}
+			assert.NoError(t, err)
+			s.Close()
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	src := memstorage.NewStorage()
+	src.Gauges["same"] = 1.5
+	src.Gauges["changed"] = 1e-9
+	src.Gauges["missing"] = 3
+	src.Counters["cnt"] = 10
+
+	dst := memstorage.NewStorage()
+	dst.Gauges["same"] = 1.5
+	dst.Gauges["changed"] = 2e-9
+	dst.Counters["cnt"] = 10
+	dst.Counters["extra"] = 1
+
+	want := []Difference{
+		{ID: "extra", MType: counter, Kind: Extra, Dst: "1"},
+		{ID: "changed", MType: gauge, Kind: Changed, Src: "1e-09", Dst: "2e-09"},
+		{ID: "missing", MType: gauge, Kind: Missing, Src: "3"},
+	}
+	got := Diff(src, dst)
+	assert.Equal(t, want, got)
+	assert.Len(t, Mismatches(got), 2)
+}
+
+func TestCopyVerify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	src, err := Open(ctx, "file:"+filepath.Join(dir, "metrics.json"))
+	assert.NoError(t, err)
+	defer src.Close()
+
+	ms := memstorage.NewStorage()
+	ms.Gauges["Alloc"] = 2048.25
+	ms.Counters["PollCount"] = 42
+	assert.NoError(t, src.Save(ctx, ms))
+
+	dst, err := Open(ctx, "bolt:"+filepath.Join(dir, "metrics.db"))
+	assert.NoError(t, err)
+	defer dst.Close()
+
+	restored, err := src.Restore(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, Copy(ctx, restored, dst))
+
+	diffs, err := Verify(ctx, ms, dst)
+	assert.NoError(t, err)
+	assert.Empty(t, diffs)
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ms := memstorage.NewStorage()
+	ms.Gauges["Alloc"] = 2048.25
+	ms.Counters["PollCount"] = 42
+
+	for _, scheme := range []string{"file", "bolt", "sqlite"} {
+		t.Run(scheme, func(t *testing.T) {
+			path := filepath.Join(dir, scheme+".store")
+			spec := scheme + ":" + path
+
+			// отсутствующее хранилище читается как пустое и не создается
+			got, err := Snapshot(ctx, spec)
+			require.NoError(t, err)
+			assert.Empty(t, got.Gauges)
+			assert.Empty(t, got.Counters)
+			assert.NoFileExists(t, path)
+			_, err = OpenReadOnly(ctx, spec)
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			s, err := Open(ctx, spec)
+			require.NoError(t, err)
+			require.NoError(t, Copy(ctx, ms, s))
+			s.Close()
+
+			got, err = Snapshot(ctx, spec)
+			require.NoError(t, err)
+			assert.Empty(t, Diff(ms, got))
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	src := memstorage.NewStorage()
+	src.Gauges["same"] = 1.5
+	src.Gauges["changed"] = 1
+	src.Counters["new"] = 10
+
+	dst := memstorage.NewStorage()
+	dst.Gauges["same"] = 1.5
+	dst.Gauges["changed"] = 2
+	dst.Counters["extra"] = 1
+
+	want := []Difference{
+		{ID: "new", MType: counter, Kind: Missing, Src: "10"},
+		{ID: "changed", MType: gauge, Kind: Changed, Src: "1", Dst: "2"},
+		{ID: "same", MType: gauge, Kind: Same, Src: "1.5", Dst: "1.5"},
+	}
+	assert.Equal(t, want, Plan(src, dst))
+}
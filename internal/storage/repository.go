@@ -6,6 +6,7 @@ import (
 
 	"github.com/rebus2015/praktikum-devops/internal/model"
 	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+	"github.com/rebus2015/praktikum-devops/internal/stream"
 )
 
 type Repository interface {
@@ -14,7 +15,10 @@ type Repository interface {
 	GetCounter(name string) (int64, error)
 	GetGauge(name string) (float64, error)
 	GetView() ([]memstorage.MetricStr, error)
+	GetMetrics() ([]model.Metrics, error)
+	QueryMetrics(q memstorage.Query) (memstorage.Page, error)
 	AddMetrics([]*model.Metrics) error
+	Stream() *stream.Broker
 }
 
 type SecondaryStorage interface {
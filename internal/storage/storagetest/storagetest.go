@@ -0,0 +1,256 @@
+// Package storagetest содержит общий набор проверок (conformance suite) для реализаций storage.SecondaryStorage.
+//
+// Пакет тестов конкретного хранилища описывает, как создать пустой экземпляр, и вызывает Run:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T, sync bool) storagetest.Instance {
+//			...
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/storage"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+// Instance тестируемый экземпляр хранилища.
+type Instance struct {
+	// Storage пустое хранилище.
+	Storage storage.SecondaryStorage
+	// Reopen закрывает Storage и открывает те же данные заново. Если nil, проверки выполняются на Storage.
+	Reopen func() (storage.SecondaryStorage, error)
+	// Corrupt повреждает сохраненные данные. Если nil, проверка поврежденных данных пропускается.
+	Corrupt func()
+}
+
+// Factory создает новый независимый экземпляр хранилища для каждой проверки.
+// Освобождение ресурсов экземпляра выполняется через t.Cleanup.
+type Factory func(t *testing.T, sync bool) Instance
+
+const (
+	largeSize      = 5000
+	largeSizeShort = 500
+	writers        = 8
+	writerUpdates  = 50
+)
+
+// Run запускает все проверки набора для хранилища, создаваемого factory.
+func Run(t *testing.T, factory Factory) {
+	t.Helper()
+	t.Run("EmptyRestore", func(t *testing.T) { testEmptyRestore(t, factory) })
+	t.Run("SaveEmpty", func(t *testing.T) { testSaveEmpty(t, factory) })
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, factory) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, factory) })
+	t.Run("Large", func(t *testing.T) { testLarge(t, factory) })
+	t.Run("ConcurrentSaves", func(t *testing.T) { testConcurrentSaves(t, factory) })
+	t.Run("Corrupted", func(t *testing.T) { testCorrupted(t, factory) })
+	t.Run("SyncMode", func(t *testing.T) { testSyncMode(t, factory) })
+}
+
+// reopen возвращает хранилище, из которого следует восстанавливать данные.
+func reopen(t *testing.T, inst Instance) storage.SecondaryStorage {
+	t.Helper()
+	if inst.Reopen == nil {
+		return inst.Storage
+	}
+	s, err := inst.Reopen()
+	require.NoError(t, err, "reopen storage")
+	return s
+}
+
+func restore(t *testing.T, inst Instance) *memstorage.MemStorage {
+	t.Helper()
+	ms, err := reopen(t, inst).Restore(context.Background())
+	require.NoError(t, err, "Restore()")
+	require.NotNil(t, ms)
+	require.NotNil(t, ms.Mux, "restored storage must be usable")
+	return ms
+}
+
+func assertEqualStorage(t *testing.T, want, got *memstorage.MemStorage) {
+	t.Helper()
+	require.Len(t, got.Gauges, len(want.Gauges), "gauges count")
+	require.Len(t, got.Counters, len(want.Counters), "counters count")
+	for name, val := range want.Gauges {
+		g, ok := got.Gauges[name]
+		if assert.True(t, ok, "gauge '%s' is missing", name) {
+			assert.Equal(t, math.Float64bits(val), math.Float64bits(g), "gauge '%s': want %v, got %v", name, val, g)
+		}
+	}
+	for name, val := range want.Counters {
+		c, ok := got.Counters[name]
+		if assert.True(t, ok, "counter '%s' is missing", name) {
+			assert.Equal(t, val, c, "counter '%s'", name)
+		}
+	}
+}
+
+func testEmptyRestore(t *testing.T, factory Factory) {
+	inst := factory(t, false)
+	ms, err := inst.Storage.Restore(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, ms.Gauges)
+	assert.Empty(t, ms.Counters)
+
+	// восстановленное хранилище должно принимать новые значения
+	_, err = ms.SetGauge("g", "1")
+	assert.NoError(t, err)
+	_, err = ms.IncCounter("c", "1")
+	assert.NoError(t, err)
+}
+
+func testSaveEmpty(t *testing.T, factory Factory) {
+	inst := factory(t, false)
+	require.NoError(t, inst.Storage.Save(context.Background(), memstorage.NewStorage()))
+	assertEqualStorage(t, memstorage.NewStorage(), restore(t, inst))
+}
+
+func testRoundTrip(t *testing.T, factory Factory) {
+	inst := factory(t, false)
+	ms := memstorage.NewStorage()
+	ms.Gauges["zero"] = 0
+	ms.Gauges["negative"] = -164.5
+	ms.Gauges["tiny"] = 1e-9
+	ms.Gauges["precise"] = 0.1 + 0.2
+	ms.Gauges["huge"] = math.MaxFloat64
+	ms.Gauges["name with spaces/and:separators"] = 12.003
+	ms.Counters["zero"] = 0
+	ms.Counters["negative"] = -5
+	ms.Counters["max"] = math.MaxInt64
+	ms.Counters["PollCount"] = 101
+
+	require.NoError(t, inst.Storage.Save(context.Background(), ms))
+	assertEqualStorage(t, ms, restore(t, inst))
+}
+
+func testOverwrite(t *testing.T, factory Factory) {
+	inst := factory(t, false)
+	ms := memstorage.NewStorage()
+	ms.Gauges["g1"] = 1
+	ms.Gauges["g2"] = 2
+	ms.Counters["c1"] = 10
+	require.NoError(t, inst.Storage.Save(context.Background(), ms))
+
+	ms.Gauges["g1"] = 11
+	ms.Counters["c1"] = 20
+	ms.Counters["c2"] = 1
+	require.NoError(t, inst.Storage.Save(context.Background(), ms))
+
+	assertEqualStorage(t, ms, restore(t, inst))
+}
+
+func testLarge(t *testing.T, factory Factory) {
+	size := largeSize
+	if testing.Short() {
+		size = largeSizeShort
+	}
+	inst := factory(t, false)
+	ms := memstorage.NewStorage()
+	for i := 0; i < size; i++ {
+		ms.Gauges[fmt.Sprintf("gauge_%06d", i)] = float64(i) / 7
+		ms.Counters[fmt.Sprintf("counter_%06d", i)] = int64(i) * 1000003
+	}
+	require.NoError(t, inst.Storage.Save(context.Background(), ms))
+	assertEqualStorage(t, ms, restore(t, inst))
+}
+
+func testConcurrentSaves(t *testing.T, factory Factory) {
+	inst := factory(t, false)
+	ms := memstorage.NewStorage()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*writerUpdates)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writerUpdates; i++ {
+				if _, err := ms.SetGauge(fmt.Sprintf("gauge_%d", w), fmt.Sprint(i)); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := ms.IncCounter("shared", "1"); err != nil {
+					errs <- err
+					return
+				}
+				if i%10 == 0 {
+					if err := inst.Storage.Save(context.Background(), ms); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, inst.Storage.Save(context.Background(), ms))
+	got := restore(t, inst)
+	assertEqualStorage(t, ms, got)
+	assert.Equal(t, int64(writers*writerUpdates), got.Counters["shared"])
+}
+
+func testCorrupted(t *testing.T, factory Factory) {
+	inst := factory(t, false)
+	if inst.Corrupt == nil {
+		t.Skip("storage does not provide a way to corrupt its data")
+	}
+	ms := memstorage.NewStorage()
+	ms.Gauges["g1"] = 1
+	require.NoError(t, inst.Storage.Save(context.Background(), ms))
+
+	inst.Corrupt()
+
+	s := inst.Storage
+	if inst.Reopen != nil {
+		var err error
+		s, err = inst.Reopen()
+		if err != nil {
+			// повреждение обнаружено уже при открытии
+			return
+		}
+	}
+	got, err := s.Restore(context.Background())
+	assert.Error(t, err, "Restore() of corrupted data must fail")
+	assert.Nil(t, got)
+}
+
+func testSyncMode(t *testing.T, factory Factory) {
+	for _, sync := range []bool{true, false} {
+		t.Run(fmt.Sprintf("sync=%v", sync), func(t *testing.T) {
+			inst := factory(t, sync)
+			assert.Equal(t, sync, inst.Storage.SyncMode())
+
+			// RepositoryWrapper сохраняет каждое изменение только в синхронном режиме
+			rw := storage.NewRepositoryWrapper(memstorage.NewStorage(), inst.Storage)
+			_, err := rw.AddGauge("g1", "1.5")
+			require.NoError(t, err)
+			_, err = rw.AddCounter("c1", "3")
+			require.NoError(t, err)
+
+			got, err := inst.Storage.Restore(context.Background())
+			require.NoError(t, err)
+			if sync {
+				assert.Equal(t, map[string]float64{"g1": 1.5}, got.Gauges)
+				assert.Equal(t, map[string]int64{"c1": 3}, got.Counters)
+				return
+			}
+			assert.Empty(t, got.Gauges)
+			assert.Empty(t, got.Counters)
+		})
+	}
+}
@@ -3,19 +3,29 @@ package storage
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/rebus2015/praktikum-devops/internal/model"
 	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+	"github.com/rebus2015/praktikum-devops/internal/stream"
 )
 
 type RepositoryWrapper struct {
 	memstorage       *memstorage.MemStorage
 	secondarystorage SecondaryStorage
+	broker           *stream.Broker
+	// applyMux удерживается от изменения метрики до публикации события,
+	// поэтому события публикуются в порядке применения изменений
+	applyMux sync.Mutex
 }
 
-const fsSaveErrorMsg string = "FileStorage Save error: %v"
+const (
+	counter        string = "counter"
+	gauge          string = "gauge"
+	fsSaveErrorMsg string = "FileStorage Save error: %v"
+)
 
 var _ Repository = new(RepositoryWrapper)
 
@@ -23,15 +33,26 @@ func NewRepositoryWrapper(mes *memstorage.MemStorage, sec SecondaryStorage) *Rep
 	return &RepositoryWrapper{
 		memstorage:       mes,
 		secondarystorage: sec,
+		broker:           stream.NewBroker(stream.DefaultBacklog),
 	}
 }
+
+// Stream брокер, в который публикуются примененные изменения метрик.
+func (rw *RepositoryWrapper) Stream() *stream.Broker {
+	return rw.broker
+}
 func (rw *RepositoryWrapper) AddGauge(name string, val interface{}) (float64, error) {
+	rw.applyMux.Lock()
 	retval, err := rw.memstorage.SetGauge(name, val)
+	if err == nil {
+		rw.broker.Publish(model.Metrics{ID: name, MType: gauge, Value: &retval})
+	}
+	rw.applyMux.Unlock()
 	if rw.secondarystorage != nil {
 		if rw.secondarystorage.SyncMode() {
 			errs := rw.secondarystorage.Save(context.Background(), rw.memstorage)
 			if errs != nil {
-				log.Printf("FileStorage Save error: %v", err)
+				log.Printf(fsSaveErrorMsg, errs)
 			}
 		}
 	}
@@ -42,12 +63,17 @@ func (rw *RepositoryWrapper) AddGauge(name string, val interface{}) (float64, er
 }
 
 func (rw *RepositoryWrapper) AddCounter(name string, val interface{}) (int64, error) {
+	rw.applyMux.Lock()
 	retval, err := rw.memstorage.IncCounter(name, val)
+	if err == nil {
+		rw.broker.Publish(model.Metrics{ID: name, MType: counter, Delta: &retval})
+	}
+	rw.applyMux.Unlock()
 	if rw.secondarystorage != nil {
 		if rw.secondarystorage.SyncMode() {
 			errs := rw.secondarystorage.Save(context.Background(), rw.memstorage)
 			if errs != nil {
-				log.Printf("FileStorage Save error: %v", err)
+				log.Printf(fsSaveErrorMsg, errs)
 			}
 		}
 	}
@@ -81,15 +107,37 @@ func (rw *RepositoryWrapper) GetView() ([]memstorage.MetricStr, error) {
 	return result, nil
 }
 
+func (rw *RepositoryWrapper) GetMetrics() ([]model.Metrics, error) {
+	return rw.memstorage.GetMetrics(), nil
+}
+
+func (rw *RepositoryWrapper) QueryMetrics(q memstorage.Query) (memstorage.Page, error) {
+	page, err := rw.memstorage.Query(q)
+	if err != nil {
+		return memstorage.Page{}, fmt.Errorf("QueryMetrics error: %w", err)
+	}
+	return page, nil
+}
+
+// AddMetrics применяет метрики по порядку. При ошибке метрики до ошибочной остаются примененными,
+// и события о них публикуются, чтобы подписчики потока не пропустили изменения.
 func (rw *RepositoryWrapper) AddMetrics(m []*model.Metrics) error {
-	err := rw.memstorage.AddMetrics(m)
+	rw.applyMux.Lock()
+	applied, err := rw.memstorage.ApplyMetrics(m)
+	if applied > 0 {
+		rw.broker.Publish(rw.memstorage.Values(m[:applied])...)
+	}
+	rw.applyMux.Unlock()
 	if rw.secondarystorage != nil {
 		if rw.secondarystorage.SyncMode() {
 			errs := rw.secondarystorage.Save(context.Background(), rw.memstorage)
 			if errs != nil {
-				log.Printf(fsSaveErrorMsg, err)
+				log.Printf(fsSaveErrorMsg, errs)
 			}
 		}
 	}
+	if err != nil {
+		return fmt.Errorf("AddMetrics error: %w", err)
+	}
 	return nil
 }
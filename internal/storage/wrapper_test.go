@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+	"github.com/rebus2015/praktikum-devops/internal/storage/memstorage"
+)
+
+func TestRepositoryWrapper_publishOrder(t *testing.T) {
+	rw := NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	const writers, writes = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(batch bool) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if batch {
+					delta := int64(1)
+					assert.NoError(t, rw.AddMetrics([]*model.Metrics{{ID: "c", MType: counter, Delta: &delta}}))
+					continue
+				}
+				_, aerr := rw.AddCounter("c", "1")
+				assert.NoError(t, aerr)
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+
+	// счетчик только растет, значит события в порядке номеров должны нести строго растущие значения
+	events := rw.Stream().Backlog()
+	require.Len(t, events, writers*writes)
+	var last int64
+	for _, e := range events {
+		require.NotNil(t, e.Metric.Delta)
+		assert.Greater(t, *e.Metric.Delta, last, "seq %d", e.Seq)
+		last = *e.Metric.Delta
+	}
+	assert.Equal(t, int64(writers*writes), last)
+}
+
+func TestRepositoryWrapper_AddMetricsPartial(t *testing.T) {
+	rw := NewRepositoryWrapper(memstorage.NewStorage(), nil)
+	delta, value := int64(3), 1.5
+	err := rw.AddMetrics([]*model.Metrics{
+		{ID: "c", MType: counter, Delta: &delta},
+		{ID: "g", MType: gauge, Value: &value},
+		{ID: "broken", MType: gauge},
+		{ID: "late", MType: gauge, Value: &value},
+	})
+	assert.Error(t, err)
+
+	// примененные до ошибки метрики сохранены, и подписчики получают о них события
+	events := rw.Stream().Backlog()
+	require.Len(t, events, 2)
+	assert.Equal(t, "c", events[0].Metric.ID)
+	assert.Equal(t, "g", events[1].Metric.ID)
+	_, err = rw.GetGauge("late")
+	assert.Error(t, err)
+}
@@ -0,0 +1,212 @@
+// Package stream рассылает подписчикам изменения метрик в порядке их применения.
+//
+// Каждому изменению присваивается порядковый номер. Последние изменения хранятся в кольцевом
+// буфере, поэтому переподключившийся клиент может продолжить получение с известного ему номера.
+// Публикация никогда не блокируется: подписчик, не успевающий читать события, отключается,
+// а его канал закрывается с причиной ErrLagged.
+package stream
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+)
+
+const (
+	DefaultBacklog    int = 4096
+	DefaultBufferSize int = 256
+)
+
+var (
+	// ErrLagged подписчик не успевал читать события и был отключен.
+	ErrLagged = errors.New("subscriber is too slow, events dropped")
+	// ErrClosed брокер остановлен.
+	ErrClosed = errors.New("stream broker is closed")
+)
+
+// Event изменение метрики. Metric содержит значение после применения изменения.
+type Event struct {
+	Time   time.Time     `json:"time"`
+	Metric model.Metrics `json:"metric"`
+	Seq    uint64        `json:"seq"`
+}
+
+// Filter отбирает события для подписчика. Нулевое значение пропускает все события.
+type Filter struct {
+	Match func(id string) bool
+	Type  string
+}
+
+func (f *Filter) accept(e *Event) bool {
+	if f.Type != "" && f.Type != e.Metric.MType {
+		return false
+	}
+	return f.Match == nil || f.Match(e.Metric.ID)
+}
+
+// Broker хранит последние события и рассылает новые подписчикам.
+type Broker struct {
+	subs       map[*Subscription]struct{}
+	ring       []Event
+	mux        sync.Mutex
+	seq        uint64
+	bufferSize int
+	closed     bool
+}
+
+// NewBroker создает брокер, хранящий backlog последних событий.
+func NewBroker(backlog int) *Broker {
+	if backlog <= 0 {
+		backlog = DefaultBacklog
+	}
+	return &Broker{
+		subs:       map[*Subscription]struct{}{},
+		ring:       make([]Event, backlog),
+		bufferSize: DefaultBufferSize,
+	}
+}
+
+// Subscription подписка на события. События читаются из канала Events, после его закрытия
+// причину можно получить методом Err.
+type Subscription struct {
+	events chan Event
+	broker *Broker
+	err    error
+	filter Filter
+	// Gap true, если часть запрошенных событий уже вытеснена из буфера:
+	// клиенту следует заново получить полное состояние.
+	Gap bool
+}
+
+// Events канал событий подписки.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err причина закрытия канала событий, nil при закрытии через Close.
+func (s *Subscription) Err() error {
+	s.broker.mux.Lock()
+	defer s.broker.mux.Unlock()
+	return s.err
+}
+
+// Close отменяет подписку.
+func (s *Subscription) Close() {
+	s.broker.mux.Lock()
+	defer s.broker.mux.Unlock()
+	s.broker.drop(s, nil)
+}
+
+// Seq номер последнего опубликованного события.
+func (b *Broker) Seq() uint64 {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.seq
+}
+
+// Backlog возвращает события, хранящиеся в буфере, в порядке публикации.
+func (b *Broker) Backlog() []Event {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	oldest := uint64(1)
+	if b.seq > uint64(len(b.ring)) {
+		oldest = b.seq - uint64(len(b.ring)) + 1
+	}
+	events := make([]Event, 0, b.seq-oldest+1)
+	for seq := oldest; seq <= b.seq; seq++ {
+		events = append(events, b.ring[seq%uint64(len(b.ring))])
+	}
+	return events
+}
+
+// Publish присваивает событиям номера и рассылает их подписчикам.
+func (b *Broker) Publish(metrics ...model.Metrics) {
+	if len(metrics) == 0 {
+		return
+	}
+	now := time.Now()
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.closed {
+		return
+	}
+	for i := range metrics {
+		b.seq++
+		e := Event{Seq: b.seq, Time: now, Metric: metrics[i]}
+		b.ring[b.seq%uint64(len(b.ring))] = e
+		for s := range b.subs {
+			if !s.filter.accept(&e) {
+				continue
+			}
+			select {
+			case s.events <- e:
+			default:
+				b.drop(s, ErrLagged)
+			}
+		}
+	}
+}
+
+// Subscribe подписывает на события с номерами больше since. События из буфера, следующие за since,
+// доставляются первыми. since == 0 означает подписку только на новые события.
+func (b *Broker) Subscribe(filter Filter, since uint64) (*Subscription, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	var backlog []Event
+	gap := false
+	if since > 0 && since < b.seq {
+		oldest := uint64(1)
+		if b.seq > uint64(len(b.ring)) {
+			oldest = b.seq - uint64(len(b.ring)) + 1
+		}
+		if since+1 < oldest {
+			gap = true
+			since = oldest - 1
+		}
+		for seq := since + 1; seq <= b.seq; seq++ {
+			e := b.ring[seq%uint64(len(b.ring))]
+			if filter.accept(&e) {
+				backlog = append(backlog, e)
+			}
+		}
+	} else if since > b.seq {
+		// номер из будущего: брокер перезапускался, история потеряна
+		gap = true
+	}
+	s := &Subscription{
+		events: make(chan Event, b.bufferSize+len(backlog)),
+		broker: b,
+		filter: filter,
+		Gap:    gap,
+	}
+	for _, e := range backlog {
+		s.events <- e
+	}
+	b.subs[s] = struct{}{}
+	return s, nil
+}
+
+// Close отключает всех подписчиков.
+func (b *Broker) Close() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		b.drop(s, ErrClosed)
+	}
+}
+
+// drop отключает подписчика. Вызывается под блокировкой брокера.
+func (b *Broker) drop(s *Subscription, err error) {
+	if _, ok := b.subs[s]; !ok {
+		return
+	}
+	delete(b.subs, s)
+	s.err = err
+	close(s.events)
+}
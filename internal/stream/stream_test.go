@@ -0,0 +1,125 @@
+package stream
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rebus2015/praktikum-devops/internal/model"
+)
+
+func gauge(id string, v float64) model.Metrics {
+	return model.Metrics{ID: id, MType: "gauge", Value: &v}
+}
+
+func counter(id string, d int64) model.Metrics {
+	return model.Metrics{ID: id, MType: "counter", Delta: &d}
+}
+
+// drain читает все уже доставленные события подписки.
+func drain(s *Subscription) []uint64 {
+	seqs := []uint64{}
+	for {
+		select {
+		case e, ok := <-s.Events():
+			if !ok {
+				return seqs
+			}
+			seqs = append(seqs, e.Seq)
+		default:
+			return seqs
+		}
+	}
+}
+
+func TestBroker_PublishFilter(t *testing.T) {
+	b := NewBroker(16)
+	all, err := b.Subscribe(Filter{}, 0)
+	require.NoError(t, err)
+	cpu, err := b.Subscribe(Filter{Type: "gauge", Match: func(id string) bool { return strings.HasPrefix(id, "cpu") }}, 0)
+	require.NoError(t, err)
+
+	b.Publish(gauge("cpu_user", 1), counter("cpu_ticks", 2), gauge("Alloc", 3))
+	b.Publish(gauge("cpu_system", 4))
+
+	assert.Equal(t, []uint64{1, 2, 3, 4}, drain(all))
+	assert.Equal(t, []uint64{1, 4}, drain(cpu))
+	assert.Equal(t, uint64(4), b.Seq())
+
+	cpu.Close()
+	cpu.Close()
+	_, ok := <-cpu.Events()
+	assert.False(t, ok)
+	assert.NoError(t, cpu.Err())
+}
+
+func TestBroker_Resume(t *testing.T) {
+	b := NewBroker(4)
+	for i := 1; i <= 6; i++ {
+		b.Publish(counter("c", int64(i)))
+	}
+
+	tests := []struct {
+		name    string
+		since   uint64
+		want    []uint64
+		wantGap bool
+	}{
+		{name: "new events only", since: 0, want: []uint64{}},
+		{name: "resume inside backlog", since: 4, want: []uint64{5, 6}},
+		{name: "resume at oldest", since: 2, want: []uint64{3, 4, 5, 6}},
+		{name: "history lost", since: 1, want: []uint64{3, 4, 5, 6}, wantGap: true},
+		{name: "up to date", since: 6, want: []uint64{}},
+		{name: "broker restarted", since: 100, want: []uint64{}, wantGap: true},
+	}
+	backlog := []uint64{}
+	for _, e := range b.Backlog() {
+		backlog = append(backlog, e.Seq)
+	}
+	assert.Equal(t, []uint64{3, 4, 5, 6}, backlog)
+	assert.Empty(t, NewBroker(4).Backlog())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := b.Subscribe(Filter{}, tt.since)
+			require.NoError(t, err)
+			defer s.Close()
+			assert.Equal(t, tt.want, drain(s))
+			assert.Equal(t, tt.wantGap, s.Gap)
+		})
+	}
+}
+
+func TestBroker_SlowSubscriber(t *testing.T) {
+	b := NewBroker(1024)
+	b.bufferSize = 3
+	slow, err := b.Subscribe(Filter{}, 0)
+	require.NoError(t, err)
+	fast, err := b.Subscribe(Filter{}, 0)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		b.Publish(gauge("g", float64(i)))
+		drain(fast)
+	}
+	// буфер медленного подписчика заполнен: следующее событие отключает его, не блокируя публикацию
+	b.Publish(gauge("g", 3))
+	assert.Equal(t, []uint64{1, 2, 3}, drain(slow))
+	assert.ErrorIs(t, slow.Err(), ErrLagged)
+	assert.Equal(t, []uint64{4}, drain(fast))
+
+	// переподключение с последнего полученного номера восстанавливает пропущенное
+	resumed, err := b.Subscribe(Filter{}, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{4}, drain(resumed))
+	assert.False(t, resumed.Gap)
+
+	b.Close()
+	assert.ErrorIs(t, fast.Err(), ErrClosed)
+	_, err = b.Subscribe(Filter{}, 0)
+	assert.ErrorIs(t, err, ErrClosed)
+	b.Publish(gauge("g", 5))
+	assert.Equal(t, uint64(4), b.Seq())
+}
@@ -0,0 +1,124 @@
+// Package subnet разбирает списки сетей CIDR и определяет реальный адрес клиента HTTP-запроса
+// с учетом доверенных прокси.
+package subnet
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIPHeader заголовок с адресом клиента: агент заполняет его адресом исходящего интерфейса,
+// прокси — адресом подключившегося к нему клиента.
+const RealIPHeader = "X-Real-IP"
+
+const forwardedForHeader = "X-Forwarded-For"
+
+// Set список сетей. Пустой список не содержит ни одного адреса.
+type Set []*net.IPNet
+
+// Parse разбирает сети CIDR, перечисленные через запятую. Одиночный адрес
+// без маски считается сетью из одного адреса. Пустая строка дает пустой список.
+func Parse(s string) (Set, error) {
+	var set Set
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address '%s'", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			set = append(set, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet '%s': %w", item, err)
+		}
+		set = append(set, n)
+	}
+	return set, nil
+}
+
+// Contains сообщает, входит ли адрес ip в одну из сетей.
+func (s Set) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range s {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// String возвращает сети через запятую в формате Parse.
+func (s Set) String() string {
+	items := make([]string, len(s))
+	for i, n := range s {
+		items[i] = n.String()
+	}
+	return strings.Join(items, ",")
+}
+
+// HostIP извлекает адрес из строки вида host:port или host.
+func HostIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.TrimSpace(addr))
+}
+
+// ClientIP возвращает реальный адрес клиента запроса r. Заголовки X-Forwarded-For и X-Real-IP
+// учитываются, только если соединение установлено с доверенного прокси из proxies:
+// X-Forwarded-For просматривается справа налево до первого адреса, не принадлежащего proxies.
+// Возвращает nil, если адрес не удалось определить.
+func ClientIP(r *http.Request, proxies Set) net.IP {
+	ip := HostIP(r.RemoteAddr)
+	if !proxies.Contains(ip) {
+		return ip
+	}
+	if fwd := r.Header.Values(forwardedForHeader); len(fwd) > 0 {
+		hops := strings.Split(strings.Join(fwd, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := HostIP(hops[i])
+			if hop == nil {
+				// искаженную цепочку левее нельзя проверить
+				return ip
+			}
+			ip = hop
+			if !proxies.Contains(hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if real := HostIP(r.Header.Get(RealIPHeader)); real != nil {
+		return real
+	}
+	return ip
+}
+
+// OutboundIP возвращает адрес локального интерфейса, через который идет маршрут к address
+// (host:port). Пакеты не отправляются: UDP-сокет только выбирает маршрут.
+func OutboundIP(address string) (net.IP, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("outbound address: %w", err)
+	}
+	defer conn.Close()
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, fmt.Errorf("outbound address: unexpected local address %v", conn.LocalAddr())
+	}
+	return addr.IP, nil
+}
@@ -0,0 +1,70 @@
+package subnet
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	set, err := Parse(" 10.0.0.0/8, 192.168.1.7 ,,fd00::/8")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/8,192.168.1.7/32,fd00::/8", set.String())
+	assert.True(t, set.Contains(net.ParseIP("10.1.2.3")))
+	assert.True(t, set.Contains(net.ParseIP("192.168.1.7")))
+	assert.False(t, set.Contains(net.ParseIP("192.168.1.8")))
+	assert.True(t, set.Contains(net.ParseIP("fd00::1")))
+	assert.False(t, set.Contains(nil))
+
+	set, err = Parse("")
+	require.NoError(t, err)
+	assert.Nil(t, set)
+	assert.False(t, set.Contains(net.ParseIP("127.0.0.1")))
+
+	_, err = Parse("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = Parse("localhost")
+	assert.Error(t, err)
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := Parse("10.0.0.0/24")
+	require.NoError(t, err)
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		want      string
+	}{
+		{"direct", "192.168.1.5:4000", "", "", "192.168.1.5"},
+		{"direct ignores headers", "192.168.1.5:4000", "172.16.0.1", "172.16.0.2", "192.168.1.5"},
+		{"proxy forwarded", "10.0.0.2:4000", "172.16.0.1", "", "172.16.0.1"},
+		{"proxy chain", "10.0.0.2:4000", "1.2.3.4, 172.16.0.1, 10.0.0.3", "", "172.16.0.1"},
+		{"proxy real ip", "10.0.0.2:4000", "", "172.16.0.2", "172.16.0.2"},
+		{"proxy without headers", "10.0.0.2:4000", "", "", "10.0.0.2"},
+		{"proxy malformed chain", "10.0.0.2:4000", "garbage, 10.0.0.3", "", "10.0.0.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set(forwardedForHeader, tt.forwarded)
+			}
+			if tt.realIP != "" {
+				r.Header.Set(RealIPHeader, tt.realIP)
+			}
+			assert.Equal(t, tt.want, ClientIP(r, proxies).String())
+		})
+	}
+}
+
+func TestOutboundIP(t *testing.T) {
+	ip, err := OutboundIP("127.0.0.1:8080")
+	require.NoError(t, err)
+	assert.True(t, ip.IsLoopback())
+}
@@ -0,0 +1,208 @@
+// Package tlsconfig готовит настройки TLS сервера и агента: сертификаты с горячей заменой,
+// проверку клиентских сертификатов (mTLS) и доверие только заданному удостоверяющему центру.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrNoCertificates = errors.New("no certificates found")
+
+// CertReloader пара сертификат и закрытый ключ, перечитываемая при изменении файлов.
+//
+// Время изменения файлов проверяется при каждом TLS-рукопожатии, поэтому обновленный
+// сертификат применяется без перезапуска. При ошибке чтения используется прежний сертификат.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// NewCertReloader загружает сертификат certFile и ключ keyFile в формате PEM.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload перечитывает сертификат и ключ. При ошибке сертификат не меняется.
+func (r *CertReloader) Reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate '%s': %w", r.certFile, err)
+	}
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate реализует tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current(), nil
+}
+
+// GetClientCertificate реализует tls.Config.GetClientCertificate.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.current(), nil
+}
+
+// current возвращает сертификат, предварительно перечитав его, если файлы изменились.
+func (r *CertReloader) current() *tls.Certificate {
+	r.mu.RLock()
+	cert, loaded := r.cert, r.modTime
+	r.mu.RUnlock()
+	if modTime, err := r.latestModTime(); err == nil && modTime.After(loaded) {
+		if err = r.Reload(); err != nil {
+			log.Printf("Error: [CertReloader] %v", err)
+			// следующая попытка — после нового изменения файлов, а не при каждом рукопожатии
+			r.mu.Lock()
+			r.modTime = modTime
+			r.mu.Unlock()
+			return cert
+		}
+		log.Printf("Reloaded certificate '%s'", r.certFile)
+		r.mu.RLock()
+		cert = r.cert
+		r.mu.RUnlock()
+	}
+	return cert
+}
+
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		stat, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("certificate: %w", err)
+		}
+		if stat.ModTime().After(latest) {
+			latest = stat.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// LoadCertPool читает сертификаты удостоверяющих центров из PEM-файла.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA bundle '%s': %w", path, ErrNoCertificates)
+	}
+	return pool, nil
+}
+
+// Server настройки TLS сервера.
+type Server struct {
+	cert     *CertReloader
+	clientCA string
+	mu       sync.RWMutex
+	pool     *x509.CertPool
+}
+
+// NewServer загружает сертификат сервера. Непустой clientCAFile включает обязательную проверку
+// клиентских сертификатов (mTLS) по этому набору удостоверяющих центров.
+func NewServer(certFile, keyFile, clientCAFile string) (*Server, error) {
+	cert, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{cert: cert, clientCA: clientCAFile}
+	if clientCAFile != "" {
+		if s.pool, err = LoadCertPool(clientCAFile); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// MutualTLS сообщает, проверяются ли клиентские сертификаты.
+func (s *Server) MutualTLS() bool {
+	return s.clientCA != ""
+}
+
+// Reload перечитывает сертификат сервера и набор удостоверяющих центров клиентов.
+// При ошибке продолжают использоваться прежние значения.
+func (s *Server) Reload() error {
+	if err := s.cert.Reload(); err != nil {
+		return err
+	}
+	if s.clientCA == "" {
+		return nil
+	}
+	pool, err := LoadCertPool(s.clientCA)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.pool = pool
+	s.mu.Unlock()
+	return nil
+}
+
+// Config возвращает tls.Config сервера. Сертификат и набор удостоверяющих центров
+// выбираются при каждом рукопожатии, поэтому Reload применяется к уже созданной конфигурации.
+func (s *Server) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: s.cert.GetCertificate,
+				NextProtos:     []string{"h2", "http/1.1"},
+			}
+			if s.clientCA != "" {
+				s.mu.RLock()
+				cfg.ClientCAs = s.pool
+				s.mu.RUnlock()
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// NewClient возвращает tls.Config агента. Непустой caFile задает единственный доверенный
+// удостоверяющий центр вместо системных (закрепление CA). Непустые certFile и keyFile задают
+// клиентский сертификат для mTLS, он перечитывается при изменении файлов.
+func NewClient(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("client certificate and key must be set together")
+		}
+		cert, err := NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = cert.GetClientCertificate
+	}
+	return cfg, nil
+}
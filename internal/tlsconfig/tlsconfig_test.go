@@ -0,0 +1,196 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// authority локальный удостоверяющий центр для тестов.
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newAuthority(t *testing.T, dir, name string) *authority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	file := filepath.Join(dir, name+".pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	return &authority{cert: cert, key: key, file: file}
+}
+
+// issue выпускает сертификат name и записывает его в <name>.crt и <name>.key.
+func (a *authority) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.cert, &key.PublicKey, a.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func startServer(t *testing.T, cfg *tls.Config) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Header().Set("X-Client", r.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+	}))
+	ts.TLS = cfg
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func get(t *testing.T, url string, cfg *tls.Config) (*http.Response, error) {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newAuthority(t, dir, "ca")
+	other := newAuthority(t, dir, "other-ca")
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	agentCert, agentKey := ca.issue(t, dir, "agent", x509.ExtKeyUsageClientAuth)
+	strangerCert, strangerKey := other.issue(t, dir, "stranger", x509.ExtKeyUsageClientAuth)
+
+	srv, err := NewServer(serverCert, serverKey, ca.file)
+	require.NoError(t, err)
+	assert.True(t, srv.MutualTLS())
+	ts := startServer(t, srv.Config())
+
+	tests := []struct {
+		name     string
+		ca       string
+		cert     string
+		key      string
+		wantErr  bool
+		wantPeer string
+	}{
+		{name: "client certificate", ca: ca.file, cert: agentCert, key: agentKey, wantPeer: "agent"},
+		{name: "no client certificate", ca: ca.file, wantErr: true},
+		{name: "certificate of other CA", ca: ca.file, cert: strangerCert, key: strangerKey, wantErr: true},
+		{name: "pinned to other CA", ca: other.file, cert: agentCert, key: agentKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := NewClient(tt.ca, tt.cert, tt.key)
+			require.NoError(t, err)
+			resp, err := get(t, ts.URL, cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPeer, resp.Header.Get("X-Client"))
+		})
+	}
+}
+
+func TestServer_certificateReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newAuthority(t, dir, "ca")
+	certFile, keyFile := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	srv, err := NewServer(certFile, keyFile, "")
+	require.NoError(t, err)
+	assert.False(t, srv.MutualTLS())
+	ts := startServer(t, srv.Config())
+	client, err := NewClient(ca.file, "", "")
+	require.NoError(t, err)
+
+	resp, err := get(t, ts.URL, client)
+	require.NoError(t, err)
+	first := resp.TLS.PeerCertificates[0].SerialNumber
+
+	// новый сертификат подхватывается при следующем рукопожатии без перезапуска сервера
+	renewedCert, renewedKey := ca.issue(t, t.TempDir(), "server", x509.ExtKeyUsageServerAuth)
+	for src, dst := range map[string]string{renewedCert: certFile, renewedKey: keyFile} {
+		data, rerr := os.ReadFile(src)
+		require.NoError(t, rerr)
+		require.NoError(t, os.WriteFile(dst, data, 0o600))
+		future := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(dst, future, future))
+	}
+	resp, err = get(t, ts.URL, client)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, resp.TLS.PeerCertificates[0].SerialNumber)
+
+	// поврежденный файл не заменяет действующий сертификат
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	assert.Error(t, srv.Reload())
+	_, err = get(t, ts.URL, client)
+	assert.NoError(t, err)
+}
+
+func TestNew_errors(t *testing.T) {
+	dir := t.TempDir()
+	ca := newAuthority(t, dir, "ca")
+	certFile, keyFile := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(empty, nil, 0o600))
+
+	_, err := NewServer(certFile, filepath.Join(dir, "none.key"), "")
+	assert.Error(t, err)
+	_, err = NewServer(certFile, keyFile, empty)
+	assert.ErrorIs(t, err, ErrNoCertificates)
+	_, err = NewClient(empty, "", "")
+	assert.ErrorIs(t, err, ErrNoCertificates)
+	_, err = NewClient(ca.file, certFile, "")
+	assert.Error(t, err)
+}